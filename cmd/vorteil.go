@@ -8,6 +8,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/vorteil/vinitd/pkg/vorteil"
 	"golang.org/x/sys/unix"
@@ -61,6 +62,21 @@ func main() {
 		return
 	}
 
+	// helper to check the boot disk from a rescue shell
+	if filepath.Base(os.Args[0]) == filepath.Base(vorteil.AppFsck) {
+		os.Exit(vorteil.RunFsck(os.Args[1:]))
+	}
+
+	// init of programs in their own pid namespace
+	if filepath.Base(os.Args[0]) == vorteil.AppShim {
+		os.Exit(vorteil.RunShim(os.Args[1:]))
+	}
+
+	// applies process settings before executing a program
+	if filepath.Base(os.Args[0]) == vorteil.AppExec {
+		os.Exit(vorteil.RunExec(os.Args[1:]))
+	}
+
 	vinitd = vorteil.New(vorteil.LogFnKernel)
 
 	ss := []seq{
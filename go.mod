@@ -8,7 +8,6 @@ replace github.com/Asphaltt/dnsproxy-go => ./build/dnsproxy-go
 
 require (
 	github.com/Asphaltt/dnsproxy-go v0.0.0-20181028064240-4c302a933bd0
-	github.com/hashicorp/go-reap v0.0.0-20170704170343-bf58d8a43e7b
 	github.com/insomniacslk/dhcp v0.0.0-20200601194411-4b5a011e0a4c
 	github.com/miekg/dns v1.1.31 // indirect
 	github.com/mitchellh/go-ps v1.0.0
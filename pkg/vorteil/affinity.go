@@ -0,0 +1,102 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const cpuOnlineFile = "/sys/devices/system/cpu/online"
+
+// parseCPUList reads a list of cpus like 0,2-3
+func parseCPUList(value string) ([]int, error) {
+
+	var cpus []int
+
+	for _, r := range strings.Split(strings.TrimSpace(value), ",") {
+
+		se := strings.SplitN(r, "-", 2)
+
+		start, err := strconv.Atoi(se[0])
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid cpu %s", se[0])
+		}
+
+		end := start
+		if len(se) == 2 {
+			end, err = strconv.Atoi(se[1])
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpu range %s", r)
+			}
+		}
+
+		for c := start; c <= end; c++ {
+			cpus = append(cpus, c)
+		}
+
+	}
+
+	return cpus, nil
+}
+
+// onlineCPUs filters the cpus to those online
+func onlineCPUs(cpus []int) []int {
+
+	o, err := ioutil.ReadFile(cpuOnlineFile)
+	if err != nil {
+		return cpus
+	}
+
+	online, err := parseCPUList(string(o))
+	if err != nil {
+		return cpus
+	}
+
+	isOnline := make(map[int]bool)
+	for _, c := range online {
+		isOnline[c] = true
+	}
+
+	var valid []int
+	for _, c := range cpus {
+		if !isOnline[c] {
+			logWarn("cpu %d is not online, ignoring it for affinity", c)
+			continue
+		}
+		valid = append(valid, c)
+	}
+
+	return valid
+}
+
+// setAffinity pins the process to the cpus which are online
+func setAffinity(pid int, cpus []int) error {
+
+	cpus = onlineCPUs(cpus)
+	if len(cpus) == 0 {
+		logWarn("no requested cpu online, not setting affinity of %d", pid)
+		return nil
+	}
+
+	var set unix.CPUSet
+	for _, c := range cpus {
+		set.Set(c)
+	}
+
+	err := unix.SchedSetaffinity(pid, &set)
+	if err != nil {
+		return err
+	}
+
+	logDebug("pinned %d to cpus %v", pid, cpus)
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package vorteil
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestCPUAffinity(t *testing.T) {
+
+	New(testLogFn)
+
+	cpus, err := parseCPUList("0,2-4,7")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2, 3, 4, 7}, cpus)
+
+	_, err = parseCPUList("3-1")
+	assert.Error(t, err)
+	_, err = parseCPUList("a")
+	assert.Error(t, err)
+
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	// cpus which are not online are ignored
+	assert.NoError(t, setAffinity(cmd.Process.Pid, []int{0, 4096}))
+
+	var set unix.CPUSet
+	assert.NoError(t, unix.SchedGetaffinity(cmd.Process.Pid, &set))
+	assert.Equal(t, 1, set.Count())
+	assert.True(t, set.IsSet(0))
+
+}
@@ -0,0 +1,147 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// missing in x/sys
+const capCheckpointRestore = 40
+
+var capabilityNames = map[string]int{
+	"CAP_CHOWN":              unix.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":             unix.CAP_FOWNER,
+	"CAP_FSETID":             unix.CAP_FSETID,
+	"CAP_KILL":               unix.CAP_KILL,
+	"CAP_SETGID":             unix.CAP_SETGID,
+	"CAP_SETUID":             unix.CAP_SETUID,
+	"CAP_SETPCAP":            unix.CAP_SETPCAP,
+	"CAP_LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+	"CAP_NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+	"CAP_NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+	"CAP_NET_ADMIN":          unix.CAP_NET_ADMIN,
+	"CAP_NET_RAW":            unix.CAP_NET_RAW,
+	"CAP_IPC_LOCK":           unix.CAP_IPC_LOCK,
+	"CAP_IPC_OWNER":          unix.CAP_IPC_OWNER,
+	"CAP_SYS_MODULE":         unix.CAP_SYS_MODULE,
+	"CAP_SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+	"CAP_SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+	"CAP_SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+	"CAP_SYS_PACCT":          unix.CAP_SYS_PACCT,
+	"CAP_SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+	"CAP_SYS_BOOT":           unix.CAP_SYS_BOOT,
+	"CAP_SYS_NICE":           unix.CAP_SYS_NICE,
+	"CAP_SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+	"CAP_SYS_TIME":           unix.CAP_SYS_TIME,
+	"CAP_SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+	"CAP_MKNOD":              unix.CAP_MKNOD,
+	"CAP_LEASE":              unix.CAP_LEASE,
+	"CAP_AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+	"CAP_AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+	"CAP_SETFCAP":            unix.CAP_SETFCAP,
+	"CAP_MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+	"CAP_MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+	"CAP_SYSLOG":             unix.CAP_SYSLOG,
+	"CAP_WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+	"CAP_BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+	"CAP_AUDIT_READ":         unix.CAP_AUDIT_READ,
+	"CAP_PERFMON":            unix.CAP_PERFMON,
+	"CAP_BPF":                unix.CAP_BPF,
+	"CAP_CHECKPOINT_RESTORE": capCheckpointRestore,
+}
+
+// parseCapabilities reads a comma separated list of capabilities, the CAP_
+// prefix is optional. "none" drops all capabilities.
+func parseCapabilities(value string) ([]int, error) {
+
+	caps := []int{}
+
+	if strings.ToLower(value) == "none" {
+		return caps, nil
+	}
+
+	for _, n := range strings.Split(value, ",") {
+
+		n = strings.ToUpper(strings.TrimSpace(n))
+		if !strings.HasPrefix(n, "CAP_") {
+			n = fmt.Sprintf("CAP_%s", n)
+		}
+
+		c, ok := capabilityNames[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown capability %s", n)
+		}
+
+		caps = append(caps, c)
+	}
+
+	return caps, nil
+}
+
+// allowedAmbient returns the ambient capabilities which are in the allowed
+// list
+func allowedAmbient(ambient []uintptr, allowed []int) []uintptr {
+
+	var caps []uintptr
+	for _, a := range ambient {
+		for _, c := range allowed {
+			if int(a) == c {
+				caps = append(caps, a)
+				break
+			}
+		}
+	}
+
+	return caps
+}
+
+// dropCapabilities removes all but the allowed capabilities from the bounding
+// and inheritable set, so they are not available after exec. This needs
+// CAP_SETPCAP.
+func dropCapabilities(allowed []int) error {
+
+	keep := make(map[int]bool)
+	for _, c := range allowed {
+		keep[c] = true
+	}
+
+	// the kernel reports invalid capabilities after the last one
+	for c := 0; c < 64; c++ {
+		if keep[c] {
+			continue
+		}
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if err == unix.EINVAL {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("can not drop capability %d: %s", c, err.Error())
+		}
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+
+	err := unix.Capget(&hdr, &data[0])
+	if err != nil {
+		return err
+	}
+
+	var mask [2]uint32
+	for c := range keep {
+		mask[c/32] |= 1 << uint(c%32)
+	}
+	data[0].Inheritable &= mask[0]
+	data[1].Inheritable &= mask[1]
+
+	return unix.Capset(&hdr, &data[0])
+}
@@ -0,0 +1,53 @@
+package vorteil
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestCapabilities(t *testing.T) {
+
+	New(testLogFn)
+
+	caps, err := parseCapabilities("CAP_NET_BIND_SERVICE, chown")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{unix.CAP_NET_BIND_SERVICE, unix.CAP_CHOWN}, caps)
+
+	caps, err = parseCapabilities("none")
+	assert.NoError(t, err)
+	assert.NotNil(t, caps)
+	assert.Empty(t, caps)
+
+	_, err = parseCapabilities("CAP_FLY")
+	assert.Error(t, err)
+
+	assert.Equal(t, []uintptr{unix.CAP_CHOWN}, allowedAmbient([]uintptr{unix.CAP_CHOWN, unix.CAP_SYS_ADMIN},
+		[]int{unix.CAP_CHOWN, unix.CAP_NET_RAW}))
+
+	if os.Getuid() != 0 {
+		t.Skip("dropping capabilities needs root")
+	}
+
+	// the test binary is the wrapper
+	execWrapper = os.Args[0]
+	defer func() {
+		execWrapper = vinitdApp
+	}()
+
+	cmd := exec.Command("sh", "-c", "grep CapEff /proc/self/status")
+	assert.NoError(t, wrapExec(cmd, execSetup{
+		DropCapabilities: true,
+		Capabilities:     []int{unix.CAP_NET_BIND_SERVICE, unix.CAP_CHOWN},
+	}))
+	cmd.Args = append([]string{cmd.Args[0], "-test.run=^TestExecHelper$", "--"}, cmd.Args[1:]...)
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"CapEff:", "0000000000000401"}, strings.Fields(string(out)))
+
+}
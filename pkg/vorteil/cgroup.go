@@ -0,0 +1,116 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// cgroup with the programs' groups as children
+const cgroupParent = "vinitd"
+
+var (
+	// cgroup v2 mount, replaced in tests
+	cgroupRoot = "/sys/fs/cgroup"
+
+	// bytes with optional K, M or G suffix as accepted by the kernel
+	memoryMaxRegex = regexp.MustCompile(`^([0-9]+[KkMmGg]?|max)$`)
+)
+
+func parseMemoryMax(value string) (string, error) {
+	if !memoryMaxRegex.MatchString(value) {
+		return "", fmt.Errorf("invalid memory limit %s", value)
+	}
+	return value, nil
+}
+
+// cgroupMemory reports if the root is a cgroup v2 mount with the memory
+// controller available
+func cgroupMemory(root string) bool {
+
+	c, err := ioutil.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return false
+	}
+
+	for _, f := range strings.Fields(string(c)) {
+		if f == "memory" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+	if err != nil {
+		return fmt.Errorf("can not write %s: %s", file, err.Error())
+	}
+	return nil
+}
+
+// setupCgroup moves the process into its own cgroup with the memory limit.
+// It returns the cgroup directory or an empty string if cgroup v2 is not
+// available.
+func setupCgroup(name string, pid int, memoryMax string) (string, error) {
+
+	if !cgroupMemory(cgroupRoot) {
+		logWarn("cgroup v2 memory controller not available, not limiting memory of %s", name)
+		return "", nil
+	}
+
+	parent := filepath.Join(cgroupRoot, cgroupParent)
+
+	// the controller has to be enabled for the children on each level
+	err := writeCgroupFile(cgroupRoot, "cgroup.subtree_control", "+memory")
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeCgroupFile(parent, "cgroup.subtree_control", "+memory")
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(parent, fmt.Sprintf("%s-%d", strings.Replace(name, "/", "_", -1), pid))
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeCgroupFile(dir, "memory.max", memoryMax)
+	if err == nil {
+		err = writeCgroupFile(dir, "cgroup.procs", fmt.Sprintf("%d", pid))
+	}
+	if err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+
+	logDebug("memory of %s limited to %s", name, memoryMax)
+
+	return dir, nil
+}
+
+// removeCgroup removes the cgroup of an exited program. It fails if
+// processes forked by the program are still running.
+func removeCgroup(dir string) {
+	err := os.Remove(dir)
+	if err != nil {
+		logDebug("can not remove cgroup %s: %s", dir, err.Error())
+	}
+}
@@ -0,0 +1,59 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupCgroup(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "cgroup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cgroupRoot = dir
+	defer func() {
+		cgroupRoot = "/sys/fs/cgroup"
+	}()
+
+	// no cgroup v2, skipped
+	cg, err := setupCgroup("app", 123, "64M")
+	assert.NoError(t, err)
+	assert.Equal(t, "", cg)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpu io memory pids\n"), 0644))
+
+	cg, err = setupCgroup("app", 123, "64M")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, cgroupParent, "app-123"), cg)
+
+	for f, v := range map[string]string{
+		filepath.Join(dir, "cgroup.subtree_control"):               "+memory",
+		filepath.Join(dir, cgroupParent, "cgroup.subtree_control"): "+memory",
+		filepath.Join(cg, "memory.max"):                            "64M",
+		filepath.Join(cg, "cgroup.procs"):                          "123",
+	} {
+		b, err := ioutil.ReadFile(f)
+		assert.NoError(t, err)
+		assert.Equal(t, v, string(b))
+	}
+
+	// the kernel removes the files of a cgroup with the directory
+	os.Remove(filepath.Join(cg, "memory.max"))
+	os.Remove(filepath.Join(cg, "cgroup.procs"))
+	removeCgroup(cg)
+	_, err = os.Stat(cg)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = parseMemoryMax("1g")
+	assert.NoError(t, err)
+	_, err = parseMemoryMax("1GB")
+	assert.Error(t, err)
+
+}
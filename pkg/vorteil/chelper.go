@@ -13,18 +13,17 @@ import "C"
 import (
 	"fmt"
 	"net"
-	"syscall"
 	"unsafe"
 )
 
 //export RebootForTools
 func RebootForTools() {
-	shutdown(syscall.LINUX_REBOOT_CMD_RESTART, 0)
+	Reboot("requested by vm tools")
 }
 
 //export ShutdownForTools
 func ShutdownForTools() {
-	shutdown(syscall.LINUX_REBOOT_CMD_POWER_OFF, 0)
+	Poweroff("requested by vm tools")
 }
 
 //export UptimeForTools
@@ -43,21 +42,23 @@ func startVMTools(cards int, hostname string) {
 
 func addNetworkRoute4(dst, mask, gw net.IP, dev string, flags int) error {
 
-	var dstNwOrder, maskNwOrder, gwNwOrder int
+	var nwOrder [3]int
 
-	direct := C.CString(dev)
-	defer C.free(unsafe.Pointer(direct))
-
-	nw := func(v net.IP) int {
-		if v != nil {
-			return int(ip2networkInt(v))
+	for i, v := range []net.IP{dst, mask, gw} {
+		if v == nil {
+			continue
+		}
+		n, err := ip2networkInt(v)
+		if err != nil {
+			return fmt.Errorf("can not add route for %s: %s", dev, err.Error())
 		}
-		return 0
+		nwOrder[i] = int(n)
 	}
 
-	dstNwOrder = nw(dst)
-	maskNwOrder = nw(mask)
-	gwNwOrder = nw(gw)
+	dstNwOrder, maskNwOrder, gwNwOrder := nwOrder[0], nwOrder[1], nwOrder[2]
+
+	direct := C.CString(dev)
+	defer C.free(unsafe.Pointer(direct))
 
 	err := C.helper_add_route(C.int(dstNwOrder),
 		C.int(maskNwOrder), C.int(gwNwOrder), direct, C.int(flags))
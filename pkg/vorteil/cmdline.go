@@ -0,0 +1,565 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cmdlineFile   = "/proc/cmdline"
+	cmdlinePrefix = "vinitd."
+
+	noProgramsPoweroff = "poweroff"
+	noProgramsHold     = "hold"
+
+	lastExitPoweroff    = "poweroff"
+	lastExitReboot      = "reboot"
+	lastExitHalt        = "halt"
+	lastExitKeepRunning = "keep-running"
+)
+
+// kernelOptions are the vinitd.* settings from the kernel command line
+type kernelOptions struct {
+	logLevel  LogLevel
+	logFormat string
+	logBuffer int
+	machineID string
+
+	// timestamp in front of messages on the screen and level colors
+	logTimestamp string
+	logColor     bool
+
+	// remote syslog collector, network and address
+	syslogNetwork string
+	syslogAddr    string
+
+	// serial device messages are mirrored to
+	serialLog string
+
+	// messages written to the boot disk
+	diskLog bool
+
+	// launch throttling, 0 is unlimited / disabled
+	launchConcurrency int
+	launchPressure    float64
+
+	readOnlyRoot bool
+
+	// writable directories with their changes in memory
+	overlays []string
+
+	// filesystem check of the boot disk before it is mounted
+	fsck string
+
+	// grow the root partition and filesystem to the size of the disk
+	growRoot bool
+
+	// kernel booted directly on reboot
+	kexec *kexecConfig
+
+	// seconds after launch exits of unregistered processes are ignored
+	registerGrace int
+
+	// seconds until the shutdown gets forced, 0 waits forever
+	shutdownTimeout int
+
+	// seconds counted down before the reboot, quiet without messages
+	shutdownCountdown int
+	countdownQuiet    bool
+
+	// restarts of all programs allowed in the window, 0 is unlimited
+	restartLimit  int
+	restartWindow int
+	restartAction string
+
+	// size limited tmpfs mounts, e.g. /tmp
+	tmpfs       []tmpfsMount
+	tmpfsInodes int
+
+	// storage assembled before programs are launched
+	mdArrays      []mdArray
+	volumeGroups  []string
+	deviceTimeout int
+
+	// swap partition or file
+	swap *swapConfig
+
+	// static ipv6 addresses
+	ipv6 []ipv6Config
+
+	// when programs needing the network are started
+	networkReady   string
+	networkTimeout int
+
+	// routes in addition to the configured ones
+	routes []staticRoute
+
+	// search domains in resolv.conf
+	dnsSearch []string
+
+	// public key to verify program signatures
+	signingKey string
+
+	// listen address of the prometheus metrics endpoint, off if empty
+	metricsAddr string
+
+	// control socket clients at a time and seconds idle clients are kept
+	controlClients int
+	controlIdle    int
+
+	// environment file for all programs, empty if disabled
+	envFile string
+
+	// script installing the busybox shell in post-setup
+	busyboxScript string
+
+	// interactive shell on the console if the boot fails
+	rescue bool
+
+	// action if no programs are configured
+	noPrograms string
+
+	// action once the last program has exited
+	onLastExit string
+
+	// signals passed on to the programs
+	forwardSignals []syscall.Signal
+
+	// program name in front of program output on the screen
+	outputPrefix string
+}
+
+type optionParser func(o *kernelOptions, value string) error
+
+var (
+	kernelOpts = defaultKernelOptions()
+
+	kernelOptionParsers = map[string]optionParser{
+		"vinitd.loglevel": func(o *kernelOptions, value string) (err error) {
+			o.logLevel, err = parseLogLevel(value)
+			return err
+		},
+		"vinitd.log-format": func(o *kernelOptions, value string) (err error) {
+			o.logFormat, err = oneOf(value, logFormatText, logFormatJSON)
+			return err
+		},
+		"vinitd.log-buffer": func(o *kernelOptions, value string) (err error) {
+			o.logBuffer, err = positiveInt(value)
+			return err
+		},
+		"vinitd.log-timestamp": func(o *kernelOptions, value string) (err error) {
+			o.logTimestamp, err = oneOf(value, timestampUptime, timestampWall, timestampBoth)
+			return err
+		},
+		"vinitd.log-color": func(o *kernelOptions, value string) (err error) {
+			o.logColor, err = boolean(value)
+			return err
+		},
+		"vinitd.syslog": func(o *kernelOptions, value string) (err error) {
+			o.syslogNetwork, o.syslogAddr, err = parseSyslogAddr(value)
+			return err
+		},
+		"vinitd.log-serial": func(o *kernelOptions, value string) error {
+			o.serialLog = value
+			return nil
+		},
+		"vinitd.log-disk": func(o *kernelOptions, value string) (err error) {
+			o.diskLog, err = boolean(value)
+			return err
+		},
+		"vinitd.machine-id": func(o *kernelOptions, value string) (err error) {
+			o.machineID, err = oneOf(value, machineIDDMI, machineIDRandom, machineIDHostname)
+			return err
+		},
+		"vinitd.launch-concurrency": func(o *kernelOptions, value string) (err error) {
+			o.launchConcurrency, err = positiveInt(value)
+			return err
+		},
+		"vinitd.launch-pressure": func(o *kernelOptions, value string) (err error) {
+			o.launchPressure, err = percent(value)
+			return err
+		},
+		"vinitd.register-grace": func(o *kernelOptions, value string) (err error) {
+			o.registerGrace, err = positiveInt(value)
+			return err
+		},
+		"vinitd.shutdown-timeout": func(o *kernelOptions, value string) (err error) {
+			o.shutdownTimeout, err = positiveInt(value)
+			return err
+		},
+		"vinitd.shutdown-countdown": func(o *kernelOptions, value string) (err error) {
+			o.shutdownCountdown, err = positiveInt(value)
+			return err
+		},
+		"vinitd.shutdown-countdown-quiet": func(o *kernelOptions, value string) (err error) {
+			o.countdownQuiet, err = boolean(value)
+			return err
+		},
+		"vinitd.restart-limit": func(o *kernelOptions, value string) (err error) {
+			o.restartLimit, err = positiveInt(value)
+			return err
+		},
+		"vinitd.restart-window": func(o *kernelOptions, value string) (err error) {
+			o.restartWindow, err = positiveInt(value)
+			return err
+		},
+		"vinitd.restart-action": func(o *kernelOptions, value string) (err error) {
+			o.restartAction, err = oneOf(value, restartActionPanic, restartActionPoweroff)
+			return err
+		},
+		"vinitd.tmpfs": func(o *kernelOptions, value string) (err error) {
+			o.tmpfs, err = parseTmpfsMounts(value)
+			return err
+		},
+		"vinitd.tmpfs-inodes": func(o *kernelOptions, value string) (err error) {
+			o.tmpfsInodes, err = positiveInt(value)
+			return err
+		},
+		"vinitd.md": func(o *kernelOptions, value string) (err error) {
+			o.mdArrays, err = parseMDArrays(value)
+			return err
+		},
+		"vinitd.lvm": func(o *kernelOptions, value string) (err error) {
+			o.volumeGroups, err = parseVolumeGroups(value)
+			return err
+		},
+		"vinitd.device-timeout": func(o *kernelOptions, value string) (err error) {
+			o.deviceTimeout, err = positiveInt(value)
+			return err
+		},
+		"vinitd.swap": func(o *kernelOptions, value string) (err error) {
+			o.swap, err = parseSwap(value)
+			return err
+		},
+		"vinitd.ipv6": func(o *kernelOptions, value string) (err error) {
+			o.ipv6, err = parseIPv6Configs(value)
+			return err
+		},
+		"vinitd.network-ready": func(o *kernelOptions, value string) (err error) {
+			o.networkReady, err = oneOf(value, networkReadyAddress, networkReadyRoute)
+			return err
+		},
+		"vinitd.network-timeout": func(o *kernelOptions, value string) (err error) {
+			o.networkTimeout, err = positiveInt(value)
+			return err
+		},
+		"vinitd.routes": func(o *kernelOptions, value string) (err error) {
+			o.routes, err = parseRoutes(value)
+			return err
+		},
+		"vinitd.dns-search": func(o *kernelOptions, value string) error {
+			o.dnsSearch = strings.FieldsFunc(value, func(r rune) bool { return r == ',' })
+			return nil
+		},
+		"vinitd.signing-key": func(o *kernelOptions, value string) error {
+			o.signingKey = value
+			return nil
+		},
+		"vinitd.no-programs": func(o *kernelOptions, value string) (err error) {
+			o.noPrograms, err = oneOf(value, noProgramsPoweroff, noProgramsHold)
+			return err
+		},
+		"vinitd.on-last-exit": func(o *kernelOptions, value string) (err error) {
+			o.onLastExit, err = oneOf(value, lastExitPoweroff, lastExitReboot, lastExitHalt, lastExitKeepRunning)
+			return err
+		},
+		"vinitd.forward-signals": func(o *kernelOptions, value string) (err error) {
+			o.forwardSignals, err = parseSignals(value)
+			return err
+		},
+		"vinitd.output-prefix": func(o *kernelOptions, value string) (err error) {
+			o.outputPrefix, err = oneOf(value, outputPrefixOff, outputPrefixName, outputPrefixColor)
+			return err
+		},
+		"vinitd.readonly-root": func(o *kernelOptions, value string) (err error) {
+			o.readOnlyRoot, err = boolean(value)
+			return err
+		},
+		"vinitd.overlay": func(o *kernelOptions, value string) (err error) {
+			o.overlays, err = parseOverlays(value)
+			return err
+		},
+		"vinitd.grow-root": func(o *kernelOptions, value string) (err error) {
+			o.growRoot, err = boolean(value)
+			return err
+		},
+		"vinitd.kexec": func(o *kernelOptions, value string) (err error) {
+			o.kexec, err = parseKexec(value)
+			return err
+		},
+		"vinitd.metrics": func(o *kernelOptions, value string) (err error) {
+			o.metricsAddr, err = parseMetricsAddr(value)
+			return err
+		},
+		"vinitd.control-clients": func(o *kernelOptions, value string) (err error) {
+			o.controlClients, err = nonZeroInt(value)
+			return err
+		},
+		"vinitd.control-idle": func(o *kernelOptions, value string) (err error) {
+			o.controlIdle, err = nonZeroInt(value)
+			return err
+		},
+		"vinitd.env-file": func(o *kernelOptions, value string) error {
+			if value != "" && !filepath.IsAbs(value) {
+				return fmt.Errorf("environment file '%s' is not absolute", value)
+			}
+			o.envFile = value
+			return nil
+		},
+		"vinitd.busybox-script": func(o *kernelOptions, value string) error {
+			if !filepath.IsAbs(value) {
+				return fmt.Errorf("busybox script '%s' is not absolute", value)
+			}
+			o.busyboxScript = filepath.Clean(value)
+			return nil
+		},
+		"vinitd.rescue": func(o *kernelOptions, value string) (err error) {
+			o.rescue, err = boolean(value)
+			return err
+		},
+		"vinitd.fsck": func(o *kernelOptions, value string) (err error) {
+			o.fsck, err = oneOf(value, fsckModeOff, fsckModeCheck, fsckModeRepair, fsckModePanic)
+			return err
+		},
+	}
+)
+
+func defaultKernelOptions() kernelOptions {
+	return kernelOptions{
+		logLevel:          LogLvDEBUG,
+		logFormat:         logFormatText,
+		logBuffer:         defaultLogBuffer,
+		logTimestamp:      timestampUptime,
+		logColor:          true,
+		machineID:         machineIDDMI,
+		registerGrace:     10,
+		shutdownTimeout:   90,
+		shutdownCountdown: 3,
+		restartLimit:      20,
+		restartWindow:     300,
+		restartAction:     restartActionPanic,
+		deviceTimeout:     30,
+		fsck:              fsckModeOff,
+		growRoot:          true,
+		busyboxScript:     busboxScript,
+		envFile:           defaultEnvFile,
+		networkReady:      networkReadyAddress,
+		networkTimeout:    30,
+		controlClients:    8,
+		controlIdle:       60,
+		noPrograms:        noProgramsPoweroff,
+		onLastExit:        lastExitPoweroff,
+		forwardSignals:    []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2},
+		outputPrefix:      outputPrefixOff,
+	}
+}
+
+func oneOf(value string, values ...string) (string, error) {
+	for _, v := range values {
+		if value == v {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("value '%s' not one of %s", value, strings.Join(values, ", "))
+}
+
+// boolean accepts flags without value as true
+func boolean(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "1", "true", "yes", "on":
+		return true, nil
+	case "0", "false", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("value '%s' is not a boolean", value)
+}
+
+// parseSignal reads a signal name with or without SIG prefix, e.g. USR1
+func parseSignal(name string) (syscall.Signal, error) {
+
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = fmt.Sprintf("SIG%s", name)
+	}
+
+	s := unix.SignalNum(name)
+	if s == 0 {
+		return 0, fmt.Errorf("unknown signal %s", name)
+	}
+
+	return s, nil
+}
+
+// parseSignals reads a comma separated list of signal names, e.g. USR1.
+// Signals vinitd uses itself to shut down or reload can not be forwarded.
+func parseSignals(value string) ([]syscall.Signal, error) {
+
+	var sigs []syscall.Signal
+
+	if value == "" {
+		return sigs, nil
+	}
+
+	for _, n := range strings.Split(value, ",") {
+
+		s, err := parseSignal(n)
+		if err != nil {
+			return nil, err
+		}
+
+		switch s {
+		case syscall.SIGKILL, syscall.SIGSTOP, syscall.SIGINT, syscall.SIGTERM,
+			syscall.SIGPWR, syscall.SIGCHLD, syscall.SIGHUP:
+			return nil, fmt.Errorf("signal %s can not be forwarded", unix.SignalName(s))
+		}
+
+		sigs = append(sigs, s)
+	}
+
+	return sigs, nil
+}
+
+func positiveInt(value string) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("value '%s' is not a positive number", value)
+	}
+	return i, nil
+}
+
+func nonZeroInt(value string) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		return 0, fmt.Errorf("value '%s' is not greater than 0", value)
+	}
+	return i, nil
+}
+
+func percent(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || f > 100 {
+		return 0, fmt.Errorf("value '%s' is not a percentage", value)
+	}
+	return f, nil
+}
+
+// splitCmdline splits the command line at spaces like the kernel does.
+// Double quotes group spaces into one argument and are removed, e.g.
+// vinitd.x="a b" is vinitd.x=a b.
+func splitCmdline(cmdline string) []string {
+
+	var (
+		args   []string
+		arg    strings.Builder
+		quoted bool
+		inArg  bool
+	)
+
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args
+}
+
+// parseKernelOptions reads all vinitd.* arguments. Unknown keys are ignored
+// and logged. Keys without a value are flags with an empty value. The last of
+// repeated keys wins. Keys with invalid values keep the default and are
+// reported in the returned error.
+func parseKernelOptions(cmdline string) (kernelOptions, error) {
+
+	var (
+		errs []string
+		seen = make(map[string]bool)
+	)
+
+	o := defaultKernelOptions()
+
+	for _, f := range splitCmdline(cmdline) {
+
+		if !strings.HasPrefix(f, cmdlinePrefix) {
+			continue
+		}
+
+		kv := strings.SplitN(f, "=", 2)
+		key := kv[0]
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+
+		parser, ok := kernelOptionParsers[key]
+		if !ok {
+			logDebug("unknown kernel argument %s", key)
+			continue
+		}
+
+		if seen[key] {
+			logWarn("kernel argument %s set multiple times, using last value", key)
+		}
+		seen[key] = true
+
+		// only apply if the value is valid
+		no := o
+		if err := parser(&no, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", key, err.Error()))
+			continue
+		}
+		o = no
+
+	}
+
+	if len(errs) > 0 {
+		return o, fmt.Errorf("invalid kernel arguments: %s", strings.Join(errs, "; "))
+	}
+
+	return o, nil
+}
+
+// setupKernelOptions reads the kernel command line into kernelOpts. Invalid
+// values are logged and the defaults used.
+func setupKernelOptions() {
+
+	cmd, err := ioutil.ReadFile(cmdlineFile)
+	if err != nil {
+		logWarn("can not read kernel arguments: %s", err.Error())
+		return
+	}
+
+	kernelOpts, err = parseKernelOptions(string(cmd))
+	if err != nil {
+		logError("%s", err.Error())
+	}
+
+	setupLogging()
+	logDebug("log level %s", logLevelNames[logLevel])
+
+}
@@ -0,0 +1,117 @@
+package vorteil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKernelOptions(t *testing.T) {
+
+	New(testLogFn)
+
+	o, err := parseKernelOptions("console=ttyS0 vinitd.loglevel=warning vinitd.machine-id=random quiet")
+	assert.NoError(t, err)
+	assert.Equal(t, LogLevel(LogLvWARNING), o.logLevel)
+	assert.Equal(t, machineIDRandom, o.machineID)
+
+	// defaults
+	o, err = parseKernelOptions("console=ttyS0")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultKernelOptions(), o)
+
+	// quoted values keep their spaces
+	assert.Equal(t, []string{"console=ttyS0", "vinitd.x=a b", "quiet"},
+		splitCmdline(" console=ttyS0  vinitd.x=\"a b\"\tquiet\n"))
+	assert.Equal(t, []string{"vinitd.x=", "y"}, splitCmdline(`vinitd.x="" y`))
+
+	o, err = parseKernelOptions(`vinitd.dns-search="a.com,b.com" vinitd.output-prefix="name"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.com", "b.com"}, o.dnsSearch)
+	assert.Equal(t, outputPrefixName, o.outputPrefix)
+
+	// unknown keys are ignored
+	o, err = parseKernelOptions("vinitd.unknown=1 vinitd.loglevel=info")
+	assert.NoError(t, err)
+	assert.Equal(t, LogLevel(LogLvINFO), o.logLevel)
+
+	// last duplicate wins
+	o, err = parseKernelOptions("vinitd.loglevel=info vinitd.loglevel=error")
+	assert.NoError(t, err)
+	assert.Equal(t, LogLevel(LogLvERR), o.logLevel)
+
+	// bad values keep the default and name the key
+	o, err = parseKernelOptions("vinitd.loglevel=loud vinitd.machine-id=hostname")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "vinitd.loglevel")
+	assert.Equal(t, LogLevel(LogLvDEBUG), o.logLevel)
+	assert.Equal(t, machineIDHostname, o.machineID)
+
+	o, err = parseKernelOptions("vinitd.launch-concurrency=2 vinitd.launch-pressure=40.5")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, o.launchConcurrency)
+	assert.Equal(t, 40.5, o.launchPressure)
+
+	_, err = parseKernelOptions("vinitd.launch-concurrency=-1 vinitd.launch-pressure=101")
+	assert.Error(t, err)
+
+	o, err = parseKernelOptions("vinitd.control-clients=2 vinitd.control-idle=300")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, o.controlClients)
+	assert.Equal(t, 300, o.controlIdle)
+
+	_, err = parseKernelOptions("vinitd.control-clients=0")
+	assert.Error(t, err)
+
+	o, err = parseKernelOptions("vinitd.readonly-root")
+	assert.NoError(t, err)
+	assert.True(t, o.readOnlyRoot)
+
+	o, err = parseKernelOptions("vinitd.readonly-root=off")
+	assert.NoError(t, err)
+	assert.False(t, o.readOnlyRoot)
+
+	_, err = parseKernelOptions("vinitd.readonly-root=maybe")
+	assert.Error(t, err)
+
+	_, err = parseKernelOptions("vinitd.machine-id")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "vinitd.machine-id")
+
+	o, err = parseKernelOptions("vinitd.tmpfs=/tmp,/run:64m,/data/ vinitd.tmpfs-inodes=4096")
+	assert.NoError(t, err)
+	assert.Equal(t, []tmpfsMount{
+		{path: "/tmp", size: "25%"},
+		{path: "/run", size: "64m"},
+		{path: "/data", size: "10%"},
+	}, o.tmpfs)
+	assert.Equal(t, "size=25%,mode=1777,nr_inodes=4096", o.tmpfs[0].options(o.tmpfsInodes))
+	assert.Equal(t, "size=64m,mode=0755", o.tmpfs[1].options(0))
+
+	_, err = parseKernelOptions("vinitd.tmpfs=/tmp:lots")
+	assert.Error(t, err)
+
+	_, err = parseKernelOptions("vinitd.tmpfs=tmp")
+	assert.Error(t, err)
+
+	_, err = parseKernelOptions("vinitd.tmpfs=/")
+	assert.Error(t, err)
+
+	o, err = parseKernelOptions("vinitd.md=md0:vdb+vdc,/dev/md1:/dev/vdd+vde vinitd.lvm=data,logs")
+	assert.NoError(t, err)
+	assert.Equal(t, []mdArray{
+		{device: "/dev/md0", members: []string{"/dev/vdb", "/dev/vdc"}},
+		{device: "/dev/md1", members: []string{"/dev/vdd", "/dev/vde"}},
+	}, o.mdArrays)
+	assert.Equal(t, []string{"data", "logs"}, o.volumeGroups)
+
+	_, err = parseKernelOptions("vinitd.md=md0")
+	assert.Error(t, err)
+
+	_, err = parseKernelOptions("vinitd.md=md0:vdb+")
+	assert.Error(t, err)
+
+	_, err = parseKernelOptions("vinitd.lvm=data,")
+	assert.Error(t, err)
+
+}
@@ -178,9 +178,8 @@ func openVCFGFile(disk string) (*os.File, error) {
 	return f, err
 }
 
-/* readVCFG reads the the configuration for the VM from disk into the
-   configuration struct */
-func (v *Vinitd) readVCFG(disk string) error {
+// loadVCFG reads the configuration for the VM from disk
+func loadVCFG(disk string) (vcfg.VCFG, error) {
 
 	logDebug("reading vcfg from disk %s", disk)
 
@@ -192,21 +191,21 @@ func (v *Vinitd) readVCFG(disk string) error {
 
 	f, err := openVCFGFile(disk)
 	if err != nil {
-		return err
+		return vcfg, err
 	}
 	defer f.Close()
 
 	// var conf PersistedConf
 	err = binary.Read(f, binary.LittleEndian, &blc)
 	if err != nil {
-		return err
+		return vcfg, err
 	}
 
 	logDebug("kernel args: %s", string(blc.LinuxArgs[:]))
 
 	_, err = f.Seek((int64)(vcfgOffset+blc.ConfigOffset), io.SeekStart)
 	if err != nil {
-		return err
+		return vcfg, err
 	}
 
 	logDebug("config offset %d bytes", blc.ConfigOffset)
@@ -214,10 +213,24 @@ func (v *Vinitd) readVCFG(disk string) error {
 	vb := make([]byte, blc.ConfigLen)
 	_, err = f.Read(vb)
 	if err != nil {
-		return err
+		return vcfg, err
 	}
 
 	err = json.Unmarshal(vb, &vcfg)
+	if err != nil {
+		return vcfg, err
+	}
+
+	vcfg.Programs = append(vcfg.Programs, loadDropIns(dropInDir)...)
+
+	return vcfg, nil
+}
+
+/* readVCFG reads the the configuration for the VM from disk into the
+   configuration struct */
+func (v *Vinitd) readVCFG(disk string) error {
+
+	vcfg, err := loadVCFG(disk)
 	if err != nil {
 		return err
 	}
@@ -241,6 +254,11 @@ func setupSharedMemory() error {
 
 	fmt.Sscanf(string(cmd), "shm=%s ", &s)
 
+	if len(s) > 0 && tmpfsConfigured("/dev/shm") {
+		logWarn("/dev/shm already mounted with vinitd.tmpfs, ignoring shm=%s", s)
+		return nil
+	}
+
 	if len(s) > 0 {
 
 		err := mountFs("/dev/shm", "tmpfs", fmt.Sprintf("size=%s", s))
@@ -634,10 +652,9 @@ func systemConfig(sysctls map[string]string, hostname string, maxFds int) error
 		}
 	}
 
-	kernelHostname := "kernel/hostname"
-	err = procsys(kernelHostname, hostname)
+	err = sethostname([]byte(hostname))
 	if err != nil {
-		logError("can not set %s: %s", kernelHostname, err.Error())
+		logError("can not set hostname: %s", err.Error())
 	}
 
 	for k, x := range sysctls {
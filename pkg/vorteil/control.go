@@ -0,0 +1,262 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ctrlList     = "list"
+	ctrlUptime   = "uptime"
+	ctrlStatus   = "status"
+	ctrlPing     = "ping"
+	ctrlRestart  = "restart"
+	ctrlPoweroff = "poweroff"
+	ctrlReboot   = "reboot"
+)
+
+// local socket to query and control vinitd, replaced in tests
+var controlSocket = "/run/vinitd.sock"
+
+// controlProgram is a program in the answer to list
+type controlProgram struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	PID      int       `json:"pid,omitempty"`
+	Restarts int       `json:"restarts"`
+	ExitCode int       `json:"exitCode"`
+	ExitTime time.Time `json:"exitTime"`
+}
+
+// controlResponse is written as one json line for every command
+type controlResponse struct {
+	Error    string           `json:"error,omitempty"`
+	Programs []controlProgram `json:"programs,omitempty"`
+	Uptime   float64          `json:"uptime,omitempty"`
+
+	Hostname  string `json:"hostname,omitempty"`
+	MachineID string `json:"machineID,omitempty"`
+}
+
+// listenControl creates the socket, only root can connect
+func listenControl(path string) (net.Listener, error) {
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// serveControl answers commands until the listener is closed. Clients
+// above the limit get an error and are disconnected.
+func (v *Vinitd) serveControl(l net.Listener) {
+
+	clients := make(chan bool, kernelOpts.controlClients)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logDebug("control socket closed: %s", err.Error())
+			return
+		}
+
+		select {
+		case clients <- true:
+		default:
+			logWarn("control socket has %d clients, refusing connection", kernelOpts.controlClients)
+			conn.SetDeadline(time.Now().Add(time.Second))
+			json.NewEncoder(conn).Encode(&controlResponse{Error: "too many clients"})
+			conn.Close()
+			continue
+		}
+
+		atomic.AddInt32(&v.controlClients, 1)
+		go func() {
+			v.handleControl(conn)
+			atomic.AddInt32(&v.controlClients, -1)
+			<-clients
+		}()
+	}
+
+}
+
+// handleControl reads one command per line, e.g. "restart web", and writes
+// one json response per line. Long-lived clients send ping to keep the
+// connection.
+func (v *Vinitd) handleControl(conn net.Conn) {
+
+	defer conn.Close()
+
+	s := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	idle := time.Duration(kernelOpts.controlIdle) * time.Second
+
+	for {
+
+		// dead or idle clients do not keep the connection
+		conn.SetDeadline(time.Now().Add(idle))
+		if !s.Scan() {
+			return
+		}
+
+		fs := strings.Fields(s.Text())
+		if len(fs) == 0 {
+			continue
+		}
+
+		resp, after := v.controlCommand(fs[0], fs[1:])
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+
+		if after != nil {
+			after()
+			return
+		}
+	}
+
+}
+
+// controlCommand runs the command. Shutdowns are returned to run after the
+// response has been sent.
+func (v *Vinitd) controlCommand(cmd string, args []string) (*controlResponse, func()) {
+
+	resp := new(controlResponse)
+
+	switch cmd {
+	case ctrlList:
+		resp.Programs = v.controlPrograms()
+	case ctrlPing:
+	case ctrlUptime:
+		resp.Uptime = uptime()
+	case ctrlStatus:
+		resp.Uptime = uptime()
+		v.hostnameLock.Lock()
+		resp.Hostname = v.hostname
+		v.hostnameLock.Unlock()
+		v.machineIDLock.Lock()
+		resp.MachineID = v.machineID
+		v.machineIDLock.Unlock()
+	case ctrlRestart:
+		if len(args) != 1 {
+			resp.Error = "usage: restart <program>"
+			break
+		}
+		if err := v.requestRestart(args[0]); err != nil {
+			resp.Error = err.Error()
+		}
+	case ctrlPoweroff:
+		return resp, func() { Poweroff("requested on control socket") }
+	case ctrlReboot:
+		return resp, func() { Reboot("requested on control socket") }
+	default:
+		resp.Error = fmt.Sprintf("unknown command %s", cmd)
+	}
+
+	return resp, nil
+}
+
+func (v *Vinitd) controlPrograms() []controlProgram {
+
+	var progs []controlProgram
+
+	for _, p := range v.programList() {
+		s := p.currentStatus()
+		cp := controlProgram{
+			Name:     s.Name,
+			State:    s.State,
+			Restarts: s.Restarts,
+			ExitCode: s.ExitCode,
+			ExitTime: s.ExitTime,
+		}
+		p.statusLock.Lock()
+		if p.cmd != nil && p.cmd.Process != nil && !p.exited {
+			cp.PID = p.cmd.Process.Pid
+		}
+		p.statusLock.Unlock()
+		progs = append(progs, cp)
+	}
+
+	return progs
+}
+
+// requestRestart stops the program and starts it again regardless of its
+// restart policy. Programs which are not running get started.
+func (v *Vinitd) requestRestart(name string) error {
+
+	if initStatus == statusPoweroff {
+		return fmt.Errorf("shutting down")
+	}
+
+	for _, p := range v.programList() {
+
+		if p.name() != name {
+			continue
+		}
+
+		return v.restartRequest(p)
+	}
+
+	return fmt.Errorf("no program %s", name)
+}
+
+// restartRequest decides under the status lock, waitForApp takes the
+// request once the process has exited
+func (v *Vinitd) restartRequest(p *program) error {
+
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+
+	running := p.cmd != nil
+	if running {
+		select {
+		case <-p.done:
+			running = false
+		default:
+		}
+	}
+
+	switch {
+	case p.restarting:
+		return fmt.Errorf("%s is restarting already", p.name())
+	case running:
+		logAlways("restart of %s requested", p.name())
+		p.restartRequested = true
+		go p.stop()
+	case p.failed || p.exited:
+		logAlways("start of %s requested", p.name())
+		p.failed = false
+		p.restarting = true
+		go v.restartProgram(p, 0, true)
+	default:
+		return fmt.Errorf("%s is starting or stopping", p.name())
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package vorteil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func TestControlSocket(t *testing.T) {
+
+	New(testLogFn)
+
+	opts := kernelOpts
+	defer func() {
+		kernelOpts = opts
+	}()
+	kernelOpts.controlClients = 3
+	kernelOpts.controlIdle = 1
+
+	dir, err := ioutil.TempDir("", "control")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	v := &Vinitd{}
+	for _, n := range []string{"web", "db"} {
+		_, err := v.prepProgram(vcfg.Program{
+			Binary: "/bin/" + n,
+			Env:    []string{"VINITD_NAME=" + n},
+		})
+		assert.NoError(t, err)
+	}
+
+	web := v.programList()[0]
+	web.setState(stateRunning)
+	web.cmd = exec.Command("sleep", "10")
+	assert.NoError(t, web.cmd.Start())
+
+	path := filepath.Join(dir, "vinitd.sock")
+	l, err := listenControl(path)
+	assert.NoError(t, err)
+	defer l.Close()
+	go v.serveControl(l)
+
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	send := func(cmd string) *controlResponse {
+		fmt.Fprintln(conn, cmd)
+		line, err := r.ReadBytes('\n')
+		assert.NoError(t, err)
+		resp := new(controlResponse)
+		assert.NoError(t, json.Unmarshal(line, resp))
+		return resp
+	}
+
+	resp := send("list")
+	assert.Empty(t, resp.Error)
+	assert.Equal(t, []controlProgram{
+		{Name: "web", State: stateRunning, PID: web.cmd.Process.Pid},
+		{Name: "db", State: stateStarting},
+	}, resp.Programs)
+
+	assert.Greater(t, send("uptime").Uptime, 0.0)
+	assert.Equal(t, &controlResponse{}, send("ping"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&v.controlClients))
+
+	v.hostname, v.machineID = "vm", "0123456789abcdef0123456789abcdef"
+	resp = send("status")
+	assert.Greater(t, resp.Uptime, 0.0)
+	assert.Equal(t, "vm", resp.Hostname)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", resp.MachineID)
+
+	// a running program gets stopped and restarted after its exit
+	assert.Empty(t, send("restart web").Error)
+	assert.True(t, web.restartRequested)
+	assert.Error(t, web.cmd.Wait())
+	assert.False(t, web.cmd.ProcessState.Success())
+
+	// started again, not restarted twice
+	web.done = make(chan struct{})
+	close(web.done)
+	web.restarting = true
+	assert.Equal(t, "web is restarting already", send("restart web").Error)
+
+	assert.Equal(t, "no program cache", send("restart cache").Error)
+	assert.NotEmpty(t, send("restart").Error)
+	assert.NotEmpty(t, send("halt").Error)
+
+	// clients above the limit are refused
+	var conns []net.Conn
+	for i := 1; i < kernelOpts.controlClients; i++ {
+		c, err := net.Dial("unix", path)
+		assert.NoError(t, err)
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	c, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	line, err := bufio.NewReader(c).ReadBytes('\n')
+	assert.NoError(t, err)
+	assert.Contains(t, string(line), "too many clients")
+	c.Close()
+
+	// a free slot accepts clients again
+	conns[0].Close()
+	time.Sleep(50 * time.Millisecond)
+	c, err = net.Dial("unix", path)
+	assert.NoError(t, err)
+	defer c.Close()
+	fmt.Fprintln(c, "uptime")
+	line, err = bufio.NewReader(c).ReadBytes('\n')
+	assert.NoError(t, err)
+	assert.Contains(t, string(line), "uptime")
+
+	// idle clients get disconnected
+	time.Sleep(1500 * time.Millisecond)
+	_, err = r.ReadBytes('\n')
+	assert.Error(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&v.controlClients))
+
+}
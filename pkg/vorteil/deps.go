@@ -0,0 +1,176 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// name identifies the program for dependencies, the binary name if not set
+func (p *program) name() string {
+	if p.opts.name != "" {
+		return p.opts.name
+	}
+	return filepath.Base(p.vcfgProg.Binary)
+}
+
+// markReady releases programs waiting for this one. Called on every launch,
+// only the first one counts.
+func (p *program) markReady() {
+	if p.ready == nil {
+		return
+	}
+	p.readyOnce.Do(func() {
+		p.readyAt = time.Now()
+		close(p.ready)
+	})
+}
+
+// failReady releases programs waiting for this one if it will never get
+// ready, they fail to start as well
+func (p *program) failReady(err error) {
+	if p.ready == nil {
+		return
+	}
+	p.readyOnce.Do(func() {
+		p.readyErr = err
+		close(p.ready)
+	})
+}
+
+// markFailed marks a program which is not running and never will be,
+// shutdown does not wait for it
+func (p *program) markFailed(err error) {
+	p.statusLock.Lock()
+	p.failed = true
+	p.status.State = stateFailed
+	p.statusLock.Unlock()
+	p.failReady(err)
+}
+
+// programsByName returns the programs for each name, names don't have to be
+// unique
+func programsByName(progs []*program) map[string][]*program {
+	byName := make(map[string][]*program)
+	for _, p := range progs {
+		byName[p.name()] = append(byName[p.name()], p)
+	}
+	return byName
+}
+
+// sortByDependencies orders the programs so dependencies come first.
+// Programs without dependencies keep their order.
+func sortByDependencies(progs []*program) ([]*program, error) {
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	var (
+		sorted []*program
+		byName = programsByName(progs)
+		state  = make(map[*program]int)
+		visit  func(p *program, chain []*program) error
+	)
+
+	visit = func(p *program, chain []*program) error {
+
+		switch state[p] {
+		case visited:
+			return nil
+		case visiting:
+			// report the cycle only, not the way to it
+			var names []string
+			for i := len(chain) - 1; i >= 0; i-- {
+				names = append([]string{chain[i].name()}, names...)
+				if chain[i] == p {
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle %s -> %s", strings.Join(names, " -> "), p.name())
+		}
+
+		state[p] = visiting
+		chain = append(chain, p)
+
+		for _, n := range p.opts.after {
+
+			deps, ok := byName[n]
+			if !ok {
+				return fmt.Errorf("%s depends on unknown program %s", p.name(), n)
+			}
+
+			for _, d := range deps {
+				if d.opts.phase > p.opts.phase {
+					return fmt.Errorf("%s can not depend on %s launched in the later phase %s",
+						p.name(), n, phaseNames[d.opts.phase])
+				}
+				if err := visit(d, chain); err != nil {
+					return err
+				}
+			}
+
+		}
+
+		state[p] = visited
+		sorted = append(sorted, p)
+
+		return nil
+	}
+
+	for _, p := range progs {
+		if err := visit(p, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+// sortPrograms orders the programs by their dependencies
+func (v *Vinitd) sortPrograms() error {
+
+	v.programsLock.Lock()
+	defer v.programsLock.Unlock()
+
+	sorted, err := sortByDependencies(v.programs)
+	if err != nil {
+		return err
+	}
+	v.programs = sorted
+
+	return nil
+}
+
+// waitForDependencies blocks until all programs p depends on are ready. It
+// fails if one of them will never be ready.
+func (v *Vinitd) waitForDependencies(p *program) error {
+
+	if len(p.opts.after) == 0 {
+		return nil
+	}
+
+	byName := programsByName(v.programList())
+
+	for _, n := range p.opts.after {
+		for _, d := range byName[n] {
+			if d.ready == nil {
+				continue
+			}
+			logDebug("%s waiting for %s", p.name(), n)
+			<-d.ready
+			if d.readyErr != nil {
+				return fmt.Errorf("%s depends on %s: %s", p.name(), n, d.readyErr.Error())
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package vorteil
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func depProgram(name string, after ...string) *program {
+	return &program{
+		vcfgProg: vcfg.Program{Binary: "/bin/" + name},
+		opts: programOptions{
+			phase: phasePostMounts,
+			after: after,
+		},
+	}
+}
+
+func programNames(progs []*program) []string {
+	var names []string
+	for _, p := range progs {
+		names = append(names, p.name())
+	}
+	return names
+}
+
+func TestSortByDependencies(t *testing.T) {
+
+	New(testLogFn)
+
+	// linear chain, independent programs keep their order
+	progs := []*program{
+		depProgram("web", "app"),
+		depProgram("cron"),
+		depProgram("app", "db"),
+		depProgram("db"),
+		depProgram("log"),
+	}
+
+	sorted, err := sortByDependencies(progs)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db", "app", "web", "cron", "log"}, programNames(sorted))
+
+	// diamond, the shared dependency starts once
+	progs = []*program{
+		depProgram("top", "left", "right"),
+		depProgram("left", "bottom"),
+		depProgram("right", "bottom"),
+		depProgram("bottom"),
+	}
+
+	sorted, err = sortByDependencies(progs)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bottom", "left", "right", "top"}, programNames(sorted))
+
+	// cycle reported without the programs leading to it
+	progs = []*program{
+		depProgram("start", "a"),
+		depProgram("a", "b"),
+		depProgram("b", "c"),
+		depProgram("c", "a"),
+	}
+
+	_, err = sortByDependencies(progs)
+	assert.EqualError(t, err, "dependency cycle a -> b -> c -> a")
+
+	_, err = sortByDependencies([]*program{depProgram("a", "missing")})
+	assert.Error(t, err)
+
+	// dependencies have to be launched in the same or an earlier phase
+	early := depProgram("early", "late")
+	early.opts.phase = phasePreNetwork
+	_, err = sortByDependencies([]*program{early, depProgram("late")})
+	assert.Error(t, err)
+
+	// explicit names
+	named := depProgram("server")
+	named.opts.name = "api"
+	sorted, err = sortByDependencies([]*program{depProgram("client", "api"), named})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api", "client"}, programNames(sorted))
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_NAME=api", "VINITD_AFTER=db, cache"})
+	assert.NoError(t, err)
+	assert.Equal(t, "api", opts.name)
+	assert.Equal(t, []string{"db", "cache"}, opts.after)
+
+}
+
+func TestFailedDependency(t *testing.T) {
+
+	New(testLogFn)
+
+	v := &Vinitd{}
+	db := depProgram("db")
+	db.opts.readyTCP = "127.0.0.1:1"
+	db.ready = make(chan struct{})
+	db.done = make(chan struct{})
+	db.cmd = &exec.Cmd{Process: &os.Process{Pid: 100}}
+	db.vinitd = v
+	web := depProgram("web", "db")
+	web.ready = make(chan struct{})
+	v.programs = []*program{db, web}
+
+	started := make(chan error)
+	go func() {
+		started <- v.startProgram(web)
+	}()
+
+	// exits before its probe passed and is not restarted
+	exit := make(chan syscall.WaitStatus, 1)
+	exit <- syscall.WaitStatus(1 << 8)
+	waitForApp(db, exit)
+
+	select {
+	case err := <-started:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("dependent program still waiting")
+	}
+
+	assert.EqualError(t, db.readyErr, "exited with 1 before it was ready")
+	assert.True(t, web.finished(true))
+	assert.Equal(t, stateFailed, web.currentStatus().State)
+	assert.Error(t, web.readyErr)
+
+}
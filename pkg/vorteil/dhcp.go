@@ -0,0 +1,318 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/client4"
+	"github.com/vishvananda/netlink"
+)
+
+var (
+	// renew and rebind requests are not sent more often than this
+	dhcpMinRetry = 60 * time.Second
+
+	// delays between attempts to get a new lease after the old one is lost
+	dhcpRetryDelay    = 2 * time.Second
+	dhcpMaxRetryDelay = 2 * time.Minute
+
+	// sends a message and waits for the answer, replaced in tests
+	dhcpExchange = exchangeDHCP
+
+	dhcpRequestedOptions = dhcpv4.WithRequestedOptions(dhcpv4.OptionRenewTimeValue,
+		dhcpv4.OptionRebindingTimeValue, dhcpv4.OptionNTPServers, dhcpv4.OptionDomainName,
+		dhcpv4.OptionDNSDomainSearchList, dhcpv4.OptionHostName, dhcpv4.GenericOptionCode(azureEndpointServerOption))
+)
+
+// dhcpLease is the address given by the server. The lease gets renewed at t1
+// with that server and at t2 with any server.
+type dhcpLease struct {
+	ack      *dhcpv4.DHCPv4
+	obtained time.Time
+	t1, t2   time.Duration
+	duration time.Duration
+}
+
+func newDHCPLease(ack *dhcpv4.DHCPv4, now time.Time) *dhcpLease {
+
+	l := &dhcpLease{
+		ack:      ack,
+		obtained: now,
+		duration: ack.IPAddressLeaseTime(2 * dhcpDefaultRenew * time.Second),
+	}
+
+	if v := ack.Options.Get(dhcpv4.OptionRenewTimeValue); len(v) == 4 {
+		l.t1 = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	if v := ack.Options.Get(dhcpv4.OptionRebindingTimeValue); len(v) == 4 {
+		l.t2 = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+
+	// defaults from rfc 2131 if not set or out of order
+	if l.t2 == 0 || l.t2 > l.duration {
+		l.t2 = l.duration * 7 / 8
+	}
+	if l.t1 == 0 || l.t1 > l.t2 {
+		l.t1 = l.duration / 2
+		if l.t1 > l.t2 {
+			l.t1 = l.t2
+		}
+	}
+
+	return l
+}
+
+// dhcpRetryWait is half the time left until the next step but at least
+// dhcpMinRetry
+func dhcpRetryWait(left time.Duration) time.Duration {
+
+	w := left / 2
+	if w < dhcpMinRetry {
+		w = dhcpMinRetry
+	}
+	if w > left {
+		w = left
+	}
+
+	return w
+}
+
+// exchangeDHCP sends the message on the interface of the client. Renew
+// requests go to the server of the lease, everything else is broadcast.
+func exchangeDHCP(c *clientdhcp, msg *dhcpv4.DHCPv4, unicast bool) (*dhcpv4.DHCPv4, error) {
+
+	rfd, err := client4.MakeListeningSocket(c.ifc.name)
+	if err != nil {
+		return nil, err
+	}
+
+	sfd, err := client4.MakeBroadcastSocket(c.ifc.name)
+	if err != nil {
+		closeFds(rfd, rfd)
+		return nil, err
+	}
+
+	defer closeFds(sfd, rfd)
+
+	client := client4.NewClient()
+	if unicast {
+		client.RemoteAddr = &net.UDPAddr{IP: c.lease.ack.ServerIdentifier(), Port: dhcpv4.ServerPort}
+		client.LocalAddr = &net.UDPAddr{IP: c.lease.ack.YourIPAddr, Port: dhcpv4.ClientPort}
+	}
+	client.ReadTimeout = defaultDHCPTimeout
+	client.WriteTimeout = defaultDHCPTimeout
+
+	return client.SendReceive(sfd, rfd, msg, dhcpv4.MessageTypeNone)
+}
+
+// request asks for the offered address, the answer has to be an ack
+func (c *clientdhcp) request(offer *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+
+	request, err := dhcpv4.NewRequestFromOffer(offer,
+		dhcpv4.WithTransactionID(c.xid),
+		dhcpv4.WithOption(dhcpv4.OptClientIdentifier(c.cid)),
+		dhcpv4.WithBroadcast(true),
+		dhcpRequestedOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	ack, err := dhcpExchange(c, request, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if ack.MessageType() != dhcpv4.MessageTypeAck {
+		return nil, fmt.Errorf("server answered with %s", ack.MessageType())
+	}
+
+	return ack, nil
+}
+
+// renew extends the lease, unicast to the server of the lease before t2 and
+// broadcast after. A nak ends the lease.
+func (c *clientdhcp) renew(unicast bool) error {
+
+	mrand.Read(c.xid[:])
+
+	request, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(c.ifc.netIfc.HardwareAddr),
+		dhcpv4.WithClientIP(c.lease.ack.YourIPAddr),
+		dhcpv4.WithTransactionID(c.xid),
+		dhcpv4.WithOption(dhcpv4.OptClientIdentifier(c.cid)),
+		dhcpRequestedOptions)
+	if err != nil {
+		return err
+	}
+
+	ack, err := dhcpExchange(c, request, unicast)
+	if err != nil {
+		return err
+	}
+
+	switch ack.MessageType() {
+	case dhcpv4.MessageTypeAck:
+		c.lease = newDHCPLease(ack, time.Now())
+		return nil
+	case dhcpv4.MessageTypeNak:
+		c.lease = &dhcpLease{ack: c.lease.ack}
+		return fmt.Errorf("server declined lease of %s", c.lease.ack.YourIPAddr)
+	}
+
+	return fmt.Errorf("server answered with %s", ack.MessageType())
+}
+
+// acquire gets a new lease and moves the interface to the new address if it
+// changed
+func (c *clientdhcp) acquire() error {
+
+	mrand.Read(c.xid[:])
+
+	discover, err := dhcpv4.NewDiscovery(c.ifc.netIfc.HardwareAddr,
+		dhcpv4.WithTransactionID(c.xid),
+		dhcpv4.WithOption(dhcpv4.OptClientIdentifier(c.cid)),
+		dhcpv4.WithBroadcast(true),
+		dhcpRequestedOptions)
+	if err != nil {
+		return err
+	}
+
+	offer, err := dhcpExchange(c, discover, false)
+	if err != nil {
+		return err
+	}
+	if offer.MessageType() != dhcpv4.MessageTypeOffer {
+		return fmt.Errorf("server answered with %s", offer.MessageType())
+	}
+
+	ack, err := c.request(offer)
+	if err != nil {
+		return err
+	}
+
+	c.lease = newDHCPLease(ack, time.Now())
+
+	if c.ifc.addr != nil && c.ifc.addr.IP.Equal(ack.YourIPAddr) {
+		return nil
+	}
+
+	var router net.IP
+	if r := ack.Router(); len(r) > 0 {
+		router = r[0]
+	}
+
+	return updateInterface(c.ifc, ack.YourIPAddr, ack.SubnetMask(), router)
+}
+
+// keepLease renews the lease until it gets stopped. After the lease is lost
+// a new one is acquired with increasing delays between the attempts.
+func (c *clientdhcp) keepLease(stop <-chan struct{}) {
+
+	var retry *backoff
+
+	for {
+
+		var (
+			wait time.Duration
+			err  error
+		)
+
+		l := c.lease
+		el := time.Since(l.obtained)
+
+		// a nak replaces the lease with an expired one to get a new one right away
+		switch {
+		case el < l.t1:
+			wait = l.t1 - el
+		case el < l.t2:
+			logDebug("renew dhcp lease of %s with %s", l.ack.YourIPAddr, l.ack.ServerIdentifier())
+			if err = c.renew(true); err != nil && c.lease == l {
+				wait = dhcpRetryWait(l.t2 - el)
+			}
+		case el < l.duration:
+			logDebug("rebind dhcp lease of %s", l.ack.YourIPAddr)
+			if err = c.renew(false); err != nil && c.lease == l {
+				wait = dhcpRetryWait(l.duration - el)
+			}
+		default:
+			if retry == nil {
+				logWarn("dhcp lease of %s on %s lost", l.ack.YourIPAddr, c.ifc.name)
+				retry = &backoff{initial: dhcpRetryDelay, max: dhcpMaxRetryDelay}
+			}
+			if err = c.acquire(); err != nil {
+				wait = retry.next(time.Now())
+				logWarn("can not get dhcp lease for %s, retry in %v: %s", c.ifc.name, wait, err.Error())
+				err = nil
+			} else {
+				logAlways("%s: dhcp lease of %s", c.ifc.name, c.lease.ack.YourIPAddr)
+				retry = nil
+			}
+		}
+
+		if err != nil {
+			logDebug("dhcp lease not extended: %s", err.Error())
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// updateInterface replaces the address and the default gateway of the
+// interface
+func updateInterface(i *ifc, ip net.IP, mask net.IPMask, router net.IP) error {
+
+	logDebug("%s: %v/%v/%v", i.name, ip, mask, router)
+
+	link, err := netlink.LinkByName(i.name)
+	if err != nil {
+		return err
+	}
+
+	// routes using the old address are removed with it
+	if i.addr != nil {
+		netlink.AddrDel(link, &netlink.Addr{IPNet: i.addr})
+	}
+
+	i.addr = &net.IPNet{IP: ip, Mask: mask}
+	err = netlink.AddrAdd(link, &netlink.Addr{IPNet: i.addr})
+	if err != nil {
+		return err
+	}
+
+	if router == nil {
+		return nil
+	}
+
+	// gateway outside of the network, e.g. google cloud
+	if !i.addr.Contains(router) {
+		err = netlink.RouteReplace(&netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       &net.IPNet{IP: router, Mask: net.CIDRMask(32, 32)},
+			Scope:     netlink.SCOPE_LINK,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	i.gw = router
+
+	return netlink.RouteReplace(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Gw:        router,
+	})
+}
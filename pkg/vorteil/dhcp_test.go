@@ -0,0 +1,221 @@
+package vorteil
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockDHCPServer answers on a local udp socket with a one second lease
+type mockDHCPServer struct {
+	conn *net.UDPConn
+
+	lock     sync.Mutex
+	silent   bool
+	nak      bool
+	received map[dhcpv4.MessageType]int
+	renewals int
+}
+
+func newMockDHCPServer(t *testing.T) *mockDHCPServer {
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+
+	s := &mockDHCPServer{
+		conn:     conn,
+		received: make(map[dhcpv4.MessageType]int),
+	}
+	go s.serve()
+
+	return s
+}
+
+func (s *mockDHCPServer) serve() {
+
+	buf := make([]byte, 1500)
+
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		req, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		s.lock.Lock()
+		s.received[req.MessageType()]++
+		if !req.ClientIPAddr.IsUnspecified() {
+			s.renewals++
+		}
+		silent, nak := s.silent, s.nak
+		s.lock.Unlock()
+
+		if silent {
+			continue
+		}
+
+		mt := dhcpv4.MessageTypeAck
+		switch {
+		case req.MessageType() == dhcpv4.MessageTypeDiscover:
+			mt = dhcpv4.MessageTypeOffer
+		case nak && !req.ClientIPAddr.IsUnspecified():
+			mt = dhcpv4.MessageTypeNak
+		}
+
+		reply, _ := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(mt),
+			dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 5)),
+			dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+			dhcpv4.WithLeaseTime(1),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 1))),
+			dhcpv4.WithOption(dhcpv4.OptRouter(net.IPv4(10, 0, 0, 1))))
+		s.conn.WriteTo(reply.ToBytes(), addr)
+	}
+}
+
+func (s *mockDHCPServer) count(mt dhcpv4.MessageType) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.received[mt]
+}
+
+func (s *mockDHCPServer) set(silent, nak bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.silent, s.nak = silent, nak
+}
+
+func TestDHCPLease(t *testing.T) {
+
+	now := time.Now()
+
+	ack, _ := dhcpv4.New(dhcpv4.WithLeaseTime(3600))
+	l := newDHCPLease(ack, now)
+	assert.Equal(t, time.Hour, l.duration)
+	assert.Equal(t, 30*time.Minute, l.t1)
+	assert.Equal(t, 52*time.Minute+30*time.Second, l.t2)
+
+	ack, _ = dhcpv4.New(dhcpv4.WithLeaseTime(3600),
+		dhcpv4.WithGeneric(dhcpv4.OptionRenewTimeValue, []byte{0, 0, 0, 60}),
+		dhcpv4.WithGeneric(dhcpv4.OptionRebindingTimeValue, []byte{0, 0, 0, 120}))
+	l = newDHCPLease(ack, now)
+	assert.Equal(t, time.Minute, l.t1)
+	assert.Equal(t, 2*time.Minute, l.t2)
+
+	// rebinding after the lease expired
+	ack, _ = dhcpv4.New(dhcpv4.WithLeaseTime(100),
+		dhcpv4.WithGeneric(dhcpv4.OptionRebindingTimeValue, []byte{0, 0, 1, 0}))
+	l = newDHCPLease(ack, now)
+	assert.Equal(t, 50*time.Second, l.t1)
+	assert.Equal(t, 87500*time.Millisecond, l.t2)
+
+	ack, _ = dhcpv4.New()
+	l = newDHCPLease(ack, now)
+	assert.Equal(t, dhcpDefaultRenew*time.Second, l.t1)
+
+	assert.Equal(t, dhcpMinRetry, dhcpRetryWait(90*time.Second))
+	assert.Equal(t, time.Second, dhcpRetryWait(time.Second))
+	assert.Equal(t, 2*time.Hour, dhcpRetryWait(4*time.Hour))
+
+}
+
+func TestDHCPRenewal(t *testing.T) {
+
+	New(testLogFn)
+
+	s := newMockDHCPServer(t)
+	defer s.conn.Close()
+
+	minRetry, retryDelay, exchange := dhcpMinRetry, dhcpRetryDelay, dhcpExchange
+	defer func() {
+		dhcpMinRetry, dhcpRetryDelay, dhcpExchange = minRetry, retryDelay, exchange
+	}()
+	dhcpMinRetry = 50 * time.Millisecond
+	dhcpRetryDelay = 50 * time.Millisecond
+
+	dhcpExchange = func(c *clientdhcp, msg *dhcpv4.DHCPv4, unicast bool) (*dhcpv4.DHCPv4, error) {
+
+		conn, err := net.DialUDP("udp4", nil, s.conn.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		conn.Write(msg.ToBytes())
+
+		buf := make([]byte, 1500)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return dhcpv4.FromBytes(buf[:n])
+	}
+
+	// the interface already has the address, the link is not changed
+	c := &clientdhcp{
+		ifc: &ifc{
+			name: "eth0",
+			addr: &net.IPNet{IP: net.IPv4(10, 0, 0, 5), Mask: net.CIDRMask(24, 32)},
+			netIfc: net.Interface{
+				HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			},
+		},
+	}
+
+	assert.NoError(t, c.acquire())
+	assert.Equal(t, time.Second, c.lease.duration)
+	assert.Equal(t, 1, s.count(dhcpv4.MessageTypeDiscover))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.keepLease(stop)
+		close(done)
+	}()
+
+	// renewed with the server at t1
+	assert.Eventually(t, func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return s.renewals > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// without answers the lease expires and a new one is acquired
+	s.set(true, false)
+	assert.Eventually(t, func() bool {
+		return s.count(dhcpv4.MessageTypeDiscover) > 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	s.lock.Lock()
+	s.silent = false
+	r := s.renewals
+	s.lock.Unlock()
+
+	// renewed again with the new lease
+	assert.Eventually(t, func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return s.renewals > r+1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// a nak ends the lease right away
+	s.set(false, true)
+	d := s.count(dhcpv4.MessageTypeDiscover)
+	assert.Eventually(t, func() bool {
+		return s.count(dhcpv4.MessageTypeDiscover) > d
+	}, 2*time.Second, 10*time.Millisecond)
+
+	close(stop)
+	<-done
+
+}
@@ -6,10 +6,12 @@
 package vorteil
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"unsafe"
@@ -21,6 +23,18 @@ const (
 	ext4IOCResizeFS = 0x40086610
 	xfsGrowFS       = 0x4010586e
 	xfsGeom         = 0x8100587e
+
+	// incompat feature flag for block counts above 32 bit
+	ext4Feature64Bit = 0x80
+
+	// free space after the filesystem below this is not used
+	growMinimum = 16 * 1024 * 1024
+)
+
+var (
+	// replaced in tests
+	fsSize   = filesystemSize
+	fsResize = resizeFilesystem
 )
 
 type xfsGrowFSData struct {
@@ -158,67 +172,180 @@ func growDisk(f *os.File, p string) error {
 		return err
 	}
 
-	if !gptGrower.needsResize() {
-		return nil
+	if gptGrower.needsResize() {
+
+		err = gptGrower.grow()
+		if err != nil {
+			return err
+		}
+
+		arg := &unix.BlkpgIoctlArg{
+			Op: unix.BLKPG_RESIZE_PARTITION,
+			Data: (*byte)(unsafe.Pointer(&unix.BlkpgPartition{
+				Start:  int64(gptGrower.partitionEntry.FirstLBA * sectorSize),                                      // in bytes
+				Length: int64((gptGrower.partitionEntry.LastLBA - gptGrower.partitionEntry.FirstLBA) * sectorSize), // in bytes
+				Pno:    int32(2),
+			})),
+		}
+
+		if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(f.Fd()), unix.BLKPG, uintptr(unsafe.Pointer(arg))); e != 0 {
+			return fmt.Errorf("error resizing gpt: %s", syscall.Errno(e))
+		}
 	}
 
-	err = gptGrower.grow()
+	// the filesystem might not have been grown with the partition on an
+	// earlier boot, it is checked every time
+	part := fmt.Sprintf("%s2", p)
+
+	pf, err := os.Open(part)
 	if err != nil {
 		return err
 	}
+	format, err := detectFormat(pf, part, 0)
+	pf.Close()
+	if err != nil {
+		logWarn("can not detect filesystem on %s: %s", part, err.Error())
+		return nil
+	}
 
-	// if reboot {
-	rootFS, err := os.Open("/")
+	partBytes, err := partitionSize(part)
 	if err != nil {
 		return err
 	}
-	defer rootFS.Close()
 
-	// get blocksize
-	s1 := syscall.Statfs_t{}
-	err = syscall.Fstatfs(int(rootFS.Fd()), &s1)
+	return growFilesystem(format, part, partBytes)
+}
+
+// partitionSize reads the size of the partition in bytes from sysfs
+func partitionSize(part string) (uint64, error) {
+
+	b, err := ioutil.ReadFile(filepath.Join("/sys/class/block", filepath.Base(part), "size"))
+	if err != nil {
+		return 0, err
+	}
+
+	sectors, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return sectors * sectorSize, nil
+}
+
+// needsGrow is true if the partition has enough space left after the
+// filesystem to be worth growing into
+func needsGrow(fsBytes, partBytes uint64) bool {
+	return partBytes > fsBytes && partBytes-fsBytes >= growMinimum
+}
+
+// growFilesystem grows the root filesystem to the size of its partition
+// unless it is already at full size
+func growFilesystem(format Format, part string, partBytes uint64) error {
+
+	switch format {
+	case Ext2FS, Ext4FS, XFS:
+	default:
+		logWarn("can not grow filesystem on %s, unsupported format", part)
+		return nil
+	}
+
+	size, blockSize, err := fsSize(format, part)
 	if err != nil {
 		return err
 	}
 
-	blocks := (gptGrower.partitionEntry.LastLBA - gptGrower.partitionEntry.FirstLBA) * sectorSize / uint64(s1.Bsize)
+	if !needsGrow(size, partBytes) {
+		logDebug("%s filesystem on %s has full size", format, part)
+		return nil
+	}
+
+	blocks := partBytes / blockSize
+	logAlways("growing %s filesystem on %s from %d to %d blocks", format, part, size/blockSize, blocks)
+
+	return fsResize(format, blocks)
+}
+
+// filesystemSize returns the size of the filesystem in bytes and its block
+// size. ext filesystems are read from the superblock on the partition, xfs
+// from the mounted root.
+func filesystemSize(format Format, part string) (uint64, uint64, error) {
+
+	if format == XFS {
+		rootFS, err := os.Open("/")
+		if err != nil {
+			return 0, 0, err
+		}
+		defer rootFS.Close()
+
+		geom, err := xfsGeometry(rootFS)
+		if err != nil {
+			return 0, 0, err
+		}
+		return geom.dataBlocks * uint64(geom.blockSize), uint64(geom.blockSize), nil
+	}
+
+	f, err := os.Open(part)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	sb := make([]byte, 1024)
+	_, err = f.ReadAt(sb, 1024)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	blocks, blockSize := ext4Size(sb)
+
+	return blocks * blockSize, blockSize, nil
+}
 
-	arg := &unix.BlkpgIoctlArg{
-		Op: unix.BLKPG_RESIZE_PARTITION,
-		Data: (*byte)(unsafe.Pointer(&unix.BlkpgPartition{
-			Start:  int64(gptGrower.partitionEntry.FirstLBA * sectorSize),                                      // in bytes
-			Length: int64((gptGrower.partitionEntry.LastLBA - gptGrower.partitionEntry.FirstLBA) * sectorSize), // in bytes
-			Pno:    int32(2),
-		})),
+// ext4Size returns the block count and block size from a superblock
+func ext4Size(sb []byte) (uint64, uint64) {
+
+	blocks := uint64(binary.LittleEndian.Uint32(sb[4:]))
+	if binary.LittleEndian.Uint32(sb[0x60:])&ext4Feature64Bit != 0 {
+		blocks |= uint64(binary.LittleEndian.Uint32(sb[0x150:])) << 32
 	}
 
-	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(f.Fd()), unix.BLKPG, uintptr(unsafe.Pointer(arg))); e != 0 {
-		return fmt.Errorf("error resizing gpt: %s", syscall.Errno(e))
+	return blocks, 1024 << binary.LittleEndian.Uint32(sb[24:])
+}
+
+func xfsGeometry(rootFS *os.File) (*xfsFsopGeom, error) {
+
+	geom := new(xfsFsopGeom)
+	if _, _, e := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		uintptr(rootFS.Fd()),
+		uintptr(xfsGeom),
+		uintptr(unsafe.Pointer(geom)),
+	); e != 0 {
+		return nil, fmt.Errorf("error getting xfs geometry: %s", syscall.Errno(e))
 	}
 
-	// detect fs type
-	format, err := detectFormat(f, p, int64(gptGrower.partitionEntry.FirstLBA*sectorSize))
+	return geom, nil
+}
+
+// resizeFilesystem grows the mounted root filesystem to the number of blocks
+func resizeFilesystem(format Format, blocks uint64) error {
+
+	rootFS, err := os.Open("/")
 	if err != nil {
 		return err
 	}
+	defer rootFS.Close()
 
-	switch format {
-	case XFS:
-		logDebug("detected xfs filesystem")
-		x := new(xfsGrowFSData)
-
-		geom := new(xfsFsopGeom)
-		if _, _, e := syscall.Syscall(
-			syscall.SYS_IOCTL,
-			uintptr(rootFS.Fd()),
-			uintptr(xfsGeom),
-			uintptr(unsafe.Pointer(geom)),
-		); e != 0 {
-			return fmt.Errorf("error getting xfs geometry: %s", syscall.Errno(e))
+	if format == XFS {
+		geom, err := xfsGeometry(rootFS)
+		if err != nil {
+			return err
 		}
 
-		x.newBlocks = blocks
-		x.imaxpct = geom.iMaxPct
+		x := &xfsGrowFSData{
+			newBlocks: blocks,
+			imaxpct:   geom.iMaxPct,
+		}
 
 		if _, _, e := syscall.Syscall(
 			syscall.SYS_IOCTL,
@@ -229,19 +356,16 @@ func growDisk(f *os.File, p string) error {
 			return fmt.Errorf("error resizing xfs filesystem: %s", syscall.Errno(e))
 		}
 
-	case Ext2FS:
-		fallthrough
-	case Ext4FS:
-		logDebug("detected ext filesystem")
-		resizeFSFlag := uintptr(ext4IOCResizeFS)
-		if _, _, e := syscall.Syscall(
-			syscall.SYS_IOCTL,
-			uintptr(rootFS.Fd()),
-			resizeFSFlag,
-			uintptr(unsafe.Pointer(&blocks)),
-		); e != 0 {
-			return fmt.Errorf("error resizing ext filesystem: %s", syscall.Errno(e))
-		}
+		return nil
+	}
+
+	if _, _, e := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		uintptr(rootFS.Fd()),
+		uintptr(ext4IOCResizeFS),
+		uintptr(unsafe.Pointer(&blocks)),
+	); e != 0 {
+		return fmt.Errorf("error resizing ext filesystem: %s", syscall.Errno(e))
 	}
 
 	return nil
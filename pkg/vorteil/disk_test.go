@@ -1,6 +1,7 @@
 package vorteil
 
 import (
+	"encoding/binary"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -33,3 +34,62 @@ func TestSetupBasicDirectories(t *testing.T) {
 	assert.True(t, len(f) > 0)
 
 }
+
+func TestExt4Size(t *testing.T) {
+
+	sb := make([]byte, 1024)
+	binary.LittleEndian.PutUint32(sb[4:], 1000)
+	binary.LittleEndian.PutUint32(sb[24:], 2)
+	binary.LittleEndian.PutUint32(sb[0x150:], 1)
+
+	blocks, bs := ext4Size(sb)
+	assert.Equal(t, uint64(1000), blocks)
+	assert.Equal(t, uint64(4096), bs)
+
+	binary.LittleEndian.PutUint32(sb[0x60:], ext4Feature64Bit)
+	blocks, _ = ext4Size(sb)
+	assert.Equal(t, uint64(1<<32+1000), blocks)
+
+}
+
+func TestGrowFilesystem(t *testing.T) {
+
+	New(testLogFn)
+
+	size, resize := fsSize, fsResize
+	defer func() {
+		fsSize, fsResize = size, resize
+	}()
+
+	var (
+		fsBytes uint64 = 1 << 30
+		grown   uint64
+	)
+
+	fsSize = func(format Format, part string) (uint64, uint64, error) {
+		return fsBytes, 4096, nil
+	}
+	fsResize = func(format Format, blocks uint64) error {
+		grown = blocks
+		return nil
+	}
+
+	assert.False(t, needsGrow(1<<30, 1<<30))
+	assert.False(t, needsGrow(1<<30, 1<<30+growMinimum-1))
+	assert.True(t, needsGrow(1<<30, 1<<31))
+
+	assert.NoError(t, growFilesystem(Ext4FS, "/dev/vda2", 1<<31))
+	assert.Equal(t, uint64(1<<31/4096), grown)
+
+	// full size already
+	grown = 0
+	fsBytes = 1 << 31
+	assert.NoError(t, growFilesystem(XFS, "/dev/vda2", 1<<31))
+	assert.Zero(t, grown)
+
+	// unknown formats are skipped
+	fsBytes = 1 << 30
+	assert.NoError(t, growFilesystem(UnknownFS, "/dev/vda2", 1<<31))
+	assert.Zero(t, grown)
+
+}
@@ -0,0 +1,129 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	diskLogFile = "system.log"
+
+	// lines kept until the disk is available
+	diskLogPending = 1000
+)
+
+// writes messages to the boot disk if enabled
+var diskLog *diskLogSink
+
+// diskLogSink appends all messages to a file on the boot disk. Messages are
+// kept in memory until the disk is mounted.
+type diskLogSink struct {
+	lock    sync.Mutex
+	pending *logRing
+	file    *os.File
+	closed  bool
+	next    logFn
+
+	copies screenCopies
+}
+
+func newDiskLogSink(next logFn) *diskLogSink {
+	return &diskLogSink{
+		pending: newLogRing(diskLogPending),
+		next:    next,
+	}
+}
+
+func (s *diskLogSink) log(level LogLevel, format string, values ...interface{}) {
+
+	s.next(level, format, values...)
+
+	msg := fmt.Sprintf(format, values...)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed || s.copies.skip(level, msg) {
+		return
+	}
+
+	line := fmt.Sprintf("%s %s", logPrefix(kernelOpts.logTimestamp, time.Now(), uptime()), msg)
+	if s.file == nil {
+		s.pending.add(line)
+		return
+	}
+
+	fmt.Fprintln(s.file, line)
+
+}
+
+// open starts writing to the file, beginning with the messages kept so far
+func (s *diskLogSink) open(path string) error {
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, l := range s.pending.entries() {
+		fmt.Fprintln(f, l)
+	}
+	s.pending = newLogRing(0)
+	s.file = f
+
+	return nil
+}
+
+// close writes the file to disk before shutdown. Later messages are not
+// written anymore.
+func (s *diskLogSink) close() {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	if s.file != nil {
+		s.file.Sync()
+		s.file.Close()
+		s.file = nil
+	}
+
+}
+
+// openDiskLog starts writing messages to the boot disk once it is mounted
+func openDiskLog() {
+
+	if diskLog == nil {
+		return
+	}
+
+	path := filepath.Join(logsDir, diskLogFile)
+	err := diskLog.open(path)
+	if err != nil {
+		logWarn("can not write messages to %s: %s", path, err.Error())
+	}
+
+}
+
+// closeDiskLog persists the messages before the disk gets flushed
+func closeDiskLog() {
+	if diskLog != nil {
+		diskLog.close()
+	}
+}
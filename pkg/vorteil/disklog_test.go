@@ -0,0 +1,49 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskLog(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "disklog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldDir := logsDir
+	logsDir = filepath.Join(dir, "logs")
+	diskLog = newDiskLogSink(vlog)
+	vlog = diskLog.log
+	defer func() {
+		logsDir = oldDir
+		diskLog = nil
+	}()
+
+	// kept in memory before the disk is mounted
+	logAlways("booting")
+	logWarn("early %d", 1)
+
+	openDiskLog()
+	logError("mounted")
+
+	closeDiskLog()
+	logDebug("after flush")
+
+	b, err := ioutil.ReadFile(filepath.Join(logsDir, diskLogFile))
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 3)
+	for i, s := range []string{"] booting", "] early 1", "] mounted"} {
+		assert.True(t, strings.HasSuffix(lines[i], s), lines[i])
+	}
+
+}
@@ -6,6 +6,8 @@
 package vorteil
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net"
 	"strings"
 
@@ -14,8 +16,14 @@ import (
 
 const (
 	defaultDNSAddr = "127.0.0.1:53"
+
+	// the resolver in libc uses up to three name servers
+	maxNameservers = 3
 )
 
+// replaced in tests
+var resolvConfFile = "/etc/resolv.conf"
+
 func printDNS(dns []string) {
 	if len(dns) > 0 {
 		logAlways("dns\t\t: %s", strings.Join(dns, ", "))
@@ -67,3 +75,44 @@ func (v *Vinitd) startDNS(dnsAddr string, verbose bool) error {
 
 	return nil
 }
+
+// resolvConf renders the resolver configuration. If the local dns server is
+// running it is asked first and the others are fallbacks.
+func resolvConf(local bool, servers []net.IP, search []string) string {
+
+	var str strings.Builder
+
+	if len(search) > 0 {
+		str.WriteString(fmt.Sprintf("search %s\n", strings.Join(search, " ")))
+	}
+
+	if local {
+		host, _, _ := net.SplitHostPort(defaultDNSAddr)
+		servers = append([]net.IP{net.ParseIP(host)}, servers...)
+	}
+
+	servers = uniqueIPs(servers)
+	if len(servers) > maxNameservers {
+		logDebug("using %d of %d name servers in %s", maxNameservers, len(servers), resolvConfFile)
+		servers = servers[:maxNameservers]
+	}
+
+	for _, s := range servers {
+		str.WriteString(fmt.Sprintf("nameserver %s\n", s))
+	}
+
+	return str.String()
+}
+
+// writeResolvConf replaces the default resolv.conf if there are name servers
+func (v *Vinitd) writeResolvConf(local bool) error {
+
+	if len(v.dns) == 0 {
+		return nil
+	}
+
+	logDebug("writing %s", resolvConfFile)
+
+	return ioutil.WriteFile(resolvConfFile,
+		[]byte(resolvConf(local, v.dns, uniqueStrings(v.dnsSearch))), 0644)
+}
@@ -2,7 +2,10 @@ package vorteil
 
 import (
 	"context"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -41,3 +44,41 @@ func TestReadVCFGFile1(t *testing.T) {
 	assert.NotNil(t, ip)
 
 }
+
+func TestResolvConf(t *testing.T) {
+
+	New(testLogFn)
+
+	assert.Equal(t, "nameserver 8.8.8.8\nnameserver 2001:4860:4860::8888\n",
+		resolvConf(false, []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("2001:4860:4860::8888"),
+			net.ParseIP("8.8.8.8")}, nil))
+
+	// local server first, at most three
+	assert.Equal(t, "search example.com corp.example.com\nnameserver 127.0.0.1\nnameserver 8.8.8.8\nnameserver 1.1.1.1\n",
+		resolvConf(true, []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("1.1.1.1"), net.ParseIP("9.9.9.9")},
+			[]string{"example.com", "corp.example.com"}))
+
+	// the local server is not listed twice
+	assert.Equal(t, "nameserver 127.0.0.1\n", resolvConf(true, []net.IP{net.ParseIP("127.0.0.1")}, nil))
+
+	dir, err := ioutil.TempDir("", "resolv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rc := resolvConfFile
+	defer func() { resolvConfFile = rc }()
+	resolvConfFile = filepath.Join(dir, "resolv.conf")
+
+	// nothing written without servers
+	v := &Vinitd{dnsSearch: []string{"example.com"}}
+	assert.NoError(t, v.writeResolvConf(false))
+	assert.NoFileExists(t, resolvConfFile)
+
+	v.dns = []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.1")}
+	v.dnsSearch = append(v.dnsSearch, "example.com")
+	assert.NoError(t, v.writeResolvConf(false))
+	b, err := ioutil.ReadFile(resolvConfFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "search example.com\nnameserver 10.0.0.1\n", string(b))
+
+}
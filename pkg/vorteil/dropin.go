@@ -0,0 +1,103 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+const (
+	dropInDir = "/etc/vinitd/programs.d"
+	dropInExt = ".json"
+)
+
+// dropIn is a single program in the drop-in directory. it has the same
+// format as a program in vcfg.
+type dropIn struct {
+	vcfg.Program
+
+	// disabled drop-ins are skipped, enabled if not set
+	Enabled *bool `json:"enabled"`
+}
+
+func parseDropIn(b []byte) (*vcfg.Program, error) {
+
+	var d dropIn
+
+	err := json.Unmarshal(b, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Enabled != nil && !*d.Enabled {
+		return nil, nil
+	}
+
+	if d.Binary == "" {
+		return nil, fmt.Errorf("binary missing")
+	}
+
+	_, err = d.ProgramArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &d.Program, nil
+}
+
+// loadDropIns reads the programs from all drop-in files in lexical order.
+// Invalid files are logged and skipped.
+func loadDropIns(dir string) []vcfg.Program {
+
+	var progs []vcfg.Program
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("can not read drop-in directory %s: %s", dir, err.Error())
+		}
+		return nil
+	}
+
+	for _, f := range files {
+
+		if f.IsDir() || !strings.HasSuffix(f.Name(), dropInExt) {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			logError("can not read drop-in %s: %s", path, err.Error())
+			continue
+		}
+
+		p, err := parseDropIn(b)
+		if err != nil {
+			logError("invalid drop-in %s: %s", path, err.Error())
+			continue
+		}
+
+		if p == nil {
+			logDebug("drop-in %s disabled", path)
+			continue
+		}
+
+		logDebug("adding program %s from drop-in %s", p.Binary, path)
+		progs = append(progs, *p)
+
+	}
+
+	return progs
+}
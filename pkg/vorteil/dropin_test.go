@@ -0,0 +1,44 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDropIns(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "programs.d")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"20-second.json": `{"binary": "/second", "args": "-v"}`,
+		"10-first.json":  `{"binary": "/first"}`,
+		"30-off.json":    `{"binary": "/off", "enabled": false}`,
+		"40-broken.json": `{"binary": `,
+		"50-nobin.json":  `{"args": "-v"}`,
+		"60-on.json":     `{"binary": "/on", "enabled": true}`,
+		"README":         `not a drop-in`,
+	}
+
+	for n, c := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, n), []byte(c), 0644))
+	}
+
+	progs := loadDropIns(dir)
+	assert.Len(t, progs, 3)
+	assert.Equal(t, "/first", progs[0].Binary)
+	assert.Equal(t, "/second", progs[1].Binary)
+	assert.Equal(t, "-v", progs[1].Args)
+	assert.Equal(t, "/on", progs[2].Binary)
+
+	// missing directory is fine
+	assert.Empty(t, loadDropIns(filepath.Join(dir, "missing")))
+
+}
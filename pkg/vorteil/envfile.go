@@ -0,0 +1,227 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// parseEnvValue removes quotes of a value. Double quotes support escapes,
+// single quotes are literal. Unquoted values end at a comment.
+func parseEnvValue(v string) (string, error) {
+
+	if len(v) == 0 {
+		return v, nil
+	}
+
+	switch v[0] {
+	case '\'':
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("unterminated quote")
+		}
+		return v[1 : len(v)-1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(v); i++ {
+			switch {
+			case v[i] == '\\' && i+1 < len(v):
+				i++
+				if v[i] == 'n' {
+					b.WriteByte('\n')
+				} else {
+					b.WriteByte(v[i])
+				}
+			case v[i] == '"' && i == len(v)-1:
+				return b.String(), nil
+			case v[i] == '"':
+				return "", fmt.Errorf("unexpected quote")
+			default:
+				b.WriteByte(v[i])
+			}
+		}
+		return "", fmt.Errorf("unterminated quote")
+	}
+
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = v[:i]
+	}
+
+	return strings.TrimSpace(v), nil
+}
+
+// parseEnvFile reads KEY=VALUE lines. Empty lines and lines starting with #
+// are ignored, a leading 'export' is allowed.
+func parseEnvFile(content string) ([]string, error) {
+
+	var env []string
+
+	for i, l := range strings.Split(content, "\n") {
+
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		l = strings.TrimPrefix(l, "export ")
+
+		kv := strings.SplitN(l, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: not a KEY=VALUE pair", i+1)
+		}
+
+		val, err := parseEnvValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+
+		env = append(env, fmt.Sprintf(environString, key, val))
+	}
+
+	return env, nil
+}
+
+// mergeEnv adds the variables to the environment, replacing existing ones
+func mergeEnv(env, add []string) []string {
+
+	idx := make(map[string]int)
+	for i, e := range env {
+		idx[strings.SplitN(e, "=", 2)[0]] = i
+	}
+
+	for _, a := range add {
+		k := strings.SplitN(a, "=", 2)[0]
+		if i, ok := idx[k]; ok {
+			env[i] = a
+			continue
+		}
+		idx[k] = len(env)
+		env = append(env, a)
+	}
+
+	return env
+}
+
+// loadEnvFiles reads the environment files in order. Files with a leading
+// '-' are optional and skipped if missing.
+func loadEnvFiles(files []string) ([]string, error) {
+
+	var env []string
+
+	for _, f := range files {
+
+		optional := strings.HasPrefix(f, "-")
+		f = strings.TrimPrefix(f, "-")
+
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			if optional && os.IsNotExist(err) {
+				logDebug("optional environment file %s missing", f)
+				continue
+			}
+			return nil, fmt.Errorf("can not read environment file %s: %s", f, err.Error())
+		}
+
+		e, err := parseEnvFile(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("environment file %s: %s", f, err.Error())
+		}
+
+		env = mergeEnv(env, e)
+	}
+
+	return env, nil
+}
+
+// programEnv layers the environment of a program. The configured variables
+// override the global environment file, the program's own environment files
+// override both. The global file is optional.
+func programEnv(global string, env, files []string) ([]string, error) {
+
+	if global != "" {
+		g, err := loadEnvFiles([]string{"-" + global})
+		if err != nil {
+			return nil, err
+		}
+		env = mergeEnv(g, env)
+	}
+
+	fileEnvs, err := loadEnvFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeEnv(env, fileEnvs), nil
+}
+
+// envMap converts KEY=VALUE pairs into a map
+func envMap(env []string) map[string]string {
+	m := make(map[string]string)
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 {
+			m[kv[0]] = kv[1]
+		}
+	}
+	return m
+}
+
+// expandVars replaces $VAR, ${VAR} and ${VAR:-fallback} with values of the
+// environment. The fallback is used if the variable is unset or empty, $$ is
+// a literal $. Undefined variables without fallback are replaced with an
+// empty string.
+func expandVars(s string, env map[string]string) string {
+
+	return os.Expand(s, func(name string) string {
+
+		if name == "$" {
+			return "$"
+		}
+
+		var (
+			fallback    string
+			hasFallback bool
+		)
+
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, fallback, hasFallback = name[:i], name[i+2:], true
+		}
+
+		if v, ok := env[name]; ok && (v != "" || !hasFallback) {
+			return v
+		}
+
+		if hasFallback {
+			return fallback
+		}
+
+		logWarn("variable %s is not defined", name)
+
+		return ""
+	})
+
+}
+
+// expandEnv expands the variables in the values of the environment with the
+// environment itself
+func expandEnv(env []string) []string {
+
+	m := envMap(env)
+
+	var expanded []string
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 2 {
+			e = fmt.Sprintf(environString, kv[0], expandVars(kv[1], m))
+		}
+		expanded = append(expanded, e)
+	}
+
+	return expanded
+}
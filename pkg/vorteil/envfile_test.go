@@ -0,0 +1,145 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnvFile(t *testing.T) {
+
+	env, err := parseEnvFile(`
+# database settings
+DB_HOST=db.local
+export DB_PORT = 5432
+DB_PASS="se\"cr#et\\"
+DB_NAME='my $db'
+DB_OPTS=a=b # comment
+MULTI="one\ntwo"
+EMPTY=
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"DB_HOST=db.local",
+		"DB_PORT=5432",
+		"DB_PASS=se\"cr#et\\",
+		"DB_NAME=my $db",
+		"DB_OPTS=a=b",
+		"MULTI=one\ntwo",
+		"EMPTY=",
+	}, env)
+
+	_, err = parseEnvFile("NOVALUE")
+	assert.Error(t, err)
+
+	_, err = parseEnvFile("A=\"open")
+	assert.Error(t, err)
+
+	_, err = parseEnvFile("A='open")
+	assert.Error(t, err)
+
+	_, err = parseEnvFile("MY KEY=1")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"A=3", "B=2", "C=4"},
+		mergeEnv([]string{"A=1", "B=2"}, []string{"A=3", "C=4"}))
+
+}
+
+func TestLoadEnvFiles(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "envfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f1 := filepath.Join(dir, "a.env")
+	f2 := filepath.Join(dir, "b.env")
+	assert.NoError(t, ioutil.WriteFile(f1, []byte("A=1\nB=1\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(f2, []byte("B=2\n"), 0644))
+
+	env, err := loadEnvFiles([]string{f1, "-" + filepath.Join(dir, "missing"), f2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=1", "B=2"}, env)
+
+	_, err = loadEnvFiles([]string{filepath.Join(dir, "missing")})
+	assert.Error(t, err)
+
+}
+
+func TestProgramEnv(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "envfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	global := filepath.Join(dir, "environment")
+	own := filepath.Join(dir, "own.env")
+	assert.NoError(t, ioutil.WriteFile(global, []byte(`
+# shared by all programs
+export REGION="eu west"
+LEVEL=info
+
+TOKEN='global'
+`), 0644))
+	assert.NoError(t, ioutil.WriteFile(own, []byte("TOKEN=own\n"), 0644))
+
+	// program variables override the global file, its own files override both
+	env, err := programEnv(global, []string{"LEVEL=debug", "TOKEN=cfg"}, []string{own})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"REGION=eu west", "LEVEL=debug", "TOKEN=own"}, env)
+
+	// a missing or disabled global file is not an error
+	env, err = programEnv(filepath.Join(dir, "missing"), []string{"A=1"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=1"}, env)
+
+	env, err = programEnv("", []string{"A=1"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A=1"}, env)
+
+	assert.NoError(t, ioutil.WriteFile(global, []byte("BROKEN\n"), 0644))
+	_, err = programEnv(global, nil, nil)
+	assert.Error(t, err)
+
+	o, err := parseKernelOptions("vinitd.env-file=" + global)
+	assert.NoError(t, err)
+	assert.Equal(t, global, o.envFile)
+
+	_, err = parseKernelOptions("vinitd.env-file=env")
+	assert.Error(t, err)
+
+}
+
+func TestExpandVars(t *testing.T) {
+
+	New(testLogFn)
+
+	env := map[string]string{
+		"HOSTNAME": "vm1",
+		"HOST":     "wrong",
+		"DATA_DIR": "/data",
+		"EMPTY":    "",
+	}
+
+	assert.Equal(t, "vm1", expandVars("$HOSTNAME", env))
+	assert.Equal(t, "/data/db", expandVars("${DATA_DIR}/db", env))
+	assert.Equal(t, "--dir=/data", expandVars("--dir=${DATA_DIR:-/tmp}", env))
+	assert.Equal(t, "--dir=/tmp", expandVars("--dir=${MISSING:-/tmp}", env))
+	assert.Equal(t, "/tmp", expandVars("${EMPTY:-/tmp}", env))
+	assert.Equal(t, "", expandVars("$EMPTY", env))
+	assert.Equal(t, "a--b", expandVars("a-$MISSING-b", env))
+	assert.Equal(t, "price $5", expandVars("price $$5", env))
+
+	assert.Equal(t, []string{"-h", "vm1", "/data/x"}, args([]string{"-h", "$HOSTNAME", "${DATA_DIR}/x"},
+		[]string{"HOSTNAME=vm1", "HOST=wrong", "DATA_DIR=/data"}))
+
+	assert.Equal(t, []string{"A=1", "B=1-x", "C=dflt"}, expandEnv([]string{"A=1", "B=${A}-x", "C=${D:-dflt}"}))
+
+}
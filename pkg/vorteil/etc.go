@@ -6,18 +6,35 @@
 package vorteil
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
 
 	"github.com/rakyll/statik/fs"
 )
 
+const (
+	machineIDFile = "/etc/machine-id"
+	dmiUUIDFile   = "/sys/class/dmi/id/product_uuid"
+
+	machineIDDMI      = "dmi"
+	machineIDRandom   = "random"
+	machineIDHostname = "hostname"
+)
+
 var (
 	etcFiles = []string{"group", "localtime", "nsswitch.conf", "passwd", "resolv.conf"}
+
+	machineIDRegex = regexp.MustCompile(`^[0-9a-f]{32}$`)
 )
 
 func writeEtcFile(baseName, fullName string) error {
@@ -48,8 +65,8 @@ func writeEtcFile(baseName, fullName string) error {
 
 func generateEtcHosts(hostname string) {
 
-	if _, err := os.Stat("/etc/hosts"); os.IsNotExist(err) {
-		logDebug("file /etc/hosts does not exist, creating")
+	if _, err := os.Stat(etcHostsFile); os.IsNotExist(err) {
+		logDebug("file %s does not exist, creating", etcHostsFile)
 
 		var str strings.Builder
 		str.WriteString("127.0.0.1\tlocalhost\n")
@@ -61,10 +78,23 @@ func generateEtcHosts(hostname string) {
 		str.WriteString("ff02::1\tip6-allnodes\n")
 		str.WriteString("ff02::2\tip6-allrouters\n")
 
-		err = ioutil.WriteFile("/etc/hosts", []byte(str.String()), 0644)
+		err = ioutil.WriteFile(etcHostsFile, []byte(str.String()), 0644)
 		if err != nil {
-			logError("can not create %s file: %v", "/etc/hosts", err)
+			logError("can not create %s file: %v", etcHostsFile, err)
 		}
+		return
+	}
+
+	// keep existing entries but point 127.0.1.1 to this host
+	hosts, err := ioutil.ReadFile(etcHostsFile)
+	if err != nil {
+		logError("can not read %s file: %v", etcHostsFile, err)
+		return
+	}
+
+	err = ioutil.WriteFile(etcHostsFile, []byte(hostsWithHostname(string(hosts), hostname)), 0644)
+	if err != nil {
+		logError("can not update %s file: %v", etcHostsFile, err)
 	}
 
 }
@@ -134,7 +164,7 @@ func etcGenerateFiles(hostname, user string) error {
 	}
 
 	// set hostname (/etc/hostname)
-	f, err := os.OpenFile("/etc/hostname", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(etcHostnameFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -150,3 +180,97 @@ func etcGenerateFiles(hostname, user string) error {
 
 	return nil
 }
+
+// deriveMachineID creates a stable machine id from a seed, e.g. the DMI uuid
+func deriveMachineID(seed string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(seed))))
+	return hex.EncodeToString(h[:16])
+}
+
+func randomMachineID() (string, error) {
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	// format as uuid v4 like systemd does
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return hex.EncodeToString(b), nil
+}
+
+func dmiMachineID() (string, error) {
+
+	uuid, err := ioutil.ReadFile(dmiUUIDFile)
+	if err != nil {
+		return "", err
+	}
+
+	if len(strings.TrimSpace(string(uuid))) == 0 {
+		return "", fmt.Errorf("empty dmi uuid")
+	}
+
+	return deriveMachineID(string(uuid)), nil
+}
+
+// generateMachineID creates a new machine id from source which can be
+// 'dmi' (default), 'random' or 'hostname'
+func generateMachineID(source, hostname string) (string, error) {
+
+	switch source {
+	case machineIDRandom:
+		return randomMachineID()
+	case machineIDHostname:
+		if len(hostname) == 0 {
+			return "", fmt.Errorf("no hostname for machine id")
+		}
+		return deriveMachineID(hostname), nil
+	case machineIDDMI, "":
+		id, err := dmiMachineID()
+		if err != nil {
+			logWarn("can not read dmi uuid, using random machine id: %s", err.Error())
+			return randomMachineID()
+		}
+		return id, nil
+	}
+
+	return "", fmt.Errorf("unknown machine id source %s", source)
+}
+
+// setupMachineID reuses /etc/machine-id or generates and persists a new one.
+// On a read-only root the id is derived from DMI and not persisted.
+func setupMachineID(source, hostname string) (string, error) {
+
+	if b, err := ioutil.ReadFile(machineIDFile); err == nil {
+		id := strings.TrimSpace(string(b))
+		if machineIDRegex.MatchString(id) {
+			return id, nil
+		}
+		logWarn("invalid machine id %s, generating new one", id)
+	}
+
+	id, err := generateMachineID(source, hostname)
+	if err != nil {
+		return "", err
+	}
+
+	err = withWritableRoot("machine id", func() error {
+		return ioutil.WriteFile(machineIDFile, []byte(fmt.Sprintf("%s\n", id)), 0444)
+	})
+	if errors.Is(err, syscall.EROFS) {
+		logWarn("read-only root, machine id not persisted")
+		if dmi, err := dmiMachineID(); err == nil {
+			return dmi, nil
+		}
+		return id, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	logDebug("created machine id %s", id)
+
+	return id, nil
+}
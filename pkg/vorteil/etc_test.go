@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -66,3 +67,31 @@ func TestEtcFiles(t *testing.T) {
 	assert.NotEqual(t, g, ga)
 
 }
+
+func TestGenerateMachineID(t *testing.T) {
+
+	New(testLogFn)
+
+	valid := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	a, err := generateMachineID(machineIDHostname, testString)
+	assert.NoError(t, err)
+	assert.Regexp(t, valid, a)
+
+	// derived ids are stable
+	b, err := generateMachineID(machineIDHostname, testString)
+	assert.NoError(t, err)
+	assert.Equal(t, a, b)
+
+	_, err = generateMachineID(machineIDHostname, "")
+	assert.Error(t, err)
+
+	r, err := generateMachineID(machineIDRandom, "")
+	assert.NoError(t, err)
+	assert.Regexp(t, valid, r)
+	assert.NotEqual(t, a, r)
+
+	_, err = generateMachineID("unknown", "")
+	assert.Error(t, err)
+
+}
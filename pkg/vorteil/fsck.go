@@ -0,0 +1,313 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// fsck exit codes, bits can be combined
+const (
+	fsckClean       = 0
+	fsckCorrected   = 1
+	fsckReboot      = 2
+	fsckUncorrected = 4
+	fsckFailed      = 8
+
+	// AppFsck is the helper to check the boot disk manually
+	AppFsck = "/sbin/vfsck"
+
+	// check of the boot disk during boot
+	fsckModeOff    = "off"
+	fsckModeCheck  = "check"
+	fsckModeRepair = "repair"
+	fsckModePanic  = "panic"
+
+	// outcome of a check from the fsck exit code
+	fsckResultClean     = "clean"
+	fsckResultCorrected = "corrected"
+	fsckResultReboot    = "reboot"
+	fsckResultErrors    = "errors"
+	fsckResultFailed    = "failed"
+)
+
+var (
+	toolDirs = []string{"/vorteil", "/sbin", "/usr/sbin", "/bin", "/usr/bin"}
+
+	// replaced in tests
+	fsckRun     = checkFilesystem
+	fsckRemount = remountRoot
+
+	errFsckReboot = errors.New("filesystem repaired, reboot required")
+)
+
+// findTool returns the path of an external tool or an empty string
+func findTool(name string) string {
+	for _, d := range toolDirs {
+		p := filepath.Join(d, name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// fsckCommand returns the command to check the filesystem. repair fixes
+// errors, otherwise it is a read-only check
+func fsckCommand(format Format, part string, repair bool) (*exec.Cmd, error) {
+
+	switch format {
+	case Ext2FS, Ext4FS:
+		tool := findTool("e2fsck")
+		if tool == "" {
+			return nil, fmt.Errorf("e2fsck not available")
+		}
+		mode := "-n"
+		if repair {
+			mode = "-p"
+		}
+		return exec.Command(tool, "-f", mode, part), nil
+	case XFS:
+		tool := findTool("xfs_repair")
+		if tool == "" {
+			return nil, fmt.Errorf("xfs_repair not available")
+		}
+		if repair {
+			return exec.Command(tool, part), nil
+		}
+		return exec.Command(tool, "-n", part), nil
+	}
+
+	return nil, fmt.Errorf("can not check filesystem %s", format)
+}
+
+// fsckResult maps the fsck exit code to the outcome of the check, a needed
+// reboot takes precedence
+func fsckResult(code int) string {
+
+	switch {
+	case code&fsckReboot != 0:
+		return fsckResultReboot
+	case code&fsckUncorrected != 0:
+		return fsckResultErrors
+	case code >= fsckFailed:
+		return fsckResultFailed
+	case code&fsckCorrected != 0:
+		return fsckResultCorrected
+	}
+
+	return fsckResultClean
+}
+
+// checkFilesystem runs fsck on the partition and returns the fsck exit code
+func checkFilesystem(part string, repair bool) (int, error) {
+
+	f, err := os.Open(part)
+	if err != nil {
+		return fsckFailed, err
+	}
+	format, err := detectFormat(f, part, 0)
+	f.Close()
+	if err != nil {
+		return fsckFailed, err
+	}
+
+	cmd, err := fsckCommand(format, part, repair)
+	if err != nil {
+		return fsckFailed, err
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	logDebug("checking %s filesystem on %s, repair %v", format, part, repair)
+
+	// the reaper runs already during boot
+	err = runReaped(cmd)
+
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode(), nil
+	} else if err != nil {
+		return fsckFailed, err
+	}
+
+	return fsckClean, nil
+}
+
+// fsckBootDisk checks the root partition of the boot disk before it gets
+// remounted with the final mount options
+func fsckBootDisk(mode string) error {
+
+	if mode == fsckModeOff {
+		return nil
+	}
+
+	disk, err := bootDisk()
+	if err != nil {
+		return err
+	}
+
+	return checkRootFilesystem(mode, fmt.Sprintf("%s2", disk))
+}
+
+// checkRootFilesystem runs fsck on the root partition. Errors of the
+// filesystem are only logged in check mode, otherwise they stop the boot.
+func checkRootFilesystem(mode, part string) error {
+
+	repair := mode == fsckModeRepair
+
+	// the check is unreliable and repairs are unsafe on a writable filesystem
+	err := fsckRemount(true)
+	if err != nil {
+		logWarn("%s", err.Error())
+		repair = false
+	} else {
+		defer func() {
+			if err := fsckRemount(false); err != nil {
+				logError("%s", err.Error())
+			}
+		}()
+	}
+
+	code, err := fsckRun(part, repair)
+	if err != nil {
+		logWarn("can not check %s: %s", part, err.Error())
+		return nil
+	}
+
+	switch fsckResult(code) {
+	case fsckResultReboot:
+		return errFsckReboot
+	case fsckResultErrors, fsckResultFailed:
+		if mode == fsckModeCheck {
+			logWarn("filesystem on %s has errors (fsck exit code %d)", part, code)
+			return nil
+		}
+		return fmt.Errorf("filesystem on %s has errors (fsck exit code %d)", part, code)
+	case fsckResultCorrected:
+		logAlways("filesystem errors on %s corrected", part)
+	default:
+		logAlways("filesystem on %s clean", part)
+	}
+
+	return nil
+}
+
+func prepSbinFsck() {
+	os.Remove(AppFsck)
+	err := os.Symlink("/vorteil/vinitd", AppFsck)
+	if err != nil {
+		logWarn("can not create %s: %s", AppFsck, err.Error())
+	}
+}
+
+// RunFsck checks the boot disk from a shell, e.g. in rescue mode. The root
+// filesystem is remounted read-only for the check and gets its boot options
+// back afterwards, read-only if it had been before. With '-y' errors get
+// repaired. An optional argument checks another device than the boot disk.
+func RunFsck(args []string) int {
+
+	vlog = LogFnStdout
+
+	var (
+		repair bool
+		part   string
+	)
+
+	for _, a := range args {
+		if a == "-y" {
+			repair = true
+		} else {
+			part = a
+		}
+	}
+
+	if part == "" {
+		disk, err := bootDisk()
+		if err != nil {
+			logError("can not find boot disk: %s", err.Error())
+			return fsckFailed
+		}
+		part = fmt.Sprintf("%s2", disk)
+	}
+
+	readOnly := rootReadOnlyNow()
+
+	err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+	if err != nil {
+		logError("can not remount / read-only: %s", err.Error())
+		if repair {
+			logError("repair requires a read-only root, stop all programs first")
+			return fsckFailed
+		}
+	}
+
+	code, err := checkFilesystem(part, repair)
+	if err != nil {
+		logError("can not check %s: %s", part, err.Error())
+	}
+
+	switch fsckResult(code) {
+	case fsckResultReboot:
+		logAlways("filesystem repaired, reboot required")
+		return code
+	case fsckResultErrors:
+		logAlways("filesystem has errors, run with -y to repair")
+	case fsckResultFailed:
+		logAlways("filesystem check failed (fsck exit code %d)", code)
+	case fsckResultCorrected:
+		logAlways("filesystem errors corrected")
+	default:
+		logAlways("filesystem clean")
+	}
+
+	err = remountBootOptions(readOnly)
+	if err != nil {
+		logError("can not remount / with its boot options, reboot to resume: %s", err.Error())
+	}
+
+	return code
+}
+
+// rootReadOnlyNow reports if / is mounted read-only, e.g. with
+// vinitd.readonly-root or in the rescue shell
+func rootReadOnlyNow() bool {
+
+	f, err := os.Open(procMounts)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	return mountedReadOnly(f, "/")
+}
+
+// remountBootOptions mounts the root filesystem with the options used during
+// boot, read-write unless readOnly is set
+func remountBootOptions(readOnly bool) error {
+
+	_, fstype, err := rootMount()
+	if err != nil {
+		return err
+	}
+
+	opts, err := rootMountOptions(fstype)
+	if err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_REMOUNT | rootMountFlags)
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+
+	return syscall.Mount("", "/", "", flags, opts)
+}
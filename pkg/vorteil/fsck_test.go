@@ -0,0 +1,187 @@
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRootFilesystem(t *testing.T) {
+
+	New(testLogFn)
+
+	run, remount := fsckRun, fsckRemount
+	defer func() {
+		fsckRun, fsckRemount = run, remount
+	}()
+
+	var (
+		code     int
+		repaired bool
+		mounts   []bool
+	)
+
+	fsckRun = func(part string, repair bool) (int, error) {
+		assert.Equal(t, "/dev/vda2", part)
+		repaired = repair
+		return code, nil
+	}
+	fsckRemount = func(readonly bool) error {
+		mounts = append(mounts, readonly)
+		return nil
+	}
+
+	// clean disks boot in all modes
+	for _, m := range []string{fsckModeCheck, fsckModeRepair, fsckModePanic} {
+		mounts = nil
+		assert.NoError(t, checkRootFilesystem(m, "/dev/vda2"))
+		assert.Equal(t, m == fsckModeRepair, repaired)
+		assert.Equal(t, []bool{true, false}, mounts)
+	}
+
+	code = fsckUncorrected
+	assert.NoError(t, checkRootFilesystem(fsckModeCheck, "/dev/vda2"))
+	assert.Error(t, checkRootFilesystem(fsckModePanic, "/dev/vda2"))
+	assert.Error(t, checkRootFilesystem(fsckModeRepair, "/dev/vda2"))
+
+	code = fsckCorrected
+	assert.NoError(t, checkRootFilesystem(fsckModeRepair, "/dev/vda2"))
+
+	code = fsckCorrected | fsckReboot
+	assert.Equal(t, errFsckReboot, checkRootFilesystem(fsckModeRepair, "/dev/vda2"))
+
+	// no repairs on a writable root
+	fsckRemount = func(readonly bool) error {
+		return syscall.EBUSY
+	}
+	code = fsckUncorrected
+	assert.Error(t, checkRootFilesystem(fsckModeRepair, "/dev/vda2"))
+	assert.False(t, repaired)
+
+}
+
+func TestCheckFilesystemReaped(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "fsck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dirs := toolDirs
+	defer func() {
+		toolDirs = dirs
+	}()
+	toolDirs = []string{dir}
+
+	// fake e2fsck exiting with the code in a file
+	codeFile := filepath.Join(dir, "code")
+	err = ioutil.WriteFile(filepath.Join(dir, "e2fsck"),
+		[]byte(fmt.Sprintf("#!/bin/sh\nsleep 0.1\nexit $(cat %s)\n", codeFile)), 0755)
+	assert.NoError(t, err)
+
+	part := filepath.Join(dir, "part")
+	img := make([]byte, 2048)
+	copy(img[1080:], ext2Signature)
+	assert.NoError(t, ioutil.WriteFile(part, img, 0644))
+
+	stop := make(chan bool)
+	defer close(stop)
+
+	// the reaper runs during the boot check
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				reapChildren()
+			}
+		}
+	}()
+
+	for _, c := range []int{fsckClean, fsckCorrected, fsckReboot, fsckUncorrected} {
+		assert.NoError(t, ioutil.WriteFile(codeFile, []byte(fmt.Sprintf("%d", c)), 0644))
+		code, err := checkFilesystem(part, true)
+		assert.NoError(t, err)
+		assert.Equal(t, c, code)
+	}
+
+}
+
+func TestFsckCommand(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "fsck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dirs := toolDirs
+	defer func() {
+		toolDirs = dirs
+	}()
+	toolDirs = []string{dir}
+
+	e2fsck := filepath.Join(dir, "e2fsck")
+	xfsRepair := filepath.Join(dir, "xfs_repair")
+
+	tests := []struct {
+		format Format
+		repair bool
+		args   []string
+	}{
+		{Ext2FS, false, []string{e2fsck, "-f", "-n", "/dev/vda2"}},
+		{Ext2FS, true, []string{e2fsck, "-f", "-p", "/dev/vda2"}},
+		{Ext4FS, true, []string{e2fsck, "-f", "-p", "/dev/vda2"}},
+		{XFS, false, []string{xfsRepair, "-n", "/dev/vda2"}},
+		{XFS, true, []string{xfsRepair, "/dev/vda2"}},
+	}
+
+	// tools not installed
+	for _, tt := range tests {
+		_, err := fsckCommand(tt.format, "/dev/vda2", tt.repair)
+		assert.Error(t, err)
+	}
+
+	assert.NoError(t, ioutil.WriteFile(e2fsck, nil, 0755))
+	assert.NoError(t, ioutil.WriteFile(xfsRepair, nil, 0755))
+
+	for _, tt := range tests {
+		cmd, err := fsckCommand(tt.format, "/dev/vda2", tt.repair)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.args, cmd.Args)
+	}
+
+	_, err = fsckCommand(UnknownFS, "/dev/vda2", false)
+	assert.Error(t, err)
+
+}
+
+func TestFsckResult(t *testing.T) {
+
+	tests := []struct {
+		code   int
+		result string
+	}{
+		{fsckClean, fsckResultClean},
+		{fsckCorrected, fsckResultCorrected},
+		{fsckReboot, fsckResultReboot},
+		{fsckCorrected | fsckReboot, fsckResultReboot},
+		{fsckUncorrected, fsckResultErrors},
+		{fsckCorrected | fsckUncorrected, fsckResultErrors},
+		{fsckReboot | fsckUncorrected, fsckResultReboot},
+		{fsckFailed, fsckResultFailed},
+		{16, fsckResultFailed},
+		{fsckFailed | fsckCorrected, fsckResultFailed},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.result, fsckResult(tt.code), "exit code %d", tt.code)
+	}
+
+}
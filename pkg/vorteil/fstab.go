@@ -0,0 +1,380 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// fstabEntry is a filesystem from /etc/fstab mounted in pre-setup
+type fstabEntry struct {
+	source, target, fstype string
+	flags                  uintptr
+	data                   string
+
+	// failing mounts are logged but do not stop the boot
+	noFail bool
+}
+
+var (
+	fstabFile = "/etc/fstab"
+
+	// mounted entries, unmounted in reverse order on shutdown
+	fstabMounts []string
+
+	// replaced in tests
+	procMounts  = "/proc/mounts"
+	flushDevice = flushDisk
+
+	fstabFlags = map[string]uintptr{
+		"defaults":    0,
+		"auto":        0,
+		"rw":          0,
+		"ro":          syscall.MS_RDONLY,
+		"noatime":     syscall.MS_NOATIME,
+		"nodiratime":  syscall.MS_NODIRATIME,
+		"relatime":    syscall.MS_RELATIME,
+		"strictatime": syscall.MS_STRICTATIME,
+		"nosuid":      syscall.MS_NOSUID,
+		"nodev":       syscall.MS_NODEV,
+		"noexec":      syscall.MS_NOEXEC,
+		"sync":        syscall.MS_SYNCHRONOUS,
+		"dirsync":     syscall.MS_DIRSYNC,
+	}
+)
+
+// parseFstab reads fstab lines of source, target, type and options. Dump and
+// pass are ignored. The root filesystem, swap and noauto entries are skipped.
+func parseFstab(r io.Reader) ([]fstabEntry, error) {
+
+	var entries []fstabEntry
+
+	s := bufio.NewScanner(r)
+	for l := 1; s.Scan(); l++ {
+
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fs := strings.Fields(line)
+		if len(fs) < 3 {
+			return nil, fmt.Errorf("fstab line %d: missing fields", l)
+		}
+
+		e := fstabEntry{
+			source: fs[0],
+			target: filepath.Clean(fs[1]),
+			fstype: fs[2],
+		}
+
+		if fs[2] == "swap" || e.target == "/" {
+			continue
+		}
+
+		if !filepath.IsAbs(fs[1]) {
+			return nil, fmt.Errorf("fstab line %d: invalid target '%s'", l, fs[1])
+		}
+
+		var (
+			data   []string
+			noAuto bool
+		)
+
+		if len(fs) > 3 {
+			for _, o := range strings.Split(fs[3], ",") {
+				f, ok := fstabFlags[o]
+				switch {
+				case ok:
+					e.flags |= f
+				case o == "nofail":
+					e.noFail = true
+				case o == "noauto":
+					noAuto = true
+				case strings.HasPrefix(o, "x-"):
+				default:
+					data = append(data, o)
+				}
+			}
+		}
+
+		if noAuto {
+			continue
+		}
+
+		e.data = strings.Join(data, ",")
+		entries = append(entries, e)
+	}
+
+	return entries, s.Err()
+}
+
+// blockDeviceIDs reads uuid and label of ext and xfs filesystems
+func blockDeviceIDs(dev string) (string, string) {
+
+	f, err := os.Open(dev)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	format, err := detectFormat(f, dev, 0)
+	if err != nil {
+		return "", ""
+	}
+
+	var (
+		uuidOff, labelOff int64
+		labelLen          int
+	)
+
+	switch format {
+	case Ext2FS, Ext4FS:
+		uuidOff, labelOff, labelLen = 1024+0x68, 1024+0x78, 16
+	case XFS:
+		uuidOff, labelOff, labelLen = 32, 108, 12
+	default:
+		return "", ""
+	}
+
+	u := make([]byte, 16)
+	l := make([]byte, labelLen)
+	if _, err := f.ReadAt(u, uuidOff); err != nil {
+		return "", ""
+	}
+	if _, err := f.ReadAt(l, labelOff); err != nil {
+		return "", ""
+	}
+
+	uuid := fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+
+	return uuid, string(bytes.TrimRight(l, "\x00"))
+}
+
+// resolveSource finds the device for UUID= and LABEL= sources, other sources
+// are used as they are
+func resolveSource(source string) (string, error) {
+
+	var (
+		uuid, label string
+	)
+
+	switch {
+	case strings.HasPrefix(source, "UUID="):
+		uuid = strings.ToLower(strings.TrimPrefix(source, "UUID="))
+	case strings.HasPrefix(source, "LABEL="):
+		label = strings.TrimPrefix(source, "LABEL=")
+	default:
+		return source, nil
+	}
+
+	devs, err := ioutil.ReadDir("/sys/class/block")
+	if err != nil {
+		return "", err
+	}
+
+	for _, d := range devs {
+		dev := filepath.Join("/dev", d.Name())
+		u, l := blockDeviceIDs(dev)
+		if (uuid != "" && u == uuid) || (label != "" && l == label) {
+			return dev, nil
+		}
+	}
+
+	return "", fmt.Errorf("no device with %s", source)
+}
+
+func (e fstabEntry) mount(timeout time.Duration) error {
+
+	src, err := resolveSource(e.source)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(src, "/dev/") {
+		err = waitForDevices([]string{src}, timeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(e.target, 0755)
+	if err != nil {
+		return err
+	}
+
+	return syscall.Mount(src, e.target, e.fstype, e.flags, e.data)
+}
+
+// mountFstab mounts the entries in order. Entries without nofail stop at the
+// first error.
+func mountFstab(entries []fstabEntry, timeout time.Duration) error {
+
+	for _, e := range entries {
+
+		err := e.mount(timeout)
+		if err != nil {
+			if e.noFail {
+				logWarn("can not mount %s on %s: %s", e.source, e.target, err.Error())
+				continue
+			}
+			return fmt.Errorf("can not mount %s on %s: %s", e.source, e.target, err.Error())
+		}
+
+		logDebug("mounted %s on %s", e.source, e.target)
+		fstabMounts = append(fstabMounts, e.target)
+	}
+
+	return nil
+}
+
+// setupFstab mounts the filesystems in the fstab file if it exists
+func setupFstab(path string, timeout time.Duration) error {
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := parseFstab(f)
+	if err != nil {
+		return err
+	}
+
+	return mountFstab(entries, timeout)
+}
+
+// unmountFstab unmounts the fstab filesystems on shutdown. Busy filesystems
+// are remounted read-only instead.
+func unmountFstab() {
+
+	for i := len(fstabMounts) - 1; i >= 0; i-- {
+
+		t := fstabMounts[i]
+
+		err := syscall.Unmount(t, 0)
+		if err == nil {
+			continue
+		}
+
+		logDebug("can not unmount %s: %s", t, err.Error())
+		err = syscall.Mount("", t, "", syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+		if err != nil {
+			logWarn("can not remount %s read-only: %s", t, err.Error())
+		}
+	}
+
+	fstabMounts = nil
+}
+
+// writableMounts returns the mount points of read-write filesystems on
+// block devices, the last mounted first
+func writableMounts(r io.Reader) []string {
+
+	var targets []string
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+
+		fs := strings.Fields(s.Text())
+		if len(fs) < 4 || !strings.HasPrefix(fs[0], "/dev/") {
+			continue
+		}
+
+		for _, o := range strings.Split(fs[3], ",") {
+			if o == "rw" {
+				targets = append([]string{fs[1]}, targets...)
+				break
+			}
+		}
+	}
+
+	return targets
+}
+
+// mountedReadOnly reports if the filesystem mounted last on target is
+// read-only
+func mountedReadOnly(r io.Reader, target string) bool {
+
+	var ro bool
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+
+		fs := strings.Fields(s.Text())
+		if len(fs) < 4 || fs[1] != target {
+			continue
+		}
+
+		ro = false
+		for _, o := range strings.Split(fs[3], ",") {
+			if o == "ro" {
+				ro = true
+			}
+		}
+	}
+
+	return ro
+}
+
+// writableDevices returns the block devices of read-write mounts, the last
+// mounted first
+func writableDevices(r io.Reader) []string {
+
+	var (
+		devs []string
+		seen = make(map[string]bool)
+	)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+
+		fs := strings.Fields(s.Text())
+		if len(fs) < 4 || !strings.HasPrefix(fs[0], "/dev/") || seen[fs[0]] {
+			continue
+		}
+
+		for _, o := range strings.Split(fs[3], ",") {
+			if o == "rw" {
+				seen[fs[0]] = true
+				devs = append([]string{fs[0]}, devs...)
+				break
+			}
+		}
+	}
+
+	return devs
+}
+
+// flushMounts writes the buffers of all block devices with writable
+// filesystems to disk
+func flushMounts() {
+
+	f, err := os.Open(procMounts)
+	if err != nil {
+		logWarn("can not read mounts: %s", err.Error())
+		return
+	}
+	devs := writableDevices(f)
+	f.Close()
+
+	for _, d := range devs {
+		logDebug("flushing %s", d)
+		flushDevice(d)
+	}
+}
@@ -0,0 +1,112 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseFstab(t *testing.T) {
+
+	fstab := `# comment
+/dev/vda2 / ext4 defaults 0 1
+UUID=1234 /data ext4 noatime,nofail,commit=30 0 2
+tmpfs /scratch tmpfs size=64m,nosuid
+/dev/vdb none swap sw 0 0
+/dev/vdc /backup xfs noauto 0 0
+`
+
+	entries, err := parseFstab(strings.NewReader(fstab))
+	assert.NoError(t, err)
+	assert.Equal(t, []fstabEntry{
+		{source: "UUID=1234", target: "/data", fstype: "ext4", flags: syscall.MS_NOATIME, data: "commit=30", noFail: true},
+		{source: "tmpfs", target: "/scratch", fstype: "tmpfs", flags: syscall.MS_NOSUID, data: "size=64m"},
+	}, entries)
+
+	_, err = parseFstab(strings.NewReader("tmpfs /scratch\n"))
+	assert.Error(t, err)
+
+	_, err = parseFstab(strings.NewReader("tmpfs scratch tmpfs\n"))
+	assert.Error(t, err)
+
+}
+
+func TestSetupFstab(t *testing.T) {
+
+	New(testLogFn)
+	defer func() {
+		fstabMounts = nil
+	}()
+
+	dir, err := ioutil.TempDir("", "fstab")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "mnt", "scratch")
+	fstab := filepath.Join(dir, "fstab")
+
+	// missing files are fine
+	assert.NoError(t, setupFstab(fstab, 0))
+
+	assert.NoError(t, ioutil.WriteFile(fstab, []byte(
+		"/dev/missing "+filepath.Join(dir, "data")+" ext4 nofail\n"+
+			"tmpfs "+target+" tmpfs size=1m\n"), 0644))
+
+	assert.NoError(t, setupFstab(fstab, 0))
+	assert.Equal(t, []string{target}, fstabMounts)
+
+	var s syscall.Statfs_t
+	assert.NoError(t, syscall.Statfs(target, &s))
+	assert.Equal(t, int64(unix.TMPFS_MAGIC), int64(s.Type))
+	assert.Equal(t, uint64(1024*1024), s.Blocks*uint64(s.Bsize))
+
+	unmountFstab()
+	assert.Empty(t, fstabMounts)
+	assert.NoError(t, syscall.Statfs(target, &s))
+	assert.NotEqual(t, int64(unix.TMPFS_MAGIC), int64(s.Type))
+
+	// without nofail the boot stops
+	assert.NoError(t, ioutil.WriteFile(fstab, []byte(
+		"/dev/missing "+filepath.Join(dir, "data")+" ext4 defaults\n"), 0644))
+	assert.Error(t, setupFstab(fstab, 0))
+
+}
+
+func TestFlushMounts(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "mounts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pm, fd := procMounts, flushDevice
+	defer func() {
+		procMounts, flushDevice = pm, fd
+	}()
+
+	procMounts = filepath.Join(dir, "mounts")
+	assert.NoError(t, ioutil.WriteFile(procMounts, []byte(`/dev/vda2 / ext4 rw,noatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/vdb /data ext4 rw,relatime 0 0
+/dev/vdc /backup xfs ro,relatime 0 0
+tmpfs /tmp tmpfs rw,size=1024k 0 0
+/dev/md0 /raid ext4 rw 0 0
+/dev/vdb /data/bind ext4 rw,relatime 0 0
+`), 0644))
+
+	var flushed []string
+	flushDevice = func(p string) {
+		flushed = append(flushed, p)
+	}
+
+	flushMounts()
+	assert.Equal(t, []string{"/dev/md0", "/dev/vdb", "/dev/vda2"}, flushed)
+
+}
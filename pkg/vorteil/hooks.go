@@ -0,0 +1,156 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	errPreStart = errors.New("pre-start command failed")
+
+	// json files with commands run before the programs are stopped
+	shutdownHookDir = "/etc/vinitd/shutdown.d"
+)
+
+// shutdownHook is a command run on shutdown, timeout in seconds
+type shutdownHook struct {
+	Command string `json:"command"`
+	Timeout int    `json:"timeout"`
+}
+
+// runPreStart runs the pre-start commands in order. The program does not
+// get launched if one of them fails.
+func (p *program) runPreStart() error {
+
+	for _, c := range p.opts.execStartPre {
+		err := p.runHook("pre-start", c, p.opts.hookTimeout)
+		if err != nil {
+			return fmt.Errorf("%w for %s: %s", errPreStart, p.path, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// runPostStop runs the post-stop commands after the program has exited.
+// Failures are only logged, all commands run regardless of the exit code.
+func (p *program) runPostStop() {
+
+	for _, c := range p.opts.execStopPost {
+		err := p.runHook("post-stop", c, p.opts.hookTimeout)
+		if err != nil {
+			logWarn("post-stop command for %s failed: %s", p.path, err.Error())
+		}
+	}
+
+}
+
+func parseShutdownHook(b []byte) (*shutdownHook, error) {
+
+	var h shutdownHook
+
+	err := json.Unmarshal(b, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Command == "" {
+		return nil, fmt.Errorf("command missing")
+	}
+
+	if h.Timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout %d", h.Timeout)
+	}
+
+	return &h, nil
+}
+
+// loadShutdownHooks reads the hooks in lexical order of the files. Invalid
+// files are logged and skipped.
+func loadShutdownHooks(dir string) []shutdownHook {
+
+	var hooks []shutdownHook
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("can not read shutdown hook directory %s: %s", dir, err.Error())
+		}
+		return nil
+	}
+
+	for _, f := range files {
+
+		if f.IsDir() || !strings.HasSuffix(f.Name(), dropInExt) {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			logError("can not read shutdown hook %s: %s", path, err.Error())
+			continue
+		}
+
+		h, err := parseShutdownHook(b)
+		if err != nil {
+			logError("invalid shutdown hook %s: %s", path, err.Error())
+			continue
+		}
+
+		hooks = append(hooks, *h)
+	}
+
+	return hooks
+}
+
+// runShutdownHooks runs the hooks one after the other while the programs
+// are still running. Failures are only logged.
+func runShutdownHooks(hooks []shutdownHook) {
+
+	for _, h := range hooks {
+
+		timeout := defaultHookTimeout
+		if h.Timeout > 0 {
+			timeout = time.Duration(h.Timeout) * time.Second
+		}
+
+		cmd, err := shellCommand("-c", h.Command)
+		if err != nil {
+			logWarn("shutdown hook failed: %s", err.Error())
+			continue
+		}
+
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+
+		logAlways("running shutdown hook: %s", h.Command)
+
+		err = runWithTimeout(cmd, timeout)
+
+		for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if l != "" {
+				logAlways("shutdown hook: %s", l)
+			}
+		}
+
+		if err != nil {
+			logWarn("shutdown hook '%s' failed: %s", h.Command, err.Error())
+		}
+	}
+
+}
@@ -0,0 +1,111 @@
+package vorteil
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func TestProgramHooks(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "hooks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	order := filepath.Join(dir, "order")
+	out := filepath.Join(dir, "out")
+
+	p := &program{
+		path: "/bin/sh",
+		args: []string{"-c", "echo main >> order; exit 3"},
+		vcfgProg: vcfg.Program{
+			Cwd:    dir,
+			Stdout: out,
+			Stderr: out,
+		},
+		opts: programOptions{
+			restart:      restartNever,
+			execStartPre: []string{"echo pre1 >> order", "echo pre2 >> order"},
+			execStopPost: []string{"echo post >> order"},
+			hookTimeout:  time.Second,
+		},
+		backoff: newBackoff(restartMaxDelay),
+		vinitd:  &Vinitd{},
+	}
+
+	err = p.launch("root", nil)
+	assert.NoError(t, err)
+
+	// post-stop runs regardless of the exit code
+	var b []byte
+	for i := 0; i < 100 && string(b) != "pre1\npre2\nmain\npost\n"; i++ {
+		reapChildren()
+		time.Sleep(10 * time.Millisecond)
+		b, _ = ioutil.ReadFile(order)
+	}
+	assert.Equal(t, "pre1\npre2\nmain\npost\n", string(b))
+
+	// failing pre-start command prevents the launch
+	os.Remove(order)
+	p.opts.execStartPre = []string{"exit 1", "echo pre2 >> order"}
+	p.cmd = nil
+
+	// pre-start commands do not need a launch slot, the only one is taken
+	g := newLaunchGate(1, 0)
+	g.acquire("other")
+	defer g.release()
+
+	err = p.launch("root", g)
+	assert.True(t, errors.Is(err, errPreStart))
+	assert.Nil(t, p.cmd)
+	_, err = os.Stat(order)
+	assert.True(t, os.IsNotExist(err))
+
+}
+
+func TestShutdownHooks(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "shutdown")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	order := filepath.Join(dir, "order")
+
+	files := map[string]string{
+		"10-first.json":  `{"command": "echo first >> ` + order + `"}`,
+		"20-hang.json":   `{"command": "sleep 30", "timeout": 1}`,
+		"30-fail.json":   `{"command": "exit 1"}`,
+		"40-last.json":   `{"command": "echo last >> ` + order + `"}`,
+		"50-broken.json": `{"timeout": 5}`,
+		"README":         `not a hook`,
+	}
+	for n, c := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, n), []byte(c), 0644))
+	}
+
+	hooks := loadShutdownHooks(dir)
+	assert.Len(t, hooks, 4)
+	assert.Equal(t, 1, hooks[1].Timeout)
+
+	// the hanging hook is killed and the others still run
+	start := time.Now()
+	runShutdownHooks(hooks)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	b, err := ioutil.ReadFile(order)
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nlast\n", string(b))
+
+	assert.Nil(t, loadShutdownHooks(filepath.Join(dir, "missing")))
+
+}
@@ -0,0 +1,89 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	etcHostsFile    = "/etc/hosts"
+	etcHostnameFile = "/etc/hostname"
+
+	// replaced in tests
+	sethostname = unix.Sethostname
+
+	// rfc 1123 label
+	hostnameLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// checkHostname verifies every label of the hostname
+func checkHostname(hostname string) error {
+
+	for _, l := range strings.Split(hostname, ".") {
+		if !hostnameLabel.MatchString(l) {
+			return fmt.Errorf("hostname %s is not valid, label '%s'", hostname, l)
+		}
+	}
+
+	return nil
+}
+
+// hostsWithHostname replaces the 127.0.1.1 entry of a hosts file or adds it
+func hostsWithHostname(hosts, hostname string) string {
+
+	entry := fmt.Sprintf("127.0.1.1\t%s", hostname)
+
+	lines := strings.Split(strings.TrimRight(hosts, "\n"), "\n")
+	for i, l := range lines {
+		if f := strings.Fields(l); len(f) > 0 && f[0] == "127.0.1.1" {
+			lines[i] = entry
+			return strings.Join(lines, "\n") + "\n"
+		}
+	}
+
+	if hosts == "" {
+		return entry + "\n"
+	}
+
+	return strings.Join(append(lines, entry), "\n") + "\n"
+}
+
+// changeHostname sets the hostname of the kernel and in the files in /etc
+func (v *Vinitd) changeHostname(name string) error {
+
+	hn, err := setHostname(name)
+	if err != nil {
+		return err
+	}
+
+	if err = sethostname([]byte(hn)); err != nil {
+		return err
+	}
+
+	err = withWritableRoot("hostname", func() error {
+		if err := ioutil.WriteFile(etcHostnameFile, []byte(hn), 0644); err != nil {
+			return err
+		}
+		generateEtcHosts(hn)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logDebug("hostname changed to %s", hn)
+	v.hostnameLock.Lock()
+	v.hostname = hn
+	v.hostnameLock.Unlock()
+
+	return nil
+}
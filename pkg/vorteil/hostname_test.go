@@ -0,0 +1,83 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHostname(t *testing.T) {
+
+	assert.NoError(t, checkHostname("web-1.example.com"))
+	assert.NoError(t, checkHostname("a"))
+
+	for _, h := range []string{"", "web-", "-web", "web..com", "web_1", "Web"} {
+		assert.Error(t, checkHostname(h), h)
+	}
+
+	// sanitized where possible
+	New(testLogFn)
+	hn, err := setHostname("My_Host")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-host", hn)
+
+	_, err = setHostname("host-")
+	assert.Error(t, err)
+
+}
+
+func TestHostsWithHostname(t *testing.T) {
+
+	assert.Equal(t, "127.0.1.1\tweb\n", hostsWithHostname("", "web"))
+	assert.Equal(t, "127.0.0.1\tlocalhost\n127.0.1.1\tweb\n", hostsWithHostname("127.0.0.1\tlocalhost\n", "web"))
+	assert.Equal(t, "127.0.0.1\tlocalhost\n127.0.1.1\tweb\n::1\tip6-localhost\n",
+		hostsWithHostname("127.0.0.1\tlocalhost\n127.0.1.1 old old.local\n::1\tip6-localhost\n", "web"))
+
+}
+
+func TestChangeHostname(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "hostname")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hosts, hostname, sh := etcHostsFile, etcHostnameFile, sethostname
+	defer func() {
+		etcHostsFile, etcHostnameFile, sethostname = hosts, hostname, sh
+	}()
+
+	etcHostsFile = filepath.Join(dir, "hosts")
+	etcHostnameFile = filepath.Join(dir, "hostname")
+
+	var set string
+	sethostname = func(p []byte) error {
+		set = string(p)
+		return nil
+	}
+
+	assert.NoError(t, ioutil.WriteFile(etcHostsFile, []byte("127.0.0.1\tlocalhost\n127.0.1.1\tvorteil-abc\n"), 0644))
+
+	v := &Vinitd{hostname: "vorteil-abc"}
+	assert.NoError(t, v.changeHostname("Web1"))
+	assert.Equal(t, "web1", set)
+	assert.Equal(t, "web1", v.hostname)
+
+	b, err := ioutil.ReadFile(etcHostnameFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "web1", string(b))
+
+	b, err = ioutil.ReadFile(etcHostsFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1\tlocalhost\n127.0.1.1\tweb1\n", string(b))
+
+	set = ""
+	assert.Error(t, v.changeHostname("-"))
+	assert.Empty(t, set)
+	assert.Equal(t, "web1", v.hostname)
+
+}
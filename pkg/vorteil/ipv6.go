@@ -0,0 +1,129 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// exists if the kernel supports ipv6
+var ipv6ProcFile = "/proc/net/if_inet6"
+
+// ipv6Config is a static ipv6 address of an interface, e.g. eth0
+type ipv6Config struct {
+	ifc  string
+	addr *net.IPNet
+	gw   net.IP
+}
+
+// parseIPv6Configs reads comma separated interface=address/prefix@gateway
+// entries, the gateway is optional
+func parseIPv6Configs(value string) ([]ipv6Config, error) {
+
+	var cfgs []ipv6Config
+
+	for _, e := range strings.Split(value, ",") {
+
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("'%s' not in format interface=address/prefix@gateway", e)
+		}
+
+		ag := strings.SplitN(kv[1], "@", 2)
+
+		ip, nw, err := net.ParseCIDR(ag[0])
+		if err != nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid ipv6 address '%s'", ag[0])
+		}
+		nw.IP = ip
+
+		c := ipv6Config{
+			ifc:  kv[0],
+			addr: nw,
+		}
+
+		if len(ag) == 2 {
+			c.gw = net.ParseIP(ag[1])
+			if c.gw == nil || c.gw.To4() != nil {
+				return nil, fmt.Errorf("invalid ipv6 gateway '%s'", ag[1])
+			}
+		}
+
+		cfgs = append(cfgs, c)
+	}
+
+	return cfgs, nil
+}
+
+// configIPv6 adds the address and the default route to the link.
+// Duplicate address detection is skipped to use it right away.
+func configIPv6(link netlink.Link, c ipv6Config) error {
+
+	err := netlink.AddrAdd(link, &netlink.Addr{IPNet: c.addr, Flags: unix.IFA_F_NODAD})
+	if err != nil {
+		return fmt.Errorf("can not add %s: %s", c.addr, err.Error())
+	}
+
+	if c.gw == nil {
+		return nil
+	}
+
+	err = netlink.RouteAdd(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Gw:        c.gw,
+	})
+	if err != nil {
+		return fmt.Errorf("can not set ipv6 gateway %s: %s", c.gw, err.Error())
+	}
+
+	return nil
+}
+
+// setupIPv6 configures the static ipv6 addresses of the interfaces. Without
+// ipv6 support in the kernel they are skipped.
+func (v *Vinitd) setupIPv6(cfgs []ipv6Config) error {
+
+	if len(cfgs) == 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(ipv6ProcFile); err != nil {
+		logWarn("kernel has no ipv6 support, skipping ipv6 configuration")
+		return nil
+	}
+
+	for _, c := range cfgs {
+
+		i, ok := v.ifcs[c.ifc]
+		if !ok {
+			return fmt.Errorf("unknown interface %s for ipv6 address %s", c.ifc, c.addr)
+		}
+
+		link, err := netlink.LinkByName(i.netIfc.Name)
+		if err != nil {
+			return err
+		}
+
+		logDebug("%s: ipv6 %s, gateway %v", c.ifc, c.addr, c.gw)
+		err = configIPv6(link, c)
+		if err != nil {
+			return err
+		}
+
+		i.addr6 = append(i.addr6, c.addr)
+		if c.gw != nil {
+			i.gw6 = c.gw
+		}
+	}
+
+	return nil
+}
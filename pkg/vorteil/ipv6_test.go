@@ -0,0 +1,57 @@
+package vorteil
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseIPv6Configs(t *testing.T) {
+
+	cfgs, err := parseIPv6Configs("eth0=2001:db8::10/64@2001:db8::1,eth1=fd00::5/48")
+	assert.NoError(t, err)
+	assert.Len(t, cfgs, 2)
+	assert.Equal(t, "eth0", cfgs[0].ifc)
+	assert.Equal(t, "2001:db8::10/64", cfgs[0].addr.String())
+	assert.Equal(t, net.ParseIP("2001:db8::1"), cfgs[0].gw)
+	assert.Nil(t, cfgs[1].gw)
+
+	for _, v := range []string{"2001:db8::10/64", "eth0=2001:db8::10", "eth0=10.0.0.1/24",
+		"eth0=2001:db8::10/64@10.0.0.1", "=fd00::5/48"} {
+		_, err = parseIPv6Configs(v)
+		assert.Error(t, err, v)
+	}
+
+}
+
+func TestConfigIPv6(t *testing.T) {
+
+	New(testLogFn)
+
+	inNetns(t, func(link netlink.Link) {
+
+		// loopback can not route to a gateway
+		cfgs, err := parseIPv6Configs("eth0=2001:db8::10/64")
+		assert.NoError(t, err)
+
+		v := &Vinitd{ifcs: map[string]*ifc{
+			"eth0": {name: "eth0", netIfc: net.Interface{Name: "lo"}},
+		}}
+		assert.NoError(t, v.setupIPv6(cfgs))
+		assert.Len(t, v.ifcs["eth0"].addr6, 1)
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		assert.NoError(t, err)
+		var found bool
+		for _, a := range addrs {
+			found = found || a.IPNet.String() == "2001:db8::10/64"
+		}
+		assert.True(t, found)
+		assert.Nil(t, v.ifcs["eth0"].gw6)
+
+		assert.Error(t, v.setupIPv6([]ipv6Config{{ifc: "eth9", addr: cfgs[0].addr}}))
+	})
+
+}
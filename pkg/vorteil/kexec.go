@@ -0,0 +1,100 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// kexecConfig is the kernel and optional initrd from the vinitd.kexec
+// kernel argument
+type kexecConfig struct {
+	kernel, initrd string
+}
+
+var (
+	// replaced in tests
+	kexecFileLoad = unix.KexecFileLoad
+	kexecCmdline  = "/proc/cmdline"
+)
+
+// parseKexec reads kernel[,initrd]
+func parseKexec(value string) (*kexecConfig, error) {
+
+	ps := strings.Split(value, ",")
+	if len(ps) > 2 {
+		return nil, fmt.Errorf("invalid kexec value '%s'", value)
+	}
+
+	for _, p := range ps {
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("invalid kexec path '%s'", p)
+		}
+	}
+
+	k := &kexecConfig{kernel: ps[0]}
+	if len(ps) == 2 {
+		k.initrd = ps[1]
+	}
+
+	return k, nil
+}
+
+// loadKexec loads the kernel to boot with the current command line
+func loadKexec(k *kexecConfig) error {
+
+	kf, err := os.Open(k.kernel)
+	if err != nil {
+		return err
+	}
+	defer kf.Close()
+
+	initrd := -1
+	flags := unix.KEXEC_FILE_NO_INITRAMFS
+
+	if k.initrd != "" {
+		rf, err := os.Open(k.initrd)
+		if err != nil {
+			return err
+		}
+		defer rf.Close()
+		initrd = int(rf.Fd())
+		flags = 0
+	}
+
+	cmdline, err := ioutil.ReadFile(kexecCmdline)
+	if err != nil {
+		return err
+	}
+
+	return kexecFileLoad(int(kf.Fd()), initrd, strings.TrimSpace(string(cmdline)), flags)
+}
+
+// rebootCommand replaces restarts with kexec if the kernel can be loaded.
+// Otherwise the machine gets a full reboot.
+func rebootCommand(cmd int, k *kexecConfig) int {
+
+	if cmd != syscall.LINUX_REBOOT_CMD_RESTART || k == nil {
+		return cmd
+	}
+
+	err := loadKexec(k)
+	if err != nil {
+		logWarn("can not load %s for kexec, doing a full reboot: %s", k.kernel, err.Error())
+		return cmd
+	}
+
+	logAlways("rebooting into %s with kexec", k.kernel)
+
+	return unix.LINUX_REBOOT_CMD_KEXEC
+}
@@ -0,0 +1,76 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestRebootCommand(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "kexec")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	kernel := filepath.Join(dir, "vmlinuz")
+	initrd := filepath.Join(dir, "initrd")
+	cmdline := filepath.Join(dir, "cmdline")
+	for _, f := range []string{kernel, initrd} {
+		assert.NoError(t, ioutil.WriteFile(f, []byte("image"), 0644))
+	}
+	assert.NoError(t, ioutil.WriteFile(cmdline, []byte("console=ttyS0 vinitd.kexec=/boot/vmlinuz\n"), 0644))
+
+	load, cl := kexecFileLoad, kexecCmdline
+	defer func() {
+		kexecFileLoad, kexecCmdline = load, cl
+	}()
+	kexecCmdline = cmdline
+
+	var (
+		loaded  string
+		loadErr error
+		flags   int
+	)
+	kexecFileLoad = func(kernelFd int, initrdFd int, cmdline string, f int) error {
+		loaded, flags = cmdline, f
+		return loadErr
+	}
+
+	k, err := parseKexec(kernel)
+	assert.NoError(t, err)
+	assert.Equal(t, unix.LINUX_REBOOT_CMD_KEXEC, rebootCommand(syscall.LINUX_REBOOT_CMD_RESTART, k))
+	assert.Equal(t, "console=ttyS0 vinitd.kexec=/boot/vmlinuz", loaded)
+	assert.Equal(t, unix.KEXEC_FILE_NO_INITRAMFS, flags)
+
+	k, err = parseKexec(kernel + "," + initrd)
+	assert.NoError(t, err)
+	assert.Equal(t, unix.LINUX_REBOOT_CMD_KEXEC, rebootCommand(syscall.LINUX_REBOOT_CMD_RESTART, k))
+	assert.Equal(t, 0, flags)
+
+	// power off and unconfigured kexec stay the same
+	loaded = ""
+	assert.Equal(t, syscall.LINUX_REBOOT_CMD_POWER_OFF, rebootCommand(syscall.LINUX_REBOOT_CMD_POWER_OFF, k))
+	assert.Equal(t, syscall.LINUX_REBOOT_CMD_RESTART, rebootCommand(syscall.LINUX_REBOOT_CMD_RESTART, nil))
+	assert.Equal(t, "", loaded)
+
+	// failed loads fall back to a full reboot
+	loadErr = syscall.ENOEXEC
+	assert.Equal(t, syscall.LINUX_REBOOT_CMD_RESTART, rebootCommand(syscall.LINUX_REBOOT_CMD_RESTART, k))
+
+	loadErr = nil
+	k, _ = parseKexec(filepath.Join(dir, "missing"))
+	assert.Equal(t, syscall.LINUX_REBOOT_CMD_RESTART, rebootCommand(syscall.LINUX_REBOOT_CMD_RESTART, k))
+
+	_, err = parseKexec("vmlinuz")
+	assert.Error(t, err)
+	_, err = parseKexec("/a,/b,/c")
+	assert.Error(t, err)
+
+}
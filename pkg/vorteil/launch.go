@@ -20,7 +20,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/hashicorp/go-reap"
 	"github.com/vorteil/vorteil/pkg/vcfg"
 	"golang.org/x/sys/unix"
 )
@@ -35,6 +34,22 @@ const (
 	userID = 1000
 )
 
+var errWorkDir = errors.New("missing working directory")
+
+// execError is returned if the program could not be executed
+type execError struct {
+	path string
+	err  error
+}
+
+func (e *execError) Error() string {
+	return fmt.Sprintf("can not execute %s: %s", e.path, e.err.Error())
+}
+
+func (e *execError) Unwrap() error {
+	return e.err
+}
+
 func pickFromEnv(env string, p vcfg.Program) string {
 	for _, e := range p.Env {
 		es := strings.SplitN(e, "=", 2)
@@ -96,19 +111,129 @@ func fixDefaults(p *vcfg.Program) {
 
 }
 
-func waitForApp(cmd *exec.Cmd) {
+func waitForApp(p *program, exit chan syscall.WaitStatus) {
 
-	logDebug("waiting for process %d", cmd.Process.Pid)
-	err := cmd.Wait()
-	if err != nil {
-		logError("error while waiting: %s", err.Error())
+	pid := p.cmd.Process.Pid
+
+	logDebug("waiting for process %d", pid)
+	ws := <-exit
+	code := exitCode(ws)
+
+	logDebug("process %d finished with exit code %d", pid, code)
+
+	// the shim and exec wrapper exit like a shell if the program can not run
+	if code == codeExecFailed && p.cmd.Path != p.path {
+		logError("%s could not be executed", p.path)
+	}
+
+	if p.opts.seccomp != nil && ws.Signaled() && ws.Signal() == syscall.SIGSYS {
+		logError("%s was killed for a system call blocked by its seccomp filter", p.name())
+	}
+
+	// the control socket does not request restarts after this
+	p.statusLock.Lock()
+	close(p.done)
+	requested := p.restartRequested
+	p.restartRequested = false
+	p.statusLock.Unlock()
+
+	removed := p.vinitd.isRemoved(p)
+	p.recordExit(code, time.Now(), removed || initStatus == statusPoweroff || requested)
+
+	if p.cgroup != "" {
+		removeCgroup(p.cgroup)
+	}
+
+	p.runPostStop()
+
+	restart := needsRestart(p.opts.restart, code) || requested
+	if p.failedStart() && !removed && initStatus != statusPoweroff {
+		logError("program %s exited before it started", p.name())
+		restart = restart || p.opts.restart != restartNever
+	}
+
+	// not restarted if removed by a reload or shutting down
+	restart = restart && !removed && initStatus != statusPoweroff
+
+	p.statusLock.Lock()
+	p.restarting = restart
+	p.exited = true
+	p.statusLock.Unlock()
+
+	if restart {
+		go p.vinitd.restartProgram(p, code, requested)
 		return
 	}
-	logDebug("process %d finished with %s", cmd.Process.Pid, cmd.ProcessState.String())
+
+	// programs depending on it do not wait forever
+	p.failReady(fmt.Errorf("exited with %d before it was ready", code))
+
+	// sidecars do not keep the system running without the main program
+	if p.opts.main && !removed && initStatus != statusPoweroff {
+		exitAction(kernelOpts.onLastExit, fmt.Sprintf("main program %s exited", p.name()))
+		return
+	}
+
+	p.vinitd.checkProgramsExited()
 
 }
 
-func (p *program) launch(systemUser string) error {
+// command creates the command for the program in its working directory
+func (p *program) command() (*exec.Cmd, error) {
+
+	// never start in a different directory than configured
+	fi, err := os.Stat(p.vcfgProg.Cwd)
+	if err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("%w %s for %s", errWorkDir, p.vcfgProg.Cwd, p.path)
+	}
+
+	cmd := exec.Command(p.path, p.args...)
+	p.joinPID = 0
+	if p.opts.pidNamespace {
+		// the first program of a group runs the shim, the others join it
+		p.joinPID = p.pidNamespaceInit()
+		if p.joinPID == 0 {
+			cmd = shimCommand(p.path, p.args)
+		}
+	}
+	cmd.Env = p.env
+	cmd.Dir = p.vcfgProg.Cwd
+
+	return cmd, nil
+}
+
+// launchFailed handles a program which could not be started. A missing
+// working directory or binary and failed pre-start commands are retried if
+// the restart policy allows it, it might be fixed later, e.g. by another
+// program.
+func (v *Vinitd) launchFailed(p *program, err error) error {
+
+	var ee *execError
+	retry := errors.Is(err, errWorkDir) || errors.Is(err, errPreStart) ||
+		errors.As(err, &ee)
+
+	if !retry || p.opts.restart == restartNever {
+		p.markFailed(err)
+		return err
+	}
+
+	logError("%s, restarting", err.Error())
+	p.setRestarting(true)
+	go v.restartProgram(p, 1, false)
+
+	return nil
+}
+
+// launch starts the process. The launch slot of gate, if not nil, is only
+// held while the process starts, not during pre-start commands or waits
+// which might depend on programs launched later.
+func (p *program) launch(systemUser string, gate *launchGate) error {
+
+	// refuse to run binaries which do not match
+	err := verifyBinary(p.path, p.opts, kernelOpts.signingKey)
+	if err != nil {
+		return err
+	}
 
 	fixDefaults(&p.vcfgProg)
 
@@ -120,9 +245,15 @@ func (p *program) launch(systemUser string) error {
 		p.path = "/vorteil/strace"
 	}
 
-	cmd := exec.Command(p.path, p.args...)
-	cmd.Env = p.env
-	cmd.Dir = p.vcfgProg.Cwd
+	cmd, err := p.command()
+	if err != nil {
+		return err
+	}
+
+	err = p.runPreStart()
+	if err != nil {
+		return err
+	}
 
 	var (
 		user string
@@ -142,9 +273,10 @@ func (p *program) launch(systemUser string) error {
 	}
 
 	// either root or uid 1000
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Credential: &syscall.Credential{Uid: uint32(rid), Gid: uint32(rid)},
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
 	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(rid), Gid: uint32(rid)}
 
 	if p.vcfgProg.Privilege == "superuser" {
 		cmd.SysProcAttr.AmbientCaps = []uintptr{
@@ -161,39 +293,134 @@ func (p *program) launch(systemUser string) error {
 		}
 	}
 
+	cred, err := p.opts.credential()
+	if err != nil {
+		return err
+	}
+	if cred != nil {
+		cmd.SysProcAttr.Credential = cred
+		user = fmt.Sprintf("%s (gid %d, groups %v)", p.opts.user, cred.Gid, cred.Groups)
+		rid = int(cred.Uid)
+	}
+
 	logDebug("starting as %s, uid %d", user, rid)
 
-	// Create stderr dir if it does not exists
-	if _, err := os.Stat(filepath.Dir(p.vcfgProg.Stderr)); os.IsNotExist(err) {
-		os.MkdirAll(filepath.Dir(p.vcfgProg.Stderr), 0)
+	stdout, stderr, err := p.openOutputs()
+	if err != nil {
+		return err
 	}
 
-	stderr, err := os.OpenFile(p.vcfgProg.Stderr, os.O_WRONLY|os.O_APPEND, 0)
+	// the child has its own copies once started
+	defer stdout.Close()
+	defer stderr.Close()
+
+	cmd.Stderr = stderr
+	cmd.Stdout = stdout
+
+	label, err := execLabel(p.opts)
 	if err != nil {
 		return err
 	}
 
-	// Create stdout dir if it does not exists
-	if _, err := os.Stat(filepath.Dir(p.vcfgProg.Stdout)); os.IsNotExist(err) {
-		os.MkdirAll(filepath.Dir(p.vcfgProg.Stdout), 0)
+	var setup execSetup
+
+	if len(p.opts.rlimits) > 0 {
+		setup.Rlimits = clampRlimits(p.opts.rlimits)
+		err = raiseHardLimits(setup.Rlimits)
+		if err != nil {
+			return fmt.Errorf("can not raise resource limits: %s", err.Error())
+		}
 	}
 
-	stdout, err := os.OpenFile(p.vcfgProg.Stdout, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0)
-	if err != nil {
-		return err
+	// root keeps the allowed capabilities of its bounding set, other users
+	// get them as ambient capabilities
+	if p.opts.capabilities != nil {
+		if cmd.SysProcAttr.Credential.Uid == rootID {
+			cmd.SysProcAttr.AmbientCaps = allowedAmbient(cmd.SysProcAttr.AmbientCaps, p.opts.capabilities)
+			setup.DropCapabilities = true
+			setup.Capabilities = p.opts.capabilities
+		} else {
+			cmd.SysProcAttr.AmbientCaps = nil
+			for _, c := range p.opts.capabilities {
+				cmd.SysProcAttr.AmbientCaps = append(cmd.SysProcAttr.AmbientCaps, uintptr(c))
+			}
+		}
 	}
 
-	cmd.Stderr = stderr
-	cmd.Stdout = stdout
+	if p.opts.seccomp != nil {
+		logDebug("filtering system calls of %s, action %s", p.name(), p.opts.seccompAction)
+		setup.Seccomp = p.opts.seccomp
+		setup.SeccompAction = seccompActions[p.opts.seccompAction]
+	}
 
-	p.cmd = cmd
+	if setup.Rlimits != nil || setup.DropCapabilities || setup.Seccomp != nil {
+		err = wrapExec(cmd, setup)
+		if err != nil {
+			return err
+		}
+	}
 
-	err = cmd.Start()
+	p.setState(stateStarting)
+	if p.launchedAt.IsZero() {
+		p.launchedAt = time.Now()
+	}
+
+	if gate != nil {
+		gate.acquire(p.vcfgProg.Binary)
+	}
+
+	exit, err := startReaped(cmd, func() error {
+		return startIsolated(cmd, label, p.opts.namespace(), p.joinPID)
+	})
 	if err != nil {
-		return err
+		if gate != nil {
+			gate.release()
+		}
+		return &execError{path: p.path, err: err}
+	}
+
+	// the control socket only sees started processes
+	p.statusLock.Lock()
+	p.cmd = cmd
+	p.exited = false
+	p.started = false
+	p.failed = false
+	p.done = make(chan struct{})
+	p.statusLock.Unlock()
+
+	if p.opts.memoryMax != "" {
+		p.cgroup, err = setupCgroup(p.name(), cmd.Process.Pid, p.opts.memoryMax)
+		if err != nil {
+			cmd.Process.Kill()
+			if gate != nil {
+				gate.release()
+			}
+			return fmt.Errorf("can not limit memory of %s: %s", p.path, err.Error())
+		}
 	}
 
-	go waitForApp(cmd)
+	if len(p.opts.cpuAffinity) > 0 {
+		err = setAffinity(cmd.Process.Pid, p.opts.cpuAffinity)
+		if err != nil {
+			logWarn("can not set cpu affinity of %s: %s", p.path, err.Error())
+		}
+	}
+
+	if p.opts.oomScoreAdj != nil {
+		err = setOOMScoreAdj(cmd.Process.Pid, *p.opts.oomScoreAdj)
+		if err != nil {
+			logWarn("can not set oom score adjustment of %s: %s", p.path, err.Error())
+		}
+	}
+
+	p.backoff.start(time.Now())
+
+	// the slot is held during the start of the program
+	if gate != nil {
+		go gate.releaseWhenStarted(p, p.done)
+	}
+
+	go waitForApp(p, exit)
 
 	logDebug("started %s as pid %d", p.path, cmd.Process.Pid)
 
@@ -248,7 +475,7 @@ func bootstrapWaitForPort(args []string, p *program) {
 	// addresses for the said interface
 	addrs, err := ifce.Addrs()
 	if err != nil {
-		logError("bootstrap 'WAIT_PORT' unable to read addresses for %s: %s", ifce, err)
+		logError("bootstrap 'WAIT_PORT' unable to read addresses for %s: %s", ief, err)
 		return
 	}
 
@@ -358,7 +585,9 @@ func bootstrapReplace(args []string, p *program) {
 	}
 
 	content := strings.Replace(string(txt), m["find"], m["replace"], -1)
-	err = ioutil.WriteFile(m["file"], []byte(content), 0)
+	err = withWritableRoot("bootstrap", func() error {
+		return ioutil.WriteFile(m["file"], []byte(content), 0)
+	})
 	if err != nil {
 		return
 	}
@@ -409,7 +638,7 @@ func (p *program) bootstrap() error {
 			}
 		default:
 			{
-				logError("unknown bootstrap command: %d", bs[0])
+				logError("unknown bootstrap command: %s", bs[0])
 			}
 		}
 	}
@@ -421,20 +650,9 @@ func args(progArgs []string, envs []string) []string {
 
 	var newArgs []string
 
-	// convert envs into map
-	ee := map[string]string{}
-
-	for _, s := range envs {
-		// we can assume KEY=VALUE here
-		k := strings.SplitN(s, "=", 2)
-		ee[k[0]] = k[1]
-	}
-
+	ee := envMap(envs)
 	for _, e := range progArgs {
-		for k, val := range ee {
-			e = strings.ReplaceAll(e, fmt.Sprintf(replaceString, k), val)
-		}
-		newArgs = append(newArgs, e)
+		newArgs = append(newArgs, expandVars(e, ee))
 	}
 
 	return newArgs
@@ -459,27 +677,75 @@ func envs(progValues []string, hyperVisorEnvs map[string]string) []string {
 	return newEnvs
 }
 
-func (v *Vinitd) prepProgram(p vcfg.Program) error {
+// propagateLogLevel adds vinitd's log level as environment variable name
+// unless the program has defined that variable itself
+func propagateLogLevel(env []string, name string) []string {
+
+	if name == "" {
+		return env
+	}
+
+	for _, e := range env {
+		if strings.HasPrefix(e, fmt.Sprintf("%s=", name)) {
+			return env
+		}
+	}
+
+	return append(env, fmt.Sprintf(environString, name, logLevelNames[logLevel]))
+}
+
+func (v *Vinitd) prepProgram(p vcfg.Program) (*program, error) {
+
+	key := programKey(p)
+
+	// vinitd options are not passed to the program
+	opts, env, err := parseProgramOptions(p.Env)
+	if err != nil {
+		return nil, err
+	}
+	p.Env = env
 
 	// we can add the program to the list now
 	np := &program{
+		key:      key,
 		vcfgProg: p,
+		opts:     opts,
+		backoff:  newBackoff(opts.restartMaxDelay),
 		cmd:      nil,
+		ready:    make(chan struct{}),
+		status:   programStatus{State: stateStarting},
 		vinitd:   v,
+
+		crashLoop: newRestartGuard(opts.crashLoopLimit, opts.crashLoopWindow),
 	}
 
+	v.programsLock.Lock()
 	v.programs = append(v.programs, np)
+	v.programsLock.Unlock()
 
-	return nil
+	return np, nil
+}
+
+// programList returns a copy of the current programs
+func (v *Vinitd) programList() []*program {
+	v.programsLock.Lock()
+	defer v.programsLock.Unlock()
+	return append([]*program{}, v.programs...)
 }
 
-func (v *Vinitd) launchProgram(np *program) error {
+func (v *Vinitd) launchProgram(np *program, gate *launchGate) error {
 
 	p := np.vcfgProg
 
 	// get envs and substitute with cloud args
 	pEnvs := envs(p.Env, v.hypervisorInfo.envs)
-	np.env = pEnvs
+
+	pEnvs, err := programEnv(kernelOpts.envFile, pEnvs, np.opts.envFiles)
+	if err != nil {
+		return err
+	}
+
+	np.env = expandEnv(propagateLogLevel(pEnvs, np.opts.propagateLogLevelAs))
 
 	// replace args cloud args as well plus existing envs
 	pArgs, err := p.ProgramArgs()
@@ -497,68 +763,133 @@ func (v *Vinitd) launchProgram(np *program) error {
 
 	logDebug("launching %s", np.path)
 
+	if np.opts.unpackArchive != "" {
+		err = withWritableRoot("unpacking", func() error {
+			return unpackArchive(np.opts.unpackArchive, np.opts.unpackTarget, np.opts.unpackSHA256)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	// run bootstrap functions
 	np.bootstrap()
 
 	logDebug("launch args %v", np.args)
 	logDebug("launch envs %v", np.env)
 
-	err = np.launch(v.user)
+	err = np.launch(v.user, gate)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
+		var ee *execError
+		if errors.As(err, &ee) && errors.Is(err, os.ErrNotExist) {
 			// that can be a missing binary or missing linker
 			// let's try to make the error message better
 			// if the binary exists it has to be a missing linker
+			ee.err = errors.New("application missing")
 			if _, err := os.Stat(np.path); err == nil {
-				return fmt.Errorf("ld linker missing for %s", np.path)
+				ee.err = errors.New("ld linker missing")
 			}
+		}
+		return err
+	}
 
-			return fmt.Errorf("%s application missing", np.path)
+	if np.opts.hasLivenessCheck() {
+		go np.watchLiveness(np.done)
+	}
 
+	// dependent programs wait until the probe passes
+	if np.opts.hasProbe() {
+		go np.probeReady()
+	} else {
+		if np.opts.startTimeout > 0 {
+			go np.watchStart(np.done)
+		} else {
+			np.setState(stateRunning)
 		}
-		return err
+		np.markReady()
 	}
 
 	return nil
 }
 
-func reapProcs() {
+// startTracking starts reaping and listening to process events before the
+// first program gets launched
+func (v *Vinitd) startTracking() {
 
-	pids := make(reap.PidCh, 1)
-	errors := make(reap.ErrorCh, 1)
+	v.trackingOnce.Do(func() {
 
-	go reap.ReapChildren(pids, errors, nil, nil)
+		go listenToProcesses(v)
 
-	for {
-		select {
-		case pid := <-pids:
-			logDebug("process %d finished", pid)
-		case err := <-errors:
-			logError("error wait pid %s", err.Error())
+		stopPrograms = v.programList
+		systemRestarts = newRestartGuard(kernelOpts.restartLimit,
+			time.Duration(kernelOpts.restartWindow)*time.Second)
+
+		v.gate = newLaunchGate(kernelOpts.launchConcurrency, kernelOpts.launchPressure)
+
+	})
+
+}
+
+// programsInPhase returns the programs launched in the boot phase
+func programsInPhase(progs []*program, phase launchPhase) []*program {
+
+	var pp []*program
+	for _, p := range progs {
+		if p.opts.phase == phase {
+			pp = append(pp, p)
 		}
 	}
 
+	return pp
 }
 
-// Launch starts all applications in vcfg
-func (v *Vinitd) Launch() error {
+// startProgram launches the program once its dependencies and the network
+// are ready and the launch gate lets it. Programs which can not be started
+// are restarted or marked failed, the error is returned for failed ones.
+// Programs whose dependencies exited are failed like exited programs and
+// the exit action applies once no program is left.
+func (v *Vinitd) startProgram(p *program) error {
+
+	err := v.waitForDependencies(p)
+	if err != nil {
+		logError("can not start %s", err.Error())
+		p.markFailed(err)
+		v.checkProgramsExited()
+		return nil
+	}
+	v.waitForNetwork(p)
+
+	err = v.launchProgram(p, v.gate)
+	if err != nil {
+		return v.launchFailed(p, err)
+	}
+
+	return nil
+}
+
+// launchPhase starts all programs of a boot phase and waits until they
+// have been started
+func (v *Vinitd) launchPhase(phase launchPhase) {
+
+	progs := programsInPhase(v.programList(), phase)
+	if len(progs) == 0 {
+		return
+	}
+
+	v.startTracking()
 
 	var wg sync.WaitGroup
-	wg.Add(len(v.vcfg.Programs))
+	wg.Add(len(progs))
 
-	logDebug("starting %d programs", len(v.vcfg.Programs))
+	logDebug("starting %d programs in phase %s", len(progs), phaseNames[phase])
 
 	errors := make(chan error)
 	wgDone := make(chan bool)
 
-	go reapProcs()
-
-	go listenToProcesses(v.programs)
-
-	for _, p := range v.programs {
+	for _, p := range progs {
 
 		go func(p *program) {
-			err := v.launchProgram(p)
+			err := v.startProgram(p)
 			if err != nil {
 				errors <- err
 			}
@@ -579,8 +910,45 @@ func (v *Vinitd) Launch() error {
 		SystemPanic("starting program failed: %s", err.Error())
 	}
 
+}
+
+// noProgramsAction returns the action if there are no programs to run
+func noProgramsAction(programs int, action string) string {
+	if programs > 0 {
+		return ""
+	}
+	return action
+}
+
+// Launch starts the remaining applications in vcfg
+func (v *Vinitd) Launch() error {
+
+	switch noProgramsAction(len(v.programList()), kernelOpts.noPrograms) {
+	case noProgramsPoweroff:
+		Poweroff("no programs configured")
+		return nil
+	case noProgramsHold:
+		logAlways("no programs configured, keeping the system running")
+		initStatus = statusLaunched
+		return nil
+	}
+
+	v.launchPhase(phasePostMounts)
+	v.launchPhase(phaseFinal)
+
+	// programs might be all in earlier phases
+	v.startTracking()
+
 	logDebug("all apps started")
+	launchedAt = time.Now()
 	initStatus = statusLaunched
 
+	// all programs might have finished during launch
+	v.checkProgramsExited()
+
+	go v.bootSummary()
+
+	go v.waitForReload()
+
 	return nil
 }
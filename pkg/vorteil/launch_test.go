@@ -0,0 +1,141 @@
+package vorteil
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func TestProgramWorkDir(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "cwd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// resolve links, e.g. a linked /tmp
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+
+	p := &program{
+		path:     "/bin/sh",
+		args:     []string{"-c", "pwd"},
+		vcfgProg: vcfg.Program{Cwd: dir},
+	}
+
+	cmd, err := p.command()
+	assert.NoError(t, err)
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, dir, strings.TrimSpace(string(out)))
+
+	p.vcfgProg.Cwd = filepath.Join(dir, "missing")
+	_, err = p.command()
+	assert.True(t, errors.Is(err, errWorkDir))
+
+	// not retried without restart policy
+	v := &Vinitd{}
+	p.opts.restart = restartNever
+	assert.Error(t, v.launchFailed(p, err))
+
+}
+
+func TestLaunchMissingBinary(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "launch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+
+	p := &program{
+		path: filepath.Join(dir, "typo"),
+		vcfgProg: vcfg.Program{
+			Cwd:    dir,
+			Stdout: out,
+			Stderr: out,
+		},
+		opts: programOptions{restart: restartNever},
+	}
+
+	err = p.launch("root", nil)
+
+	var ee *execError
+	assert.True(t, errors.As(err, &ee))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// never started, nothing to wait for
+	v := &Vinitd{}
+	assert.Error(t, v.launchFailed(p, err))
+	assert.True(t, p.failed)
+	assert.True(t, programsDone([]*program{p}, true))
+
+}
+
+func TestMainProgramExit(t *testing.T) {
+
+	New(testLogFn)
+
+	sf, st, la := shutdownFn, initStatus, launchedAt
+	defer func() {
+		shutdownFn, initStatus, launchedAt = sf, st, la
+		procs.reset()
+	}()
+
+	var cmds []int
+	shutdownFn = func(cmd, timeout int) {
+		cmds = append(cmds, cmd)
+	}
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_MAIN=true"})
+	assert.NoError(t, err)
+	assert.True(t, opts.main)
+
+	v := &Vinitd{}
+	newProgram := func(pid int, o programOptions) *program {
+		p := &program{
+			path:   "/bin/app",
+			cmd:    &exec.Cmd{Process: &os.Process{Pid: pid}},
+			done:   make(chan struct{}),
+			opts:   o,
+			vinitd: v,
+		}
+		v.programs = append(v.programs, p)
+		procs.add(uint32(pid), "/bin/app")
+		return p
+	}
+
+	main := newProgram(100, opts)
+	sidecar := newProgram(101, programOptions{})
+
+	initStatus = statusLaunched
+	launchedAt = time.Now().Add(-time.Hour)
+
+	exit := func(p *program, code int) {
+		procs.remove(uint32(p.cmd.Process.Pid))
+		c := make(chan syscall.WaitStatus, 1)
+		c <- syscall.WaitStatus(code << 8)
+		waitForApp(p, c)
+	}
+
+	// the main program keeps the system running
+	exit(sidecar, 1)
+	assert.Empty(t, cmds)
+
+	exit(main, 0)
+	assert.Equal(t, []int{syscall.LINUX_REBOOT_CMD_POWER_OFF}, cmds)
+
+}
@@ -0,0 +1,84 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"io/ioutil"
+	"time"
+)
+
+const (
+	defaultLiveInterval = 10 * time.Second
+	defaultLiveDelay    = 30 * time.Second
+	defaultLiveFailures = 3
+)
+
+// hasLivenessCheck reports if the program gets checked while running
+func (o programOptions) hasLivenessCheck() bool {
+	return o.liveTCP != "" || o.liveHTTP != "" || o.liveExec != ""
+}
+
+// checkLiveness runs the configured checks once. Each check has to finish
+// within the interval.
+func (p *program) checkLiveness() error {
+
+	err := checkEndpoint(p.opts.liveTCP, p.opts.liveHTTP, p.opts.liveInterval)
+	if err != nil || p.opts.liveExec == "" {
+		return err
+	}
+
+	cmd, err := shellCommand("-c", p.opts.liveExec)
+	if err != nil {
+		return err
+	}
+
+	cmd.Env = p.env
+	cmd.Dir = p.vcfgProg.Cwd
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = ioutil.Discard
+
+	return runWithTimeout(cmd, p.opts.liveInterval)
+}
+
+// watchLiveness checks the program until it exits. After too many failed
+// checks in a row it gets stopped like on shutdown, so the restart policy
+// applies.
+func (p *program) watchLiveness(done <-chan struct{}) {
+
+	// no checks while the program starts up
+	select {
+	case <-done:
+		return
+	case <-time.After(p.opts.liveDelay):
+	}
+
+	var failures int
+
+	for {
+
+		err := p.checkLiveness()
+		if err != nil {
+			failures++
+			logWarn("%s liveness check failed (%d/%d): %s", p.name(), failures, p.opts.liveFailures, err.Error())
+		} else {
+			failures = 0
+		}
+
+		if failures >= p.opts.liveFailures {
+			logError("%s is not alive, terminating", p.name())
+			p.stop()
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(p.opts.liveInterval):
+		}
+
+	}
+
+}
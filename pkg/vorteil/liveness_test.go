@@ -0,0 +1,100 @@
+package vorteil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLivenessCheck(t *testing.T) {
+
+	New(testLogFn)
+
+	var healthy int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	start := func(name string, args ...string) (*program, chan struct{}) {
+
+		cmd := exec.Command(name, args...)
+		assert.NoError(t, cmd.Start())
+
+		done := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			close(done)
+		}()
+
+		p := &program{
+			cmd:  cmd,
+			done: done,
+			opts: programOptions{
+				restart:      restartOnFailure,
+				liveHTTP:     srv.URL,
+				liveInterval: 50 * time.Millisecond,
+				liveDelay:    100 * time.Millisecond,
+				liveFailures: 3,
+				stopSignal:   syscall.SIGTERM,
+				stopTimeout:  200 * time.Millisecond,
+			},
+		}
+		go p.watchLiveness(done)
+
+		return p, done
+	}
+
+	p, done := start("sleep", "10")
+
+	select {
+	case <-done:
+		t.Fatal("healthy program terminated")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("unhealthy program not terminated")
+	}
+
+	ws := p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	assert.Equal(t, syscall.SIGTERM, ws.Signal())
+	assert.True(t, needsRestart(p.opts.restart, exitCode(ws)))
+
+	// programs ignoring the stop signal get killed after the stop timeout
+	p, done = start("sh", "-c", `trap "" TERM; exec sleep 10`)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("unhealthy program not killed")
+	}
+
+	ws = p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	assert.Equal(t, syscall.SIGKILL, ws.Signal())
+
+	// hung exec checks time out
+	p = &program{opts: programOptions{liveExec: "sleep 5", liveInterval: 100 * time.Millisecond}}
+	now := time.Now()
+	assert.Error(t, p.checkLiveness())
+	assert.True(t, time.Since(now) < 2*time.Second)
+
+	p.opts.liveExec = "true"
+	assert.NoError(t, p.checkLiveness())
+
+	p.opts.liveExec = "exit 1"
+	assert.Error(t, p.checkLiveness())
+
+}
@@ -6,11 +6,13 @@
 package vorteil
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/vorteil/vorteil/pkg/vcfg"
@@ -19,46 +21,159 @@ import (
 
 var (
 	vlog logFn
+
+	// logLevel is the verbosity of vinitd, configured with vinitd.loglevel
+	logLevel LogLevel = LogLvDEBUG
+
+	logLevelNames = map[LogLevel]string{
+		LogLvEMERG:   "emerg",
+		LogLvALERT:   "alert",
+		LogLvCRIT:    "crit",
+		LogLvERR:     "error",
+		LogLvWARNING: "warning",
+		LogLvNOTICE:  "notice",
+		LogLvINFO:    "info",
+		LogLvDEBUG:   "debug",
+	}
 )
 
 const (
 	msgIOCTLOutput = 0x40042101
+
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	// prefix of messages written to the screen
+	timestampUptime = "uptime"
+	timestampWall   = "wallclock"
+	timestampBoth   = "both"
 )
 
+// clocks before that have not been set, e.g. by the rtc or ntp
+var minValidTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
 func logAlways(format string, values ...interface{}) {
 	// write to stderr and kernel logs
-	vlog(LogLvSTDERR, format, values...)
-	vlog(LogLvDEBUG, format, values...)
+	recordLog(format, values...)
+	logf(LogLvSTDERR, format, values...)
+	logf(LogLvDEBUG, format, values...)
 }
 
 func logDebug(format string, values ...interface{}) {
-	vlog(LogLvDEBUG, format, values...)
+	recordLog(format, values...)
+	logf(LogLvDEBUG, format, values...)
 }
 
 func logWarn(format string, values ...interface{}) {
-	vlog(LogLvWARNING, format, values...)
+	recordLog(format, values...)
+	logf(LogLvWARNING, format, values...)
 }
 
-// SystemPanic prints error message and shuts down the system
+// SystemPanic prints error message and shuts down the system. The recent
+// messages are written out first, they might have scrolled off the screen.
 func SystemPanic(format string, values ...interface{}) {
 	logAlways(format, values...)
-	shutdown(syscall.LINUX_REBOOT_CMD_POWER_OFF, forcedPoweroffTimeout)
+	dumpRecentLogs()
+
+	cmd := syscall.LINUX_REBOOT_CMD_POWER_OFF
+	if rescue(kernelOpts.rescue) {
+		cmd = syscall.LINUX_REBOOT_CMD_RESTART
+	}
+	shutdown(cmd, forcedPoweroffTimeout)
 }
 
 func logError(format string, values ...interface{}) {
-	vlog(LogLvSTDERR, format, values...)
+	recordLog(format, values...)
+	logf(LogLvSTDERR, format, values...)
+}
+
+// parseLogLevel accepts a level name, e.g. warning, or the numeric kernel level
+func parseLogLevel(s string) (LogLevel, error) {
+
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	for l, n := range logLevelNames {
+		if n == s || fmt.Sprintf("%d", l) == s {
+			return l, nil
+		}
+	}
+
+	return LogLvDEBUG, fmt.Errorf("unknown log level %s", s)
+}
+
+// logPrefix returns the timestamp of a message in the mode. The wall clock
+// is only used once it has been set, uptime until then.
+func logPrefix(mode string, now time.Time, up float64) string {
+
+	upPrefix := fmt.Sprintf("[%05.6f]", up)
+	if mode == timestampUptime || !wallClockReady() || now.Before(minValidTime) {
+		return upPrefix
+	}
+
+	wall := fmt.Sprintf("[%s]", now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	if mode == timestampBoth {
+		return fmt.Sprintf("%s %s", wall, upPrefix)
+	}
+
+	return wall
 }
 
 func writeToOut(out *os.File, format string, values ...interface{}) {
 	txt := fmt.Sprintf(format, values...)
-	up := fmt.Sprintf("[%05.6f]", uptime())
-	fmt.Fprintf(out, "%s %s\n", up, txt)
+	fmt.Fprintf(out, "%s %s\n", logPrefix(kernelOpts.logTimestamp, time.Now(), uptime()), txt)
 	out.Sync()
 }
 
+// ansi colors of log levels on the screen
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorDim    = "\x1b[2m"
+	colorReset  = "\x1b[0m"
+)
+
+// isTerminal reports if the file is a tty
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// colorEnabled reports if messages written to out get colored. NO_COLOR
+// passed on the kernel command line disables it as well.
+func colorEnabled(out *os.File) bool {
+	return kernelOpts.logColor && os.Getenv("NO_COLOR") == "" && isTerminal(out)
+}
+
+// colorLevel colors the message depending on its level
+func colorLevel(level LogLevel, txt string) string {
+
+	var c string
+	switch {
+	case level == LogLvSTDERR || level <= LogLvERR:
+		c = colorRed
+	case level == LogLvWARNING:
+		c = colorYellow
+	case level == LogLvDEBUG:
+		c = colorDim
+	default:
+		return txt
+	}
+
+	return fmt.Sprintf("%s%s%s", c, txt, colorReset)
+}
+
+// writeLevelToOut writes the message, colored if out is a terminal
+func writeLevelToOut(out *os.File, level LogLevel, format string, values ...interface{}) {
+	if colorEnabled(out) {
+		writeToOut(out, "%s", colorLevel(level, fmt.Sprintf(format, values...)))
+		return
+	}
+	writeToOut(out, format, values...)
+}
+
 // LogFnStdout prints all messages to stdout for testing
 func LogFnStdout(level LogLevel, format string, values ...interface{}) {
-	writeToOut(os.Stdout, format, values...)
+	writeLevelToOut(os.Stdout, level, format, values...)
 }
 
 // LogFnKernel prints messages to /dev/kmsg. Based on the kernel's LogLevel
@@ -66,7 +181,7 @@ func LogFnStdout(level LogLevel, format string, values ...interface{}) {
 // of log level
 func LogFnKernel(level LogLevel, format string, values ...interface{}) {
 	if level == LogLvSTDERR {
-		writeToOut(os.Stderr, format, values...)
+		writeLevelToOut(os.Stderr, level, format, values...)
 	} else {
 
 		txt := fmt.Sprintf("<%d>%s", level, fmt.Sprintf(format, values...))
@@ -84,6 +199,110 @@ func LogFnKernel(level LogLevel, format string, values ...interface{}) {
 	}
 }
 
+// jsonEntry is a log message in json format
+type jsonEntry struct {
+	TS     string  `json:"ts"`
+	Level  string  `json:"level"`
+	Msg    string  `json:"msg"`
+	Uptime float64 `json:"uptime"`
+}
+
+// jsonLine returns the message as a single line json object
+func jsonLine(level LogLevel, now time.Time, up float64, msg string) string {
+
+	name, ok := logLevelNames[level]
+	if level == LogLvSTDERR {
+		name = "stderr"
+	} else if !ok {
+		name = fmt.Sprintf("%d", level)
+	}
+
+	b, _ := json.Marshal(jsonEntry{
+		TS:     now.UTC().Format(time.RFC3339Nano),
+		Level:  name,
+		Msg:    msg,
+		Uptime: up,
+	})
+
+	return string(b)
+}
+
+// LogFnJSON is LogFnKernel writing one json object per message
+func LogFnJSON(level LogLevel, format string, values ...interface{}) {
+
+	txt := jsonLine(level, time.Now(), uptime(), fmt.Sprintf(format, values...))
+
+	if level == LogLvSTDERR {
+		fmt.Fprintln(os.Stderr, txt)
+		os.Stderr.Sync()
+		return
+	}
+
+	f, err := os.OpenFile("/dev/kmsg", os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	f.Write([]byte(fmt.Sprintf("<%d>%s", level, txt)))
+
+}
+
+// screenCopies detects the kernel log copy of a message logAlways has just
+// written for the screen. Sinks writing all levels write it once.
+type screenCopies struct {
+	last string
+}
+
+func (c *screenCopies) skip(level LogLevel, msg string) bool {
+
+	if level != LogLvSTDERR && msg == c.last {
+		c.last = ""
+		return true
+	}
+
+	c.last = ""
+	if level == LogLvSTDERR {
+		c.last = msg
+	}
+
+	return false
+}
+
+// setupLogging applies the logging kernel arguments
+func setupLogging() {
+
+	logLevel = kernelOpts.logLevel
+	recentLogs.resize(kernelOpts.logBuffer)
+
+	if kernelOpts.logFormat == logFormatJSON {
+		vlog = LogFnJSON
+	}
+
+	if kernelOpts.syslogAddr != "" {
+		vlog = newSyslogSink(kernelOpts.syslogNetwork, kernelOpts.syslogAddr, vlog).log
+	}
+
+	// the root filesystem can not be made read-only with files open for
+	// writing
+	if kernelOpts.diskLog && kernelOpts.readOnlyRoot {
+		logWarn("messages are not written to disk with a read-only root filesystem")
+	} else if kernelOpts.diskLog {
+		diskLog = newDiskLogSink(vlog)
+		vlog = diskLog.log
+	}
+
+	if kernelOpts.serialLog != "" {
+		f, err := os.OpenFile(kernelOpts.serialLog, os.O_WRONLY|unix.O_NOCTTY, 0)
+		if err != nil {
+			logWarn("can not mirror messages to %s: %s", kernelOpts.serialLog, err.Error())
+			return
+		}
+		vlog = newSerialSink(f, vlog).log
+	}
+
+}
+
 func printVersion() error {
 
 	pv, err := ioutil.ReadFile("/proc/version")
@@ -104,10 +323,22 @@ func printVersion() error {
 	return nil
 }
 
+// openOutput opens name as new stdout/stderr. If that fails the current file
+// is kept so logging never writes to an invalid descriptor.
+func openOutput(name string, current *os.File) *os.File {
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		logWarn("can not assign %s to vinitd: %s", name, err.Error())
+		return current
+	}
+
+	return f
+}
+
 func setupVtty(mode vcfg.StdoutMode) {
 
 	var m int
-	var err error
 
 	switch mode {
 	case vcfg.StdoutModeScreenOnly:
@@ -120,30 +351,20 @@ func setupVtty(mode vcfg.StdoutMode) {
 		m = 0
 	}
 
-	os.Stdout, err = os.OpenFile(defaultTTY,
-		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		logWarn("can not assign /dev/vtty to vinitd")
-	}
-
-	os.Stderr, err = os.OpenFile(defaultTTY,
-		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		logWarn("can not assign /dev/vtty to vinitd")
-	}
+	os.Stdout = openOutput(defaultTTY, os.Stdout)
+	os.Stderr = openOutput(defaultTTY, os.Stderr)
 
 	file, err := os.OpenFile(defaultTTY, os.O_RDWR, 0)
 	if err != nil {
 		LogFnKernel(LogLvERR, "can not open vtty: %s", err.Error())
+		return
 	}
 	defer file.Close()
 
 	_, _, ep := unix.Syscall(unix.SYS_IOCTL, file.Fd(),
 		msgIOCTLOutput, uintptr(unsafe.Pointer(&m)))
 	if ep != 0 {
-		if err != nil {
-			LogFnKernel(LogLvERR, "can not ioctl vtty: %s", err.Error())
-		}
+		LogFnKernel(LogLvERR, "can not ioctl vtty: %s", ep.Error())
 	}
 
 }
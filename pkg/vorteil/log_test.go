@@ -0,0 +1,126 @@
+package vorteil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenOutput(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "vtty")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// keeps the current file if the tty can not be opened
+	out := openOutput(filepath.Join(dir, "missing", "vtty"), os.Stdout)
+	assert.Equal(t, os.Stdout, out)
+
+	out = openOutput(filepath.Join(dir, "vtty"), os.Stdout)
+	assert.NotEqual(t, os.Stdout, out)
+	out.Close()
+
+}
+
+func TestJSONLogging(t *testing.T) {
+
+	New(testLogFn)
+
+	now := time.Date(2020, 5, 1, 10, 30, 0, 500, time.UTC)
+
+	for l, n := range logLevelNames {
+		var e map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(jsonLine(l, now, 1.5, "msg")), &e))
+		assert.Equal(t, n, e["level"])
+		assert.Equal(t, "msg", e["msg"])
+		assert.Equal(t, "2020-05-01T10:30:00.0000005Z", e["ts"])
+		assert.Equal(t, 1.5, e["uptime"])
+	}
+
+	// messages are escaped and stay on one line
+	msg := "quote \" newline \n tab \t <tag>"
+	line := jsonLine(LogLvSTDERR, now, 0, msg)
+	assert.NotContains(t, line, "\n")
+
+	var e jsonEntry
+	assert.NoError(t, json.Unmarshal([]byte(line), &e))
+	assert.Equal(t, msg, e.Msg)
+	assert.Equal(t, "stderr", e.Level)
+
+	o, err := parseKernelOptions("vinitd.log-format=json")
+	assert.NoError(t, err)
+	assert.Equal(t, logFormatJSON, o.logFormat)
+
+}
+
+func TestLogPrefix(t *testing.T) {
+
+	now := time.Date(2020, 5, 1, 10, 30, 0, 1500, time.UTC)
+
+	// clock not synced yet
+	atomic.StoreInt32(&clockReady, 0)
+	assert.Equal(t, "[12.500000]", logPrefix(timestampWall, now, 12.5))
+
+	atomic.StoreInt32(&clockReady, 1)
+	assert.Equal(t, "[12.500000]", logPrefix(timestampUptime, now, 12.5))
+	assert.Equal(t, "[2020-05-01T10:30:00.000001Z]", logPrefix(timestampWall, now, 12.5))
+	assert.Equal(t, "[2020-05-01T10:30:00.000001Z] [12.500000]", logPrefix(timestampBoth, now, 12.5))
+
+	// clock not set yet
+	boot := time.Unix(12, 0)
+	assert.Equal(t, "[12.000000]", logPrefix(timestampWall, boot, 12))
+	assert.Equal(t, "[12.000000]", logPrefix(timestampBoth, boot, 12))
+
+}
+
+func TestLogColor(t *testing.T) {
+
+	New(testLogFn)
+
+	assert.Equal(t, "\x1b[31mfailed\x1b[0m", colorLevel(LogLvSTDERR, "failed"))
+	assert.Equal(t, "\x1b[31mfailed\x1b[0m", colorLevel(LogLvERR, "failed"))
+	assert.Equal(t, "\x1b[33mslow\x1b[0m", colorLevel(LogLvWARNING, "slow"))
+	assert.Equal(t, "\x1b[2mdetail\x1b[0m", colorLevel(LogLvDEBUG, "detail"))
+	assert.Equal(t, "info", colorLevel(LogLvINFO, "info"))
+
+	dir, err := ioutil.TempDir("", "color")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// no colors if redirected to a file
+	f, err := os.Create(filepath.Join(dir, "out"))
+	assert.NoError(t, err)
+	defer f.Close()
+
+	assert.False(t, colorEnabled(f))
+	writeLevelToOut(f, LogLvSTDERR, "plain %d", 1)
+	b, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "\x1b[")
+	assert.Contains(t, string(b), "plain 1")
+
+	pty, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip("no pty available")
+	}
+	defer pty.Close()
+
+	assert.True(t, colorEnabled(pty))
+
+	kernelOpts.logColor = false
+	assert.False(t, colorEnabled(pty))
+	kernelOpts.logColor = true
+
+	os.Setenv("NO_COLOR", "1")
+	assert.False(t, colorEnabled(pty))
+	os.Unsetenv("NO_COLOR")
+
+}
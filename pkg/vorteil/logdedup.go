@@ -0,0 +1,130 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// identical messages in a row shown within the window before the rest
+	// gets suppressed
+	dupLimit  = 5
+	dupWindow = 10 * time.Second
+)
+
+var duplicates = newLogDedup(dupLimit, dupWindow)
+
+// logDedup collapses identical messages in a row, e.g. from a program failing
+// in a loop. Messages are compared per level after formatting.
+type logDedup struct {
+	lock   sync.Mutex
+	limit  int
+	window time.Duration
+	last   map[LogLevel]*dupState
+}
+
+type dupState struct {
+	msg        string
+	start      time.Time
+	count      int
+	suppressed int
+
+	// reports the suppressed messages at the end of the window if no
+	// other message does
+	flush *time.Timer
+}
+
+func newLogDedup(limit int, window time.Duration) *logDedup {
+	return &logDedup{
+		limit:  limit,
+		window: window,
+		last:   make(map[LogLevel]*dupState),
+	}
+}
+
+// reportRepeated logs the number of suppressed messages
+func reportRepeated(level LogLevel, repeated int) {
+	vlog(level, "last message repeated %d times", repeated)
+}
+
+// flush reports the messages suppressed within the window of s unless a
+// later message has reported them already
+func (d *logDedup) flush(level LogLevel, s *dupState) {
+
+	d.lock.Lock()
+	repeated := 0
+	if d.last[level] == s {
+		repeated = s.suppressed
+		s.suppressed = 0
+	}
+	d.lock.Unlock()
+
+	if repeated > 0 {
+		reportRepeated(level, repeated)
+	}
+
+}
+
+// check reports if the message should be shown and how many messages have
+// been suppressed before it and need to be reported
+func (d *logDedup) check(level LogLevel, msg string, now time.Time) (bool, int) {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	s, ok := d.last[level]
+	if !ok || s.msg != msg || now.Sub(s.start) >= d.window {
+		repeated := 0
+		if ok {
+			repeated = s.suppressed
+			if s.flush != nil {
+				s.flush.Stop()
+			}
+		}
+		d.last[level] = &dupState{msg: msg, start: now, count: 1}
+		return true, repeated
+	}
+
+	s.count++
+	if s.count > d.limit {
+		s.suppressed++
+		if s.flush == nil {
+			s.flush = time.AfterFunc(d.window-now.Sub(s.start), func() {
+				d.flush(level, s)
+			})
+		}
+		return false, 0
+	}
+
+	return true, 0
+}
+
+// logEnabled reports if messages of the level pass the configured log
+// level. Messages for the screen are always shown.
+func logEnabled(level LogLevel) bool {
+	return level == LogLvSTDERR || level <= logLevel
+}
+
+// logf writes the message unless it is filtered by level or a repeated
+// duplicate
+func logf(level LogLevel, format string, values ...interface{}) {
+
+	if !logEnabled(level) {
+		return
+	}
+
+	show, repeated := duplicates.check(level, fmt.Sprintf(format, values...), time.Now())
+	if repeated > 0 {
+		reportRepeated(level, repeated)
+	}
+	if show {
+		vlog(level, format, values...)
+	}
+
+}
@@ -0,0 +1,124 @@
+package vorteil
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogDedup(t *testing.T) {
+
+	var out []string
+	New(func(level LogLevel, format string, values ...interface{}) {
+		out = append(out, fmt.Sprintf("%d %s", level, fmt.Sprintf(format, values...)))
+	})
+
+	oldDups := duplicates
+	duplicates = newLogDedup(2, time.Minute)
+	defer func() {
+		duplicates = oldDups
+	}()
+
+	for i := 0; i < 5; i++ {
+		logWarn("connection refused")
+	}
+	logWarn("retry %d", 1)
+	logWarn("retry %d", 2)
+
+	assert.Equal(t, []string{
+		"4 connection refused",
+		"4 connection refused",
+		"4 last message repeated 3 times",
+		"4 retry 1",
+		"4 retry 2",
+	}, out)
+
+	// logAlways alternates levels which are checked separately
+	out = nil
+	for i := 0; i < 3; i++ {
+		logAlways("waiting")
+	}
+	assert.Len(t, out, 4)
+
+	// repeats reported after the window
+	d := newLogDedup(1, time.Second)
+	now := time.Now()
+	show, _ := d.check(LogLvERR, "a", now)
+	assert.True(t, show)
+	show, _ = d.check(LogLvERR, "a", now)
+	assert.False(t, show)
+	show, _ = d.check(LogLvERR, "a", now.Add(500*time.Millisecond))
+	assert.False(t, show)
+	show, repeated := d.check(LogLvERR, "a", now.Add(time.Second))
+	assert.True(t, show)
+	assert.Equal(t, 2, repeated)
+
+}
+
+func TestLogDedupFlush(t *testing.T) {
+
+	var lock sync.Mutex
+	var out []string
+	New(func(level LogLevel, format string, values ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		out = append(out, fmt.Sprintf(format, values...))
+	})
+
+	oldDups := duplicates
+	duplicates = newLogDedup(1, 50*time.Millisecond)
+	defer func() {
+		duplicates = oldDups
+	}()
+
+	// a burst followed by silence still reports its count
+	for i := 0; i < 4; i++ {
+		logWarn("disk full")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	lock.Lock()
+	assert.Equal(t, []string{
+		"disk full",
+		"last message repeated 3 times",
+	}, out)
+	out = nil
+	lock.Unlock()
+
+	// reported once, the next message starts a new window
+	logWarn("disk full")
+	lock.Lock()
+	assert.Equal(t, []string{"disk full"}, out)
+	lock.Unlock()
+
+}
+
+func TestLogLevelFilter(t *testing.T) {
+
+	var out []LogLevel
+	New(func(level LogLevel, format string, values ...interface{}) {
+		out = append(out, level)
+	})
+
+	logLevel = LogLvWARNING
+	defer func() { logLevel = LogLvDEBUG }()
+
+	logDebug("debug")
+	logWarn("warning")
+	logError("error")
+	logAlways("always")
+	assert.Equal(t, []LogLevel{LogLvWARNING, LogLvSTDERR, LogLvSTDERR}, out)
+
+	logLevel = LogLvERR
+	out = nil
+	logWarn("warning")
+	logError("error")
+	assert.Equal(t, []LogLevel{LogLvSTDERR}, out)
+
+	assert.True(t, logEnabled(LogLvEMERG))
+	assert.False(t, logEnabled(LogLvNOTICE))
+
+}
@@ -202,7 +202,9 @@ func (v *Vinitd) startLogging() {
 	str.WriteString("    Match *\n")
 	str.WriteString("    Record hostname ${HOSTNAME}\n")
 
-	err := ioutil.WriteFile("/etc/fb.cfg", []byte(str.String()), 0644)
+	err := withWritableRoot("logging config", func() error {
+		return ioutil.WriteFile("/etc/fb.cfg", []byte(str.String()), 0644)
+	})
 	if err != nil {
 		logAlways("can not create fluent-bit config file: %s", err.Error())
 		return
@@ -0,0 +1,119 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultLogBuffer = 256
+	panicLogFile     = "vinitd-panic.log"
+)
+
+var (
+	// recent messages, written out if the system panics
+	recentLogs = newLogRing(defaultLogBuffer)
+
+	kmsgFile = "/dev/kmsg"
+)
+
+// logRing keeps the last messages, older ones get overwritten
+type logRing struct {
+	lock  sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{
+		lines: make([]string, size),
+	}
+}
+
+func (r *logRing) add(line string) {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.lines) == 0 {
+		return
+	}
+
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+
+}
+
+// entries returns the messages, oldest first
+func (r *logRing) entries() []string {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.full {
+		return append([]string{}, r.lines[:r.next]...)
+	}
+
+	return append(append([]string{}, r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+// resize changes the number of messages kept, keeping the newest ones
+func (r *logRing) resize(size int) {
+
+	e := r.entries()
+	if len(e) > size {
+		e = e[len(e)-size:]
+	}
+
+	r.lock.Lock()
+	r.lines = make([]string, size)
+	r.next = 0
+	r.full = false
+	r.lock.Unlock()
+
+	for _, l := range e {
+		r.add(l)
+	}
+
+}
+
+func recordLog(format string, values ...interface{}) {
+	recentLogs.add(fmt.Sprintf("[%05.6f] %s", uptime(), fmt.Sprintf(format, values...)))
+}
+
+// dumpRecentLogs writes the recent messages to the kernel log and the logs
+// directory if the disk is writable
+func dumpRecentLogs() {
+
+	lines := recentLogs.entries()
+	if len(lines) == 0 {
+		return
+	}
+
+	if f, err := os.OpenFile(kmsgFile, os.O_WRONLY, 0644); err == nil {
+		fmt.Fprintf(f, "<%d>last %d messages before panic", LogLvCRIT, len(lines))
+		for _, l := range lines {
+			fmt.Fprintf(f, "<%d>%s", LogLvCRIT, l)
+		}
+		f.Close()
+	}
+
+	if err := os.MkdirAll(logsDir, 0755); err == nil {
+		ioutil.WriteFile(filepath.Join(logsDir, panicLogFile),
+			[]byte(strings.Join(lines, "\n")+"\n"), 0644)
+	}
+
+}
@@ -0,0 +1,69 @@
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRing(t *testing.T) {
+
+	r := newLogRing(3)
+	assert.Empty(t, r.entries())
+
+	r.add("1")
+	r.add("2")
+	assert.Equal(t, []string{"1", "2"}, r.entries())
+
+	for i := 3; i <= 7; i++ {
+		r.add(fmt.Sprintf("%d", i))
+	}
+	assert.Equal(t, []string{"5", "6", "7"}, r.entries())
+
+	r.resize(2)
+	assert.Equal(t, []string{"6", "7"}, r.entries())
+	r.resize(4)
+	r.add("8")
+	assert.Equal(t, []string{"6", "7", "8"}, r.entries())
+
+	r.resize(0)
+	r.add("9")
+	assert.Empty(t, r.entries())
+
+	dir, err := ioutil.TempDir("", "logring")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	kmsg := filepath.Join(dir, "kmsg")
+	assert.NoError(t, ioutil.WriteFile(kmsg, nil, 0644))
+
+	oldLogs, oldDir := recentLogs, logsDir
+	recentLogs, logsDir, kmsgFile = newLogRing(2), filepath.Join(dir, "logs"), kmsg
+	defer func() {
+		recentLogs, logsDir, kmsgFile = oldLogs, oldDir, "/dev/kmsg"
+	}()
+
+	New(testLogFn)
+	logDebug("first")
+	logWarn("second %d", 2)
+	logError("third")
+
+	dumpRecentLogs()
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "logs", panicLogFile))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], "] second 2"))
+	assert.True(t, strings.HasSuffix(lines[1], "] third"))
+
+	b, err = ioutil.ReadFile(kmsg)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "last 2 messages before panic")
+
+}
@@ -0,0 +1,166 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	lsmFile              = "/sys/kernel/security/lsm"
+	selinuxEnforceFile   = "/sys/fs/selinux/enforce"
+	selinuxContextFile   = "/sys/fs/selinux/context"
+	apparmorProfilesFile = "/sys/kernel/security/apparmor/profiles"
+
+	// applied to the next exec of the thread
+	execAttrFile = "/proc/thread-self/attr/exec"
+
+	lsmSELinux  = "selinux"
+	lsmAppArmor = "apparmor"
+)
+
+// hasLSM checks the comma separated list of active security modules
+func hasLSM(list, name string) bool {
+	for _, l := range strings.Split(strings.TrimSpace(list), ",") {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+func lsmActive(name string) bool {
+	l, err := ioutil.ReadFile(lsmFile)
+	if err != nil {
+		return false
+	}
+	return hasLSM(string(l), name)
+}
+
+// hasAppArmorProfile checks the list of loaded profiles, one 'name (mode)'
+// per line
+func hasAppArmorProfile(profiles, name string) bool {
+	for _, l := range strings.Split(profiles, "\n") {
+		if i := strings.LastIndex(l, " ("); i > 0 && l[:i] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func selinuxLabel(context string) (string, error) {
+
+	if !lsmActive(lsmSELinux) {
+		logWarn("selinux not active, ignoring context %s", context)
+		return "", nil
+	}
+
+	enforce, _ := ioutil.ReadFile(selinuxEnforceFile)
+
+	// the kernel rejects writes of invalid contexts
+	f, err := os.OpenFile(selinuxContextFile, os.O_RDWR, 0)
+	if err == nil {
+		_, err = f.Write([]byte(context))
+		f.Close()
+	}
+
+	if err != nil {
+		if strings.TrimSpace(string(enforce)) == "1" {
+			return "", fmt.Errorf("selinux context %s invalid: %s", context, err.Error())
+		}
+		logWarn("selinux context %s invalid, ignored in permissive mode: %s", context, err.Error())
+		return "", nil
+	}
+
+	return context, nil
+}
+
+func apparmorLabel(profile string) (string, error) {
+
+	if !lsmActive(lsmAppArmor) {
+		logWarn("apparmor not active, ignoring profile %s", profile)
+		return "", nil
+	}
+
+	profiles, err := ioutil.ReadFile(apparmorProfilesFile)
+	if err != nil {
+		return "", fmt.Errorf("can not read apparmor profiles: %s", err.Error())
+	}
+
+	if !hasAppArmorProfile(string(profiles), profile) {
+		return "", fmt.Errorf("apparmor profile %s not loaded", profile)
+	}
+
+	return fmt.Sprintf("exec %s", profile), nil
+}
+
+// execLabel returns the value for the exec attribute of the program or an
+// empty string if the program runs unconfined
+func execLabel(o programOptions) (string, error) {
+
+	switch {
+	case o.selinuxContext != "" && o.apparmorProfile != "":
+		return "", fmt.Errorf("selinux context and apparmor profile can not be used together")
+	case o.selinuxContext != "":
+		return selinuxLabel(o.selinuxContext)
+	case o.apparmorProfile != "":
+		return apparmorLabel(o.apparmorProfile)
+	}
+
+	return "", nil
+}
+
+// startIsolated starts the command with the security label applied on exec,
+// in its own mount namespace if it has one and in the pid namespace of
+// joinPID if it is set
+func startIsolated(cmd *exec.Cmd, label string, ns *mountNamespace, joinPID int) error {
+
+	if label == "" && ns == nil && joinPID == 0 {
+		return cmd.Start()
+	}
+
+	errc := make(chan error)
+
+	go func() {
+		// the thread stays locked and gets terminated with the goroutine, so
+		// the label and namespace can not apply to other processes started
+		// by vinitd
+		runtime.LockOSThread()
+
+		if joinPID != 0 {
+			err := joinPIDNamespace(joinPID)
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		if ns != nil {
+			err := ns.enter()
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		if label != "" {
+			err := ioutil.WriteFile(execAttrFile, []byte(label), 0)
+			if err != nil {
+				errc <- fmt.Errorf("can not set security label '%s': %s", label, err.Error())
+				return
+			}
+		}
+
+		errc <- cmd.Start()
+	}()
+
+	return <-errc
+}
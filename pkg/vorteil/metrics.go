@@ -0,0 +1,239 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const metricsPath = "/metrics"
+
+var (
+	// replaced in tests
+	procMeminfo = "/proc/meminfo"
+	procLoadavg = "/proc/loadavg"
+
+	// meminfo fields exported, in kB in /proc/meminfo
+	meminfoMetrics = []struct{ field, name string }{
+		{"MemTotal", "vinitd_memory_total_bytes"},
+		{"MemFree", "vinitd_memory_free_bytes"},
+		{"MemAvailable", "vinitd_memory_available_bytes"},
+		{"SwapTotal", "vinitd_swap_total_bytes"},
+		{"SwapFree", "vinitd_swap_free_bytes"},
+	}
+
+	programStates = []string{stateStarting, stateRunning, stateFailed, stateStopped}
+
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// parseMetricsAddr checks the listen address, a port alone listens on all
+// addresses
+func parseMetricsAddr(value string) (string, error) {
+
+	if _, err := strconv.Atoi(value); err == nil {
+		value = ":" + value
+	}
+
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// metricsWriter writes the prometheus text format
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (m metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m metricsWriter) value(name string, v float64, labels ...string) {
+
+	var ls []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		ls = append(ls, fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+
+	if len(ls) > 0 {
+		name = fmt.Sprintf("%s{%s}", name, strings.Join(ls, ","))
+	}
+
+	fmt.Fprintf(m.w, "%s %s\n", name, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// readMeminfo returns the fields of /proc/meminfo in bytes
+func readMeminfo(path string) (map[string]uint64, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mem := make(map[string]uint64)
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fs := strings.Fields(s.Text())
+		if len(fs) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fs[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fs) > 2 && fs[2] == "kB" {
+			n *= 1024
+		}
+		mem[strings.TrimSuffix(fs[0], ":")] = n
+	}
+
+	return mem, s.Err()
+}
+
+// readLoadavg returns the 1, 5 and 15 minute load averages
+func readLoadavg(path string) ([]float64, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := strings.Fields(string(b))
+	if len(fs) < 3 {
+		return nil, fmt.Errorf("unexpected loadavg '%s'", strings.TrimSpace(string(b)))
+	}
+
+	var load []float64
+	for _, f := range fs[:3] {
+		l, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		load = append(load, l)
+	}
+
+	return load, nil
+}
+
+// writeMetrics writes system and program metrics. Unreadable /proc files
+// are left out.
+func (v *Vinitd) writeMetrics(w io.Writer) {
+
+	m := metricsWriter{w}
+
+	up := uptime()
+	m.family("vinitd_uptime_seconds", "gauge", "Seconds since boot.")
+	m.value("vinitd_uptime_seconds", up)
+
+	m.family("vinitd_boot_time_seconds", "gauge", "Boot time in seconds since the epoch.")
+	m.value("vinitd_boot_time_seconds", float64(time.Now().Add(-time.Duration(up*float64(time.Second))).Unix()))
+
+	if up, idle, err := uptimeIdle(); err == nil {
+		m.family("vinitd_cpu_busy_percent", "gauge", "Average cpu utilization since boot in percent.")
+		m.value("vinitd_cpu_busy_percent", busyPercent(up, idle, runtime.NumCPU()))
+	} else {
+		logDebug("can not read uptime: %s", err.Error())
+	}
+
+	if load, err := readLoadavg(procLoadavg); err == nil {
+		for i, n := range []string{"1", "5", "15"} {
+			name := fmt.Sprintf("vinitd_load%s", n)
+			m.family(name, "gauge", fmt.Sprintf("%s minute load average.", n))
+			m.value(name, load[i])
+		}
+	} else {
+		logDebug("can not read load average: %s", err.Error())
+	}
+
+	if mem, err := readMeminfo(procMeminfo); err == nil {
+		for _, mm := range meminfoMetrics {
+			if n, ok := mem[mm.field]; ok {
+				m.family(mm.name, "gauge", fmt.Sprintf("%s from /proc/meminfo in bytes.", mm.field))
+				m.value(mm.name, float64(n))
+			}
+		}
+	} else {
+		logDebug("can not read memory info: %s", err.Error())
+	}
+
+	m.family("vinitd_control_clients", "gauge", "Clients connected to the control socket.")
+	m.value("vinitd_control_clients", float64(atomic.LoadInt32(&v.controlClients)))
+
+	// nil until programs are launched
+	if systemRestarts != nil {
+		m.family("vinitd_system_restarts", "gauge",
+			fmt.Sprintf("Restarts of all programs within the last %v.", systemRestarts.window))
+		m.value("vinitd_system_restarts", float64(systemRestarts.count(time.Now())))
+	}
+
+	status := v.programStatus()
+	if len(status) == 0 {
+		return
+	}
+
+	m.family("vinitd_program_restarts_total", "counter", "Restarts of the program.")
+	for _, s := range status {
+		m.value("vinitd_program_restarts_total", float64(s.Restarts), "program", s.Name)
+	}
+
+	m.family("vinitd_program_state", "gauge", "Current state of the program.")
+	for _, s := range status {
+		for _, st := range programStates {
+			val := 0.0
+			if s.State == st {
+				val = 1
+			}
+			m.value("vinitd_program_state", val, "program", s.Name, "state", st)
+		}
+	}
+
+}
+
+func (v *Vinitd) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	v.writeMetrics(w)
+}
+
+// serveMetrics starts the http endpoint if an address is configured
+func (v *Vinitd) serveMetrics(addr string) error {
+
+	if addr == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, v.metricsHandler)
+
+	logDebug("serving metrics on %s%s", l.Addr().String(), metricsPath)
+
+	go func() {
+		err := http.Serve(l, mux)
+		logWarn("metrics endpoint stopped: %s", err.Error())
+	}()
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func TestParseMetricsAddr(t *testing.T) {
+
+	a, err := parseMetricsAddr("9100")
+	assert.NoError(t, err)
+	assert.Equal(t, ":9100", a)
+
+	a, err = parseMetricsAddr("127.0.0.1:9100")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9100", a)
+
+	_, err = parseMetricsAddr("localhost")
+	assert.Error(t, err)
+
+}
+
+func TestMetricsHandler(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "metrics")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldMem, oldLoad, oldUptime := procMeminfo, procLoadavg, procFile
+	defer func() {
+		procMeminfo, procLoadavg, procFile = oldMem, oldLoad, oldUptime
+	}()
+
+	procMeminfo = filepath.Join(dir, "meminfo")
+	procLoadavg = filepath.Join(dir, "loadavg")
+	procFile = filepath.Join(dir, "uptime")
+	ioutil.WriteFile(procFile, []byte(fmt.Sprintf("100.00 %d.00\n", 75*runtime.NumCPU())), 0644)
+	ioutil.WriteFile(procMeminfo, []byte("MemTotal:        2048 kB\nMemAvailable:    1024 kB\nHugePages_Total:       0\n"), 0644)
+	ioutil.WriteFile(procLoadavg, []byte("0.50 0.25 0.10 1/100 1234\n"), 0644)
+
+	v := &Vinitd{}
+	web, err := v.prepProgram(vcfg.Program{
+		Binary: "/bin/web",
+		Env:    []string{"VINITD_NAME=web"},
+	})
+	assert.NoError(t, err)
+	web.setState(stateRunning)
+	web.recordRestart()
+	web.recordRestart()
+	web.setState(stateRunning)
+
+	_, err = v.prepProgram(vcfg.Program{
+		Binary: "/bin/db",
+		Env:    []string{`VINITD_NAME=d"b`},
+	})
+	assert.NoError(t, err)
+
+	restarts := systemRestarts
+	defer func() {
+		systemRestarts = restarts
+	}()
+	systemRestarts = newRestartGuard(10, time.Minute)
+	systemRestarts.record(time.Now().Add(-2 * time.Minute))
+	systemRestarts.record(time.Now())
+	systemRestarts.record(time.Now())
+
+	v.controlClients = 2
+
+	rec := httptest.NewRecorder()
+	v.metricsHandler(rec, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; version=0.0.4", rec.Header().Get("Content-Type"))
+
+	out := rec.Body.String()
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+
+	for _, l := range []string{
+		"# TYPE vinitd_uptime_seconds gauge",
+		"# TYPE vinitd_boot_time_seconds gauge",
+		"# TYPE vinitd_cpu_busy_percent gauge",
+		"vinitd_cpu_busy_percent 25",
+		"vinitd_load1 0.5",
+		"vinitd_load5 0.25",
+		"vinitd_load15 0.1",
+		"vinitd_memory_total_bytes 2.097152e+06",
+		"vinitd_memory_available_bytes 1.048576e+06",
+		"# HELP vinitd_system_restarts Restarts of all programs within the last 1m0s.",
+		"vinitd_system_restarts 2",
+		"# HELP vinitd_control_clients Clients connected to the control socket.",
+		"vinitd_control_clients 2",
+		"# TYPE vinitd_program_restarts_total counter",
+		`vinitd_program_restarts_total{program="web"} 2`,
+		`vinitd_program_restarts_total{program="d\"b"} 0`,
+		`vinitd_program_state{program="web",state="running"} 1`,
+		`vinitd_program_state{program="web",state="failed"} 0`,
+		`vinitd_program_state{program="d\"b",state="starting"} 1`,
+	} {
+		assert.Contains(t, lines, l)
+	}
+
+	// missing meminfo fields are left out
+	assert.NotContains(t, out, "vinitd_swap_total_bytes")
+
+	// every sample belongs to a declared family
+	for _, l := range lines {
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		name := strings.FieldsFunc(l, func(r rune) bool { return r == '{' || r == ' ' })[0]
+		assert.Contains(t, out, "# TYPE "+name+" ", l)
+	}
+
+}
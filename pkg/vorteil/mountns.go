@@ -0,0 +1,137 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	mountNamespaceOff       = "off"
+	mountNamespaceRequired  = "required"
+	mountNamespacePreferred = "preferred"
+)
+
+// bindMount is a directory or file of the shared filesystem mounted into a
+// program's mount namespace
+type bindMount struct {
+	source, target string
+	readOnly       bool
+}
+
+// mountNamespace is the private mount namespace of a program
+type mountNamespace struct {
+	// required fails the launch if the namespace can not be created,
+	// preferred uses the shared namespace instead
+	policy string
+
+	binds      []bindMount
+	privateTmp bool
+}
+
+// parseBindMounts reads a comma separated list of source:target[:ro]
+func parseBindMounts(value string) ([]bindMount, error) {
+
+	var binds []bindMount
+
+	for _, b := range strings.Split(value, ",") {
+
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+
+		fs := strings.Split(b, ":")
+		if len(fs) < 2 || len(fs) > 3 || (len(fs) == 3 && fs[2] != "ro" && fs[2] != "rw") {
+			return nil, fmt.Errorf("invalid bind mount '%s'", b)
+		}
+
+		if !filepath.IsAbs(fs[0]) || !filepath.IsAbs(fs[1]) {
+			return nil, fmt.Errorf("bind mount '%s' needs absolute paths", b)
+		}
+
+		binds = append(binds, bindMount{
+			source:   filepath.Clean(fs[0]),
+			target:   filepath.Clean(fs[1]),
+			readOnly: len(fs) == 3 && fs[2] == "ro",
+		})
+	}
+
+	return binds, nil
+}
+
+// namespace returns the mount namespace the program runs in, nil if it
+// shares vinitd's
+func (o programOptions) namespace() *mountNamespace {
+
+	if o.mountNamespace != mountNamespaceRequired && o.mountNamespace != mountNamespacePreferred {
+		return nil
+	}
+
+	return &mountNamespace{
+		policy:     o.mountNamespace,
+		binds:      o.bindMounts,
+		privateTmp: o.privateTmp,
+	}
+}
+
+func (b bindMount) mount() error {
+
+	err := unix.Mount(b.source, b.target, "", unix.MS_BIND|unix.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("can not bind mount %s on %s: %s", b.source, b.target, err.Error())
+	}
+
+	if b.readOnly {
+		err = unix.Mount("", b.target, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY, "")
+		if err != nil {
+			return fmt.Errorf("can not make %s read-only: %s", b.target, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// enter moves the calling thread into a new mount namespace and sets up the
+// mounts. The thread has to be locked, processes started from it inherit
+// the namespace.
+func (n *mountNamespace) enter() error {
+
+	err := unix.Unshare(unix.CLONE_NEWNS)
+	if err != nil {
+		if n.policy == mountNamespacePreferred {
+			logWarn("can not create mount namespace, using the shared one: %s", err.Error())
+			return nil
+		}
+		return fmt.Errorf("can not create mount namespace: %s", err.Error())
+	}
+
+	// mounts in the namespace do not propagate back
+	err = unix.Mount("", "/", "", unix.MS_REC|unix.MS_SLAVE, "")
+	if err != nil {
+		return fmt.Errorf("can not make mounts private: %s", err.Error())
+	}
+
+	for _, b := range n.binds {
+		err = b.mount()
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.privateTmp {
+		err = unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+		if err != nil {
+			return fmt.Errorf("can not mount private /tmp: %s", err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package vorteil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBindMounts(t *testing.T) {
+
+	b, err := parseBindMounts("/data/a:/srv/a, /etc/cfg:/etc/app:ro")
+	assert.NoError(t, err)
+	assert.Equal(t, []bindMount{
+		{source: "/data/a", target: "/srv/a"},
+		{source: "/etc/cfg", target: "/etc/app", readOnly: true},
+	}, b)
+
+	_, err = parseBindMounts("/data")
+	assert.Error(t, err)
+
+	_, err = parseBindMounts("data:/srv")
+	assert.Error(t, err)
+
+	_, err = parseBindMounts("/data:/srv:rx")
+	assert.Error(t, err)
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_PRIVATE_TMP=true"})
+	assert.NoError(t, err)
+	assert.Equal(t, mountNamespaceRequired, opts.mountNamespace)
+
+	opts, _, err = parseProgramOptions([]string{"VINITD_MOUNT_NAMESPACE=preferred", "VINITD_BIND_MOUNTS=/a:/b"})
+	assert.NoError(t, err)
+	assert.Equal(t, &mountNamespace{policy: mountNamespacePreferred,
+		binds: []bindMount{{source: "/a", target: "/b"}}}, opts.namespace())
+
+	opts, _, err = parseProgramOptions(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, opts.namespace())
+
+}
+
+func TestMountNamespace(t *testing.T) {
+
+	New(testLogFn)
+
+	if os.Getuid() != 0 {
+		t.Skip("mount namespaces need root")
+	}
+
+	dir, err := ioutil.TempDir("", "mountns")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "target")
+	for _, d := range []string{"a", "b", "target"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, d), 0755))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a", "marker"), []byte("a"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b", "marker"), []byte("b"), 0644))
+
+	run := func(ns *mountNamespace, script string) string {
+		var out bytes.Buffer
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		assert.NoError(t, startIsolated(cmd, "", ns, 0))
+		cmd.Wait()
+		return strings.TrimSpace(out.String())
+	}
+
+	// every program sees its own mount
+	assert.Equal(t, "a", run(&mountNamespace{
+		policy: mountNamespaceRequired,
+		binds:  []bindMount{{source: filepath.Join(dir, "a"), target: target}},
+	}, "cat "+target+"/marker"))
+
+	assert.Equal(t, "b\nread-only", run(&mountNamespace{
+		policy: mountNamespaceRequired,
+		binds:  []bindMount{{source: filepath.Join(dir, "b"), target: target, readOnly: true}},
+	}, "cat "+target+"/marker; echo; touch "+target+"/new 2>/dev/null || echo read-only"))
+
+	// neither is visible outside
+	assert.Equal(t, "", run(nil, "ls "+target))
+	fis, err := ioutil.ReadDir(target)
+	assert.NoError(t, err)
+	assert.Empty(t, fis)
+
+	// private /tmp starts empty and vanishes with the namespace
+	name := filepath.Base(dir)
+	assert.Equal(t, "", run(&mountNamespace{
+		policy:     mountNamespaceRequired,
+		privateTmp: true,
+	}, "ls /tmp; touch /tmp/private-"+name))
+	_, err = os.Stat("/tmp/private-" + name)
+	assert.True(t, os.IsNotExist(err))
+
+}
@@ -0,0 +1,106 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// an interface is up and has an address, or there is a default route
+	networkReadyAddress = "address"
+	networkReadyRoute   = "route"
+)
+
+var (
+	netReadyPoll = 250 * time.Millisecond
+
+	// checks if the network can be used, replaced in tests
+	networkIsReady = checkNetworkReady
+)
+
+func checkNetworkReady(mode string) bool {
+
+	if mode == networkReadyRoute {
+		for _, f := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			routes, err := netlink.RouteList(nil, f)
+			if err != nil {
+				continue
+			}
+			for _, r := range routes {
+				if r.Dst == nil {
+					return true
+				}
+				if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return false
+	}
+
+	for _, l := range links {
+
+		flags := l.Attrs().Flags
+		if flags&net.FlagUp == 0 || flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := netlink.AddrList(l, netlink.FAMILY_ALL)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if a.IP.IsGlobalUnicast() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// watchNetwork closes netReady once the network is ready. After the timeout
+// programs waiting for the network are started anyway, 0 waits forever.
+func (v *Vinitd) watchNetwork(mode string, timeout time.Duration) {
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	for !networkIsReady(mode) {
+		select {
+		case <-expired:
+			logWarn("network not ready after %v, starting programs anyway", timeout)
+			close(v.netReady)
+			return
+		case <-time.After(netReadyPoll):
+		}
+	}
+
+	logDebug("network ready")
+	close(v.netReady)
+}
+
+// waitForNetwork blocks programs which need the network until it is ready
+func (v *Vinitd) waitForNetwork(p *program) {
+
+	if !p.opts.needsNetwork {
+		return
+	}
+
+	logDebug("%s waiting for network", p.name())
+	<-v.netReady
+}
@@ -0,0 +1,88 @@
+package vorteil
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestNetworkReady(t *testing.T) {
+
+	New(testLogFn)
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_NEEDS_NETWORK=true", "VINITD_PHASE=post-network"})
+	assert.NoError(t, err)
+	assert.True(t, opts.needsNetwork)
+
+	_, _, err = parseProgramOptions([]string{"VINITD_NEEDS_NETWORK=true", "VINITD_PHASE=pre-network"})
+	assert.Error(t, err)
+
+	check, poll := networkIsReady, netReadyPoll
+	defer func() {
+		networkIsReady, netReadyPoll = check, poll
+	}()
+	netReadyPoll = 10 * time.Millisecond
+
+	var ready int32
+	networkIsReady = func(mode string) bool {
+		return atomic.LoadInt32(&ready) == 1
+	}
+
+	v := &Vinitd{netReady: make(chan struct{})}
+	go v.watchNetwork(networkReadyAddress, 0)
+
+	// programs without the option do not wait
+	v.waitForNetwork(&program{})
+
+	started := make(chan struct{})
+	go func() {
+		v.waitForNetwork(&program{opts: opts})
+		close(started)
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("started before the network is ready")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	atomic.StoreInt32(&ready, 1)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("not started once the network is ready")
+	}
+
+	// started anyway after the timeout
+	atomic.StoreInt32(&ready, 0)
+	v = &Vinitd{netReady: make(chan struct{})}
+	go v.watchNetwork(networkReadyAddress, 50*time.Millisecond)
+
+	select {
+	case <-v.netReady:
+	case <-time.After(time.Second):
+		t.Fatal("not started after the timeout")
+	}
+
+}
+
+func TestCheckNetworkReady(t *testing.T) {
+
+	inNetns(t, func(lo netlink.Link) {
+
+		assert.False(t, checkNetworkReady(networkReadyAddress))
+		assert.False(t, checkNetworkReady(networkReadyRoute))
+
+		assert.NoError(t, netlink.RouteAdd(&netlink.Route{
+			LinkIndex: lo.Attrs().Index,
+			Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+		}))
+		assert.True(t, checkNetworkReady(networkReadyRoute))
+
+	})
+
+}
@@ -7,7 +7,6 @@ package vorteil
 
 import (
 	"bufio"
-	"encoding/binary"
 	"log"
 	"os/exec"
 	"runtime"
@@ -60,6 +59,10 @@ const (
 	ethtoolSRINGPARAM = 0x00000011
 	siocETHTOOL       = 0x8946
 	ifNameSz          = 16
+
+	// ipv4 minimum and largest ip packet
+	minMTU = 68
+	maxMTU = 65535
 )
 
 var (
@@ -111,10 +114,10 @@ type ringparam struct {
 }
 
 type clientdhcp struct {
-	cid    []byte
-	client *client4.Client
-	xid    dhcpv4.TransactionID
-	offer  *dhcpv4.DHCPv4
+	ifc   *ifc
+	cid   []byte
+	xid   dhcpv4.TransactionID
+	lease *dhcpLease
 }
 
 func networkDeviceType(name string) networkType {
@@ -238,35 +241,6 @@ func configQueues(ifcs map[string]*ifc) {
 	}
 }
 
-func renewDHCP(name string, cinfo *clientdhcp) (*dhcpv4.DHCPv4, error) {
-
-	rfd, err := client4.MakeListeningSocket(name)
-	if err != nil {
-		return nil, err
-	}
-
-	sfd, err := client4.MakeBroadcastSocket(name)
-	if err != nil {
-		return nil, err
-	}
-
-	defer closeFds(sfd, rfd)
-
-	request, err := dhcpv4.NewRequestFromOffer(cinfo.offer,
-		dhcpv4.WithTransactionID(cinfo.xid),
-		dhcpv4.WithOption(dhcpv4.OptClientIdentifier(cinfo.cid)),
-		dhcpv4.WithBroadcast(true),
-		dhcpv4.WithRequestedOptions(dhcpv4.OptionRenewTimeValue, dhcpv4.OptionNTPServers,
-			dhcpv4.GenericOptionCode(azureEndpointServerOption)))
-
-	if err != nil {
-		return nil, err
-	}
-
-	return cinfo.client.SendReceive(sfd, rfd, request, dhcpv4.MessageTypeAck)
-
-}
-
 func closeFds(sfd, rfd int) {
 	if err := unix.Close(sfd); err != nil {
 		log.Printf("unix.Close(sendFd) failed: %v", err)
@@ -328,8 +302,7 @@ func dhcpDiscover(ifc net.Interface,
 				dhcpv4.WithTransactionID(xid),
 				dhcpv4.WithOption(dhcpv4.OptClientIdentifier(clientID)),
 				dhcpv4.WithBroadcast(true),
-				dhcpv4.WithRequestedOptions(dhcpv4.OptionRenewTimeValue, dhcpv4.OptionNTPServers,
-					dhcpv4.GenericOptionCode(azureEndpointServerOption)))
+				dhcpRequestedOptions)
 
 			offer, err = c.SendReceive(sfd, rfd, discover,
 				dhcpv4.MessageTypeOffer)
@@ -386,8 +359,6 @@ func configInterface(ifc *ifc, ip, mask, router net.IP) error {
 
 func fetchDHCP(ifc *ifc, v *Vinitd) error {
 
-	client := client4.NewClient()
-
 	cid := make([]byte, len(ifc.netIfc.HardwareAddr)+1)
 	cid[0] = byte(1)
 	copy(cid[1:], ifc.netIfc.HardwareAddr)
@@ -402,7 +373,6 @@ func fetchDHCP(ifc *ifc, v *Vinitd) error {
 	// if ack is not successful we panic later
 	router := dhcpv4.GetIP(dhcpv4.OptionRouter, offer.Options)
 	mask := dhcpv4.GetIP(dhcpv4.OptionSubnetMask, offer.Options)
-	dhcpServerIP := dhcpv4.GetIP(dhcpv4.OptionServerIdentifier, offer.Options)
 
 	if len(offer.Options.Get(dhcpv4.GenericOptionCode(azureEndpointServerOption))) > 0 {
 		v.hypervisorInfo.cloud = cpAzure
@@ -412,60 +382,45 @@ func fetchDHCP(ifc *ifc, v *Vinitd) error {
 
 	configInterface(ifc, offer.YourIPAddr, mask, router)
 
-	renew := dhcpDefaultRenew
-	rv := offer.Options.Get(dhcpv4.OptionRenewTimeValue)
-	if rv != nil {
-		renew = int(binary.BigEndian.Uint32(rv))
+	// add DNS
+	v.dns = append(v.dns, offer.DNS()...)
+	if offer.DomainName() != "" {
+		v.dnsSearch = append(v.dnsSearch, offer.DomainName())
 	}
-
-	addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%d", dhcpServerIP.String(), dhcpv4.ServerPort))
-	if err != nil {
-		logError("can not parse server address: %s", err.Error())
-		return err
+	if l := offer.DomainSearch(); l != nil {
+		v.dnsSearch = append(v.dnsSearch, l.Labels...)
 	}
-	client.RemoteAddr = addr
-
-	addr, err = net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%d", offer.YourIPAddr.String(), dhcpv4.ClientPort))
-	if err != nil {
-		logError("can not parse client address: %s", err.Error())
-		return err
+	if v.dhcpHostname == "" {
+		v.dhcpHostname = offer.HostName()
 	}
-	client.LocalAddr = addr
-	client.ReadTimeout = defaultDHCPTimeout
-	client.WriteTimeout = defaultDHCPTimeout
-
-	// add DNS
-	v.dns = append(v.dns, offer.DNS()...)
 
 	// XXX: ntp, at the moment we only use provided ntp servers
 	// we should read from dhcp as well
 
-	go func(name string, client *client4.Client, offer *dhcpv4.DHCPv4) {
+	// the offer is used as lease until the server acknowledged it
+	c := &clientdhcp{
+		ifc:   ifc,
+		cid:   cid,
+		xid:   xid,
+		lease: newDHCPLease(offer, time.Now()),
+	}
 
-		offer.SetUnicast()
+	go func(c *clientdhcp, offer *dhcpv4.DHCPv4) {
 
-		// create client info
-		clientInfo := &clientdhcp{
-			cid:    cid,
-			client: client,
-			xid:    xid,
-			offer:  offer,
-		}
+		offer.SetUnicast()
 
 		// this is getting the ack,if not we panic because we are using that IP already
-		ack, err := renewDHCP(name, clientInfo)
+		ack, err := c.request(offer)
 		if err != nil {
 			logWarn("can not ack IP address: %s", err.Error())
+		} else {
+			logDebug("dhcp acknowledged: %v", ack)
+			c.lease = newDHCPLease(ack, time.Now())
 		}
-		logDebug("dhcp acknowledged: %v", ack)
 
-		for {
-			<-time.After(time.Duration(renew) * time.Second)
-			logDebug("renew with %v", dhcpServerIP)
-			renewDHCP(name, clientInfo)
-		}
+		c.keepLease(nil)
 
-	}(ifc.name, client, offer)
+	}(c, offer)
 
 	return nil
 
@@ -507,6 +462,22 @@ func setTSOValues(name string, val byte) {
 
 }
 
+// setMTU changes the mtu of the link, 0 keeps the default of the driver
+func setMTU(link netlink.Link, mtu int) error {
+
+	if mtu == 0 {
+		return nil
+	}
+
+	if mtu < minMTU || mtu > maxMTU {
+		return fmt.Errorf("mtu %d not between %d and %d", mtu, minMTU, maxMTU)
+	}
+
+	logDebug("set mtu to %d for %s", mtu, link.Attrs().Name)
+
+	return netlink.LinkSetMTU(link, mtu)
+}
+
 func startLink(name string) (netlink.Link, error) {
 
 	link, err := netlink.LinkByName(name)
@@ -523,6 +494,33 @@ func startLink(name string) (netlink.Link, error) {
 
 }
 
+// setupLoopback brings up lo with 127.0.0.1/8 and ::1/128. The kernel might
+// have added the addresses already.
+func setupLoopback() error {
+
+	link, err := startLink("lo")
+	if err != nil {
+		return err
+	}
+
+	netlink.LinkSetMTU(link, 65536)
+
+	addrs := []*net.IPNet{
+		{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(8, 32)},
+		{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+	}
+
+	for _, a := range addrs {
+		err = netlink.AddrReplace(link, &netlink.Addr{IPNet: a})
+		if err != nil {
+			return fmt.Errorf("can not add %v to lo: %s", a, err.Error())
+		}
+	}
+
+	return nil
+
+}
+
 func (v *Vinitd) handleNetworkLink(interf *ifc, ifcg vcfg.NetworkInterface, errCh chan error, wg *sync.WaitGroup) {
 
 	if ifcg.IP != "dhcp" && ifcg.IP != "" {
@@ -609,8 +607,8 @@ func (v *Vinitd) networkSetup() error {
 
 		deviceType := networkDeviceType(i.Name)
 
-		// only handle devices
-		if deviceType < devtypeNet {
+		// only handle devices, lo is up already
+		if deviceType != devtypeNet {
 			continue
 		}
 
@@ -622,41 +620,30 @@ func (v *Vinitd) networkSetup() error {
 			return err
 		}
 
-		if deviceType == devtypeLocalhost {
-			ipnet := &net.IPNet{
-				IP:   net.IPv4(127, 0, 0, 1),
-				Mask: net.IPv4Mask(255, 0, 0, 0),
-			}
-
-			addr := &netlink.Addr{IPNet: ipnet}
-			netlink.AddrAdd(link, addr)
-			netlink.LinkSetMTU(link, 65536)
-		} else {
-
-			// add the device to the list
-			ifName := fmt.Sprintf("eth%d", ic)
-			v.ifcs[ifName] = &ifc{
-				name:   ifName,
-				idx:    ic,
-				netIfc: i,
-			}
+		// add the device to the list
+		ifName := fmt.Sprintf("eth%d", ic)
+		v.ifcs[ifName] = &ifc{
+			name:   ifName,
+			idx:    ic,
+			netIfc: i,
+		}
 
-			ifcg := v.vcfg.Networks[ic]
+		ifcg := v.vcfg.Networks[ic]
 
-			logDebug("set mtu to %d for %s", ifcg.MTU, i.Name)
-			netlink.LinkSetMTU(link, int(ifcg.MTU))
+		if err := setMTU(link, int(ifcg.MTU)); err != nil {
+			logWarn("can not set mtu for %s: %s", i.Name, err.Error())
+		}
 
-			logDebug("disable tso: %v", ifcg.DisableTCPSegmentationOffloading)
-			if ifcg.DisableTCPSegmentationOffloading {
-				setTSOValues(i.Name, 0)
-			} else {
-				setTSOValues(i.Name, 1)
-			}
-			wg.Add(2)
-			handleNetworkTCPDump(v.ifcs[ifName], ifcg, errCh, &wg)
-			v.handleNetworkLink(v.ifcs[ifName], ifcg, errCh, &wg)
-			ic++
+		logDebug("disable tso: %v", ifcg.DisableTCPSegmentationOffloading)
+		if ifcg.DisableTCPSegmentationOffloading {
+			setTSOValues(i.Name, 0)
+		} else {
+			setTSOValues(i.Name, 1)
 		}
+		wg.Add(2)
+		handleNetworkTCPDump(v.ifcs[ifName], ifcg, errCh, &wg)
+		v.handleNetworkLink(v.ifcs[ifName], ifcg, errCh, &wg)
+		ic++
 	}
 
 	// wait for network setup
@@ -673,11 +660,17 @@ func (v *Vinitd) networkSetup() error {
 		return err
 	}
 
+	err = v.setupIPv6(kernelOpts.ipv6)
+	if err != nil {
+		logError("can not configure ipv6: %s", err.Error())
+		return err
+	}
+
 	logDebug("network configured")
 
 	sortAndPrint(v.ifcs)
 
-	configRoutes(v.vcfg.Routing)
+	v.configRoutes(append(vcfgRoutes(v.vcfg.Routing), kernelOpts.routes...))
 
 	go configQueues(v.ifcs)
 
@@ -696,6 +689,12 @@ func sortAndPrint(ifcs map[string]*ifc) {
 		logAlways("%s ip\t: %s", ifcs[iKey].name, ifcs[iKey].addr.IP.String())
 		logAlways("%s mask\t: %s", ifcs[iKey].name, net.IP(ifcs[iKey].addr.Mask).String())
 		logAlways("%s gateway\t: %s", ifcs[iKey].name, ifcs[iKey].gw.String())
+		for _, a := range ifcs[iKey].addr6 {
+			logAlways("%s ipv6\t: %s", ifcs[iKey].name, a.String())
+		}
+		if ifcs[iKey].gw6 != nil {
+			logAlways("%s gateway6\t: %s", ifcs[iKey].name, ifcs[iKey].gw6.String())
+		}
 	}
 
 	if len(ifcs) == 0 {
@@ -725,7 +724,11 @@ func setHostname(str string) (string, error) {
 		return "", err
 	}
 
-	return hh, nil
+	if hh != str {
+		logWarn("hostname %s changed to %s", str, hh)
+	}
+
+	return hh, checkHostname(hh)
 }
 
 func validateHostname(hostname string) (string, error) {
@@ -780,46 +783,3 @@ func trimString(s string, n int) string {
 	}
 	return s[:n]
 }
-
-func configRoutes(routes []vcfg.Route) {
-
-	for _, r := range routes {
-
-		dst, nw, err := net.ParseCIDR(r.Destination)
-		if err != nil {
-			logError("can not set route destination: %v", r.Destination)
-			continue
-		}
-
-		gw := net.ParseIP(r.Gateway)
-		if gw == nil {
-			logError("gateway %s invalid", r.Gateway)
-			continue
-		}
-
-		// check if gateway is in that network
-		// if not, we need to create a direct link
-		var errNw error
-		if !nw.Contains(gw) {
-			errNw = addNetworkRoute4(gw, net.IPv4bcast, nil, r.Interface,
-				unix.RTF_UP|unix.RTF_HOST)
-		} else {
-			errNw = addNetworkRoute4(gw, net.IP(nw.Mask), nil, r.Interface,
-				unix.RTF_UP|unix.RTF_HOST)
-		}
-
-		if errNw != nil {
-			logError("can not set route direct link: %v", errNw)
-			continue
-		}
-
-		err = addNetworkRoute4(dst, net.IP(nw.Mask), gw, r.Interface,
-			unix.RTF_UP|unix.RTF_STATIC|unix.RTF_GATEWAY)
-		if err != nil {
-			logError("can not set route: %v", err)
-			continue
-		}
-
-	}
-
-}
@@ -0,0 +1,101 @@
+package vorteil
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// inNetns runs fn in a new network namespace on a thread which is thrown
+// away afterwards. The loopback device is the only link in it.
+func inNetns(t *testing.T, fn func(lo netlink.Link)) {
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			t.Logf("no network namespace: %s", err.Error())
+			return
+		}
+
+		link, err := netlink.LinkByName("lo")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, netlink.LinkSetUp(link))
+
+		fn(link)
+	}()
+
+	<-done
+
+}
+
+func TestSetMTU(t *testing.T) {
+
+	New(testLogFn)
+
+	inNetns(t, func(lo netlink.Link) {
+
+		mtu := func() int {
+			l, err := netlink.LinkByName("lo")
+			assert.NoError(t, err)
+			return l.Attrs().MTU
+		}
+		def := mtu()
+
+		// unset keeps the default
+		assert.NoError(t, setMTU(lo, 0))
+		assert.Equal(t, def, mtu())
+
+		assert.NoError(t, setMTU(lo, 1400))
+		assert.Equal(t, 1400, mtu())
+
+		assert.Error(t, setMTU(lo, 67))
+		assert.Error(t, setMTU(lo, 65536))
+		assert.Equal(t, 1400, mtu())
+
+	})
+
+}
+
+func TestSetupLoopback(t *testing.T) {
+
+	New(testLogFn)
+
+	inNetns(t, func(lo netlink.Link) {
+
+		assert.NoError(t, netlink.LinkSetDown(lo))
+
+		check := func() {
+			l, err := netlink.LinkByName("lo")
+			assert.NoError(t, err)
+			assert.NotZero(t, l.Attrs().Flags&net.FlagUp)
+
+			addrs, err := netlink.AddrList(l, netlink.FAMILY_ALL)
+			assert.NoError(t, err)
+
+			var found []string
+			for _, a := range addrs {
+				found = append(found, a.IPNet.String())
+			}
+			assert.ElementsMatch(t, []string{"127.0.0.1/8", "::1/128"}, found)
+		}
+
+		assert.NoError(t, setupLoopback())
+		check()
+
+		// running again changes nothing
+		assert.NoError(t, setupLoopback())
+		check()
+
+	})
+
+}
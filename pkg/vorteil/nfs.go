@@ -63,7 +63,9 @@ func setupNFS(mounts []vcfg.NFSSettings) {
 		a = append(a, fmt.Sprintf("addr=%s", s.String()))
 
 		logAlways("nfs mount %s to %s with %s", srvInfo[1], mp, strings.Join(a[:], ","))
-		os.MkdirAll(mp, 0755)
+		withWritableRoot("nfs mount point", func() error {
+			return os.MkdirAll(mp, 0755)
+		})
 
 		err := syscall.Mount(fmt.Sprintf(":%s", srvInfo[1]), mp, "nfs", 0, strings.Join(a[:], ","))
 		if err != nil {
@@ -6,13 +6,28 @@
 package vorteil
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ntpPort = "123"
+
+	// seconds between 1900 and 1970
+	ntpEpochOffset = 2208988800
+
+	ntpPacketSize = 48
 )
 
 // NTP vars
@@ -22,8 +37,118 @@ driftfile /etc/chrony.drift
 makestep 1.0 3
 rtcsync`
 	chronydCfgPath = "/etc/chrony.conf"
+
+	ntpTimeout = 2 * time.Second
+
+	// steps the system clock, replaced in tests
+	setClock = func(t time.Time) error {
+		tv := unix.NsecToTimeval(t.UnixNano())
+		return unix.Settimeofday(&tv)
+	}
+
+	// set to 1 once the clock has been synced or can not be synced
+	clockReady int32
 )
 
+// wallClockReady reports if the system time can be used in log messages
+func wallClockReady() bool {
+	return atomic.LoadInt32(&clockReady) == 1
+}
+
+func ntpTime(b []byte) time.Time {
+	sec := binary.BigEndian.Uint32(b[0:4])
+	frac := binary.BigEndian.Uint32(b[4:8])
+	nsec := (int64(frac) * 1e9) >> 32
+	return time.Unix(int64(sec)-ntpEpochOffset, nsec)
+}
+
+func putNTPTime(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b[0:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:8], uint32((int64(t.Nanosecond())<<32)/1e9))
+}
+
+// sntpOffset asks the server for the time and returns the offset of the
+// local clock (rfc 4330)
+func sntpOffset(server string) (time.Duration, error) {
+
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, ntpPort)
+	}
+
+	conn, err := net.DialTimeout("udp", addr, ntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ntpTimeout))
+
+	// version 4, client mode
+	req := make([]byte, ntpPacketSize)
+	req[0] = 0x23
+
+	sent := time.Now()
+	putNTPTime(req[40:], sent)
+
+	if _, err = conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, ntpPacketSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+
+	switch {
+	case n < ntpPacketSize:
+		return 0, fmt.Errorf("short ntp response from %s", server)
+	case resp[0]&0x7 != 4:
+		return 0, fmt.Errorf("no ntp server response from %s", server)
+	case resp[1] == 0:
+		return 0, fmt.Errorf("ntp server %s declined request", server)
+	case string(resp[24:32]) != string(req[40:48]):
+		return 0, fmt.Errorf("ntp response from %s does not match request", server)
+	}
+
+	t2 := ntpTime(resp[32:])
+	t3 := ntpTime(resp[40:])
+
+	return (t2.Sub(sent) + t3.Sub(received)) / 2, nil
+}
+
+// syncClock steps the clock to the time of the first server answering. If
+// none does the time from the rtc is kept.
+func syncClock(servers []string) {
+
+	defer atomic.StoreInt32(&clockReady, 1)
+
+	if len(servers) == 0 {
+		return
+	}
+
+	var err error
+	for _, s := range servers {
+
+		var offset time.Duration
+		offset, err = sntpOffset(s)
+		if err != nil {
+			logDebug("can not get time from %s: %s", s, err.Error())
+			continue
+		}
+
+		if err = setClock(time.Now().Add(offset)); err != nil {
+			break
+		}
+
+		logAlways("clock stepped by %v using %s", offset, s)
+		return
+	}
+
+	logWarn("can not sync clock, using rtc time: %s", err.Error())
+}
+
 func setupChronyD(ntps []string) error {
 
 	logDebug("ntp servers found: %d", len(ntps))
@@ -32,20 +157,27 @@ func setupChronyD(ntps []string) error {
 	if len(ntps) != 0 {
 
 		logAlways("ntp servers\t: %s", strings.Join(ntps, ", "))
-		if _, err := os.Stat(filepath.Dir(chronydCfgPath)); os.IsNotExist(err) {
-			if err := os.MkdirAll(filepath.Dir(chronydCfgPath), 0755); err != nil {
-				return fmt.Errorf("could not create directory: %v", err)
-			}
-		}
 
 		// Prepend servers to config data
 		for _, ntpServer := range ntps {
 			chronydCfgData = fmt.Sprintf("server %s iburst\n%s", ntpServer, chronydCfgData)
 		}
 
-		// Write config data
-		if err := ioutil.WriteFile(chronydCfgPath, []byte(chronydCfgData), 0644); err != nil {
-			return fmt.Errorf("could not write config file: %v", err)
+		err := withWritableRoot("ntp config", func() error {
+			if _, err := os.Stat(filepath.Dir(chronydCfgPath)); os.IsNotExist(err) {
+				if err := os.MkdirAll(filepath.Dir(chronydCfgPath), 0755); err != nil {
+					return fmt.Errorf("could not create directory: %v", err)
+				}
+			}
+
+			// Write config data
+			if err := ioutil.WriteFile(chronydCfgPath, []byte(chronydCfgData), 0644); err != nil {
+				return fmt.Errorf("could not write config file: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
 		logDebug("ntp config:\n %s", chronydCfgData)
@@ -56,7 +188,7 @@ func setupChronyD(ntps []string) error {
 			Credential: &syscall.Credential{Uid: uint32(rootID), Gid: uint32(rootID)},
 		}
 
-		err := chronydCMD.Start()
+		err = chronydCMD.Start()
 		if err != nil {
 			return fmt.Errorf("could not execute chronyd: %v", err)
 		}
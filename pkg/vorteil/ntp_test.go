@@ -0,0 +1,90 @@
+package vorteil
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockNTPServer answers with its clock an hour ahead
+func mockNTPServer(t *testing.T) *net.UDPConn {
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, ntpPacketSize)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			resp := make([]byte, ntpPacketSize)
+			resp[0] = 0x24 // version 4, server mode
+			resp[1] = 2
+			copy(resp[24:32], buf[40:48])
+
+			now := time.Now().Add(time.Hour)
+			putNTPTime(resp[32:], now)
+			putNTPTime(resp[40:], now)
+
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestNTPTime(t *testing.T) {
+
+	b := make([]byte, 8)
+	now := time.Unix(1600000000, 500000000)
+	putNTPTime(b, now)
+	assert.Equal(t, uint8(0xe3), b[0])
+	assert.InDelta(t, now.UnixNano(), ntpTime(b).UnixNano(), 1)
+
+}
+
+func TestSyncClock(t *testing.T) {
+
+	New(testLogFn)
+
+	conn := mockNTPServer(t)
+	defer conn.Close()
+
+	sc, timeout := setClock, ntpTimeout
+	defer func() {
+		setClock, ntpTimeout = sc, timeout
+	}()
+	ntpTimeout = 200 * time.Millisecond
+
+	var stepped time.Time
+	setClock = func(t time.Time) error {
+		stepped = t
+		return nil
+	}
+
+	atomic.StoreInt32(&clockReady, 0)
+	defer atomic.StoreInt32(&clockReady, 1)
+
+	// the first server does not answer
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+
+	syncClock([]string{dead.LocalAddr().String(), conn.LocalAddr().String()})
+	assert.WithinDuration(t, time.Now().Add(time.Hour), stepped, time.Second)
+	assert.True(t, wallClockReady())
+
+	// no answer keeps the clock
+	atomic.StoreInt32(&clockReady, 0)
+	stepped = time.Time{}
+	syncClock([]string{dead.LocalAddr().String()})
+	assert.True(t, stepped.IsZero())
+	assert.True(t, wallClockReady())
+
+}
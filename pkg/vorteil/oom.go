@@ -0,0 +1,49 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	oomScoreAdjMin = -1000
+	oomScoreAdjMax = 1000
+)
+
+// clampOOMScoreAdj limits the value to the range the kernel accepts
+func clampOOMScoreAdj(adj int) int {
+
+	c := adj
+	if c < oomScoreAdjMin {
+		c = oomScoreAdjMin
+	} else if c > oomScoreAdjMax {
+		c = oomScoreAdjMax
+	}
+
+	if c != adj {
+		logWarn("oom score adjustment %d out of range, using %d", adj, c)
+	}
+
+	return c
+}
+
+// setOOMScoreAdj makes the process more or less likely to be killed if the
+// system is out of memory
+func setOOMScoreAdj(pid, adj int) error {
+
+	adj = clampOOMScoreAdj(adj)
+
+	err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/oom_score_adj", pid), []byte(fmt.Sprintf("%d", adj)), 0644)
+	if err != nil {
+		return err
+	}
+
+	logDebug("oom score adjustment of %d set to %d", pid, adj)
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOOMScoreAdj(t *testing.T) {
+
+	New(testLogFn)
+
+	assert.Equal(t, -1000, clampOOMScoreAdj(-5000))
+	assert.Equal(t, 1000, clampOOMScoreAdj(1001))
+	assert.Equal(t, 500, clampOOMScoreAdj(500))
+
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	assert.NoError(t, setOOMScoreAdj(cmd.Process.Pid, 2000))
+
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/oom_score_adj", cmd.Process.Pid))
+	assert.NoError(t, err)
+	assert.Equal(t, "1000", strings.TrimSpace(string(b)))
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_OOM_SCORE_ADJ=-500"})
+	assert.NoError(t, err)
+	assert.Equal(t, -500, *opts.oomScoreAdj)
+
+}
@@ -0,0 +1,530 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// program options are set as environment variables of the program. they are
+// consumed by vinitd and not passed on to the application.
+const (
+	optPrefix = "VINITD_"
+
+	optPropagateLogLevelAs = "VINITD_PROPAGATE_LOGLEVEL_AS"
+	optSELinuxContext      = "VINITD_SELINUX_CONTEXT"
+	optAppArmorProfile     = "VINITD_APPARMOR_PROFILE"
+	optExecStop            = "VINITD_EXEC_STOP"
+	optExecStopTimeout     = "VINITD_EXEC_STOP_TIMEOUT"
+	optStopSignal          = "VINITD_STOP_SIGNAL"
+	optExecStartPre        = "VINITD_EXEC_START_PRE"
+	optExecStopPost        = "VINITD_EXEC_STOP_POST"
+	optExecHookTimeout     = "VINITD_EXEC_HOOK_TIMEOUT"
+	optPhase               = "VINITD_PHASE"
+	optNeedsNetwork        = "VINITD_NEEDS_NETWORK"
+	optEnvFile             = "VINITD_ENV_FILE"
+	optPIDNamespace        = "VINITD_PID_NAMESPACE"
+	optUnpack              = "VINITD_UNPACK"
+	optUnpackSHA256        = "VINITD_UNPACK_SHA256"
+	optSHA256              = "VINITD_SHA256"
+	optSignature           = "VINITD_SIGNATURE"
+	optRestart             = "VINITD_RESTART"
+	optRestartMaxDelay     = "VINITD_RESTART_MAX_DELAY"
+	optCrashLoopLimit      = "VINITD_CRASH_LOOP_LIMIT"
+	optCrashLoopWindow     = "VINITD_CRASH_LOOP_WINDOW"
+	optName                = "VINITD_NAME"
+	optAfter               = "VINITD_AFTER"
+	optReadyTCP            = "VINITD_READY_TCP"
+	optReadyHTTP           = "VINITD_READY_HTTP"
+	optReadyInterval       = "VINITD_READY_INTERVAL"
+	optReadyTimeout        = "VINITD_READY_TIMEOUT"
+	optStartTimeout        = "VINITD_START_TIMEOUT"
+	optLiveTCP             = "VINITD_LIVE_TCP"
+	optLiveHTTP            = "VINITD_LIVE_HTTP"
+	optLiveExec            = "VINITD_LIVE_EXEC"
+	optLiveInterval        = "VINITD_LIVE_INTERVAL"
+	optLiveDelay           = "VINITD_LIVE_DELAY"
+	optLiveFailures        = "VINITD_LIVE_FAILURES"
+	optMemoryMax           = "VINITD_MEMORY_MAX"
+	optCPUAffinity         = "VINITD_CPU_AFFINITY"
+	optOOMScoreAdj         = "VINITD_OOM_SCORE_ADJ"
+	optUser                = "VINITD_USER"
+	optGroup               = "VINITD_GROUP"
+	optGroups              = "VINITD_GROUPS"
+	optCapabilities        = "VINITD_CAPABILITIES"
+	optLogOutput           = "VINITD_LOG_OUTPUT"
+	optSeccomp             = "VINITD_SECCOMP"
+	optSeccompAction       = "VINITD_SECCOMP_ACTION"
+	optMountNamespace      = "VINITD_MOUNT_NAMESPACE"
+	optBindMounts          = "VINITD_BIND_MOUNTS"
+	optPrivateTmp          = "VINITD_PRIVATE_TMP"
+	optMain                = "VINITD_MAIN"
+
+	// followed by the resource, e.g. VINITD_RLIMIT_NOFILE
+	optRlimitPrefix = "VINITD_RLIMIT_"
+
+	defaultStopTimeout = 10 * time.Second
+	defaultHookTimeout = 30 * time.Second
+
+	// environment of all programs, overridden by their own variables
+	defaultEnvFile = "/etc/vinitd/environment"
+)
+
+// boot phases programs can be launched in, in order
+const (
+	phasePreNetwork launchPhase = iota
+	phasePostNetwork
+	phasePostMounts
+	phaseFinal
+)
+
+type launchPhase int
+
+var phaseNames = map[launchPhase]string{
+	phasePreNetwork:  "pre-network",
+	phasePostNetwork: "post-network",
+	phasePostMounts:  "post-mounts",
+	phaseFinal:       "final",
+}
+
+func parsePhase(value string) (launchPhase, error) {
+	for p, n := range phaseNames {
+		if n == value {
+			return p, nil
+		}
+	}
+	return phasePostMounts, fmt.Errorf("unknown phase %s", value)
+}
+
+func validURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid url %s", value)
+	}
+	return nil
+}
+
+// programOptions are vinitd settings for a single program which are not
+// part of vcfg
+type programOptions struct {
+	// environment variable the vinitd log level gets passed as
+	propagateLogLevelAs string
+
+	// mandatory access control applied on exec
+	selinuxContext  string
+	apparmorProfile string
+
+	// command run on shutdown before the program gets signaled
+	execStop    string
+	stopTimeout time.Duration
+	stopSignal  syscall.Signal
+
+	// commands run before every launch and after every exit
+	execStartPre []string
+	execStopPost []string
+	hookTimeout  time.Duration
+
+	// boot phase the program gets launched in
+	phase launchPhase
+
+	// launched once the network is ready
+	needsNetwork bool
+
+	// files with environment variables, read on every launch
+	envFiles []string
+
+	// run in a new pid namespace with the shim as init, programs of the
+	// same group share one
+	pidNamespace bool
+	pidGroup     string
+
+	// archive extracted to the target directory before the first launch
+	unpackArchive string
+	unpackTarget  string
+	unpackSHA256  string
+
+	// expected digest and signature file of the binary
+	sha256    string
+	signature string
+
+	// restart policy if the program exits
+	restart         string
+	restartMaxDelay time.Duration
+
+	// restarts within the window before the system panics
+	crashLoopLimit  int
+	crashLoopWindow time.Duration
+
+	// name other programs can depend on and the programs started before
+	name  string
+	after []string
+
+	// readiness probe, a tcp address or http url
+	readyTCP      string
+	readyHTTP     string
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+
+	// time to pass the probe or to keep running, 0 disables it
+	startTimeout time.Duration
+
+	// liveness checks while running, terminated after too many failures
+	liveTCP      string
+	liveHTTP     string
+	liveExec     string
+	liveInterval time.Duration
+	liveDelay    time.Duration
+	liveFailures int
+
+	// cgroup v2 memory limit
+	memoryMax string
+
+	// cpus the program runs on
+	cpuAffinity []int
+
+	// preference of the oom killer, nil keeps the inherited value
+	oomScoreAdj *int
+
+	// user and groups as names or ids, override the vcfg privilege
+	user   string
+	group  string
+	groups []string
+
+	// capabilities the program keeps, nil keeps the default
+	capabilities []int
+
+	// system calls allowed, nil does not filter, and the action for others
+	seccomp       []uint32
+	seccompAction string
+
+	// the system shuts down once the program exits without restart
+	main bool
+
+	// own mount namespace with bind mounts and an empty /tmp
+	mountNamespace string
+	bindMounts     []bindMount
+	privateTmp     bool
+
+	// output to files in the logs directory instead of the vcfg settings
+	logOutput string
+
+	// resource limits set before exec
+	rlimits map[int]unix.Rlimit
+}
+
+// parseProgramOptions reads all VINITD_ variables from the environment list and
+// returns the options and the environment without them
+func parseProgramOptions(env []string) (programOptions, []string, error) {
+
+	var (
+		opts = programOptions{
+			stopTimeout: defaultStopTimeout,
+			stopSignal:  syscall.SIGTERM,
+			hookTimeout: defaultHookTimeout,
+			phase:       phasePostMounts,
+			restart:     restartNever,
+
+			restartMaxDelay: restartMaxDelay,
+			crashLoopLimit:  crashLoopLimit,
+			crashLoopWindow: crashLoopWindow,
+			probeInterval:   defaultProbeInterval,
+			probeTimeout:    defaultProbeTimeout,
+			liveInterval:    defaultLiveInterval,
+			liveDelay:       defaultLiveDelay,
+			liveFailures:    defaultLiveFailures,
+			seccompAction:   seccompActionErrno,
+			mountNamespace:  mountNamespaceOff,
+		}
+		rest []string
+	)
+
+	for _, e := range env {
+
+		if !strings.HasPrefix(e, optPrefix) {
+			rest = append(rest, e)
+			continue
+		}
+
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || len(kv[1]) == 0 {
+			return opts, nil, fmt.Errorf("program option %s has no value", kv[0])
+		}
+
+		switch kv[0] {
+		case optPropagateLogLevelAs:
+			opts.propagateLogLevelAs = kv[1]
+		case optSELinuxContext:
+			opts.selinuxContext = kv[1]
+		case optAppArmorProfile:
+			opts.apparmorProfile = kv[1]
+		case optExecStop:
+			opts.execStop = kv[1]
+		case optExecStopTimeout:
+			t, err := positiveInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.stopTimeout = time.Duration(t) * time.Second
+		case optStopSignal:
+			s, err := parseSignal(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			if s == syscall.SIGKILL || s == syscall.SIGSTOP {
+				return opts, nil, fmt.Errorf("program option %s: %s can not be handled", kv[0], kv[1])
+			}
+			opts.stopSignal = s
+		case optExecStartPre:
+			opts.execStartPre = append(opts.execStartPre, kv[1])
+		case optExecStopPost:
+			opts.execStopPost = append(opts.execStopPost, kv[1])
+		case optExecHookTimeout:
+			t, err := nonZeroInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.hookTimeout = time.Duration(t) * time.Second
+		case optPhase:
+			p, err := parsePhase(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.phase = p
+		case optNeedsNetwork:
+			b, err := boolean(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.needsNetwork = b
+		case optEnvFile:
+			opts.envFiles = append(opts.envFiles, kv[1])
+		case optPIDNamespace:
+			b, g, err := parsePIDNamespace(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.pidNamespace, opts.pidGroup = b, g
+		case optUnpack:
+			at := strings.SplitN(kv[1], ":", 2)
+			if len(at) != 2 || at[0] == "" || at[1] == "" {
+				return opts, nil, fmt.Errorf("program option %s not in format archive:directory", kv[0])
+			}
+			opts.unpackArchive, opts.unpackTarget = at[0], at[1]
+		case optUnpackSHA256:
+			opts.unpackSHA256 = kv[1]
+		case optSHA256:
+			opts.sha256 = kv[1]
+		case optSignature:
+			opts.signature = kv[1]
+		case optRestart:
+			r, err := oneOf(kv[1], restartNever, restartOnFailure, restartAlways)
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.restart = r
+		case optRestartMaxDelay:
+			t, err := positiveInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.restartMaxDelay = time.Duration(t) * time.Second
+		case optCrashLoopLimit:
+			l, err := positiveInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.crashLoopLimit = l
+		case optCrashLoopWindow:
+			t, err := positiveInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.crashLoopWindow = time.Duration(t) * time.Second
+		case optName:
+			opts.name = kv[1]
+		case optAfter:
+			for _, n := range strings.Split(kv[1], ",") {
+				if n = strings.TrimSpace(n); n != "" {
+					opts.after = append(opts.after, n)
+				}
+			}
+		case optReadyTCP:
+			if _, _, err := net.SplitHostPort(kv[1]); err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.readyTCP = kv[1]
+		case optReadyHTTP:
+			if err := validURL(kv[1]); err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.readyHTTP = kv[1]
+		case optReadyInterval:
+			t, err := nonZeroInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.probeInterval = time.Duration(t) * time.Second
+		case optReadyTimeout:
+			t, err := positiveInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.probeTimeout = time.Duration(t) * time.Second
+		case optStartTimeout:
+			t, err := positiveInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.startTimeout = time.Duration(t) * time.Second
+		case optLiveTCP:
+			if _, _, err := net.SplitHostPort(kv[1]); err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.liveTCP = kv[1]
+		case optLiveHTTP:
+			if err := validURL(kv[1]); err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.liveHTTP = kv[1]
+		case optLiveExec:
+			opts.liveExec = kv[1]
+		case optLiveInterval:
+			t, err := nonZeroInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.liveInterval = time.Duration(t) * time.Second
+		case optLiveDelay:
+			t, err := positiveInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.liveDelay = time.Duration(t) * time.Second
+		case optLiveFailures:
+			f, err := nonZeroInt(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.liveFailures = f
+		case optMemoryMax:
+			m, err := parseMemoryMax(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.memoryMax = m
+		case optCPUAffinity:
+			c, err := parseCPUList(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.cpuAffinity = c
+		case optOOMScoreAdj:
+			a, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: value '%s' is not a number", kv[0], kv[1])
+			}
+			opts.oomScoreAdj = &a
+		case optUser:
+			opts.user = kv[1]
+		case optGroup:
+			opts.group = kv[1]
+		case optCapabilities:
+			c, err := parseCapabilities(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.capabilities = c
+		case optSeccomp:
+			sc, err := parseSeccomp(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.seccomp = sc
+		case optSeccompAction:
+			a, err := oneOf(kv[1], seccompActionErrno, seccompActionKill, seccompActionLog)
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.seccompAction = a
+		case optMountNamespace:
+			n, err := oneOf(kv[1], mountNamespaceOff, mountNamespaceRequired, mountNamespacePreferred)
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.mountNamespace = n
+		case optBindMounts:
+			b, err := parseBindMounts(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.bindMounts = b
+		case optMain:
+			b, err := boolean(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.main = b
+		case optPrivateTmp:
+			b, err := boolean(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.privateTmp = b
+		case optLogOutput:
+			o, err := oneOf(kv[1], logOutputCombined, logOutputSeparate)
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+			opts.logOutput = o
+		case optGroups:
+			for _, g := range strings.Split(kv[1], ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					opts.groups = append(opts.groups, g)
+				}
+			}
+		default:
+
+			r, ok := rlimitNames[strings.TrimPrefix(kv[0], optRlimitPrefix)]
+			if !ok || !strings.HasPrefix(kv[0], optRlimitPrefix) {
+				logWarn("unknown program option %s", kv[0])
+				continue
+			}
+
+			l, err := parseRlimit(kv[1])
+			if err != nil {
+				return opts, nil, fmt.Errorf("program option %s: %s", kv[0], err.Error())
+			}
+
+			if opts.rlimits == nil {
+				opts.rlimits = make(map[int]unix.Rlimit)
+			}
+			opts.rlimits[r] = l
+
+		}
+
+	}
+
+	// mounts need a namespace, they would be visible to all programs otherwise
+	if (len(opts.bindMounts) > 0 || opts.privateTmp) && opts.mountNamespace == mountNamespaceOff {
+		opts.mountNamespace = mountNamespaceRequired
+	}
+
+	// the network is set up after the pre-network phase
+	if opts.needsNetwork && opts.phase == phasePreNetwork {
+		return opts, nil, fmt.Errorf("program option %s: not possible in phase %s",
+			optNeedsNetwork, phaseNames[phasePreNetwork])
+	}
+
+	return opts, rest, nil
+}
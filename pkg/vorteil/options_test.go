@@ -0,0 +1,144 @@
+package vorteil
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProgramOptions(t *testing.T) {
+
+	New(testLogFn)
+
+	opts, env, err := parseProgramOptions([]string{"A=B",
+		"VINITD_PROPAGATE_LOGLEVEL_AS=RUST_LOG", "VINITD_UNKNOWN=1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "RUST_LOG", opts.propagateLogLevelAs)
+	assert.Equal(t, []string{"A=B"}, env)
+
+	_, _, err = parseProgramOptions([]string{"VINITD_PROPAGATE_LOGLEVEL_AS="})
+	assert.Error(t, err)
+
+	opts, _, err = parseProgramOptions([]string{"VINITD_EXEC_STOP=app drain"})
+	assert.NoError(t, err)
+	assert.Equal(t, "app drain", opts.execStop)
+	assert.Equal(t, defaultStopTimeout, opts.stopTimeout)
+
+	opts, _, err = parseProgramOptions([]string{"VINITD_EXEC_STOP_TIMEOUT=3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3*time.Second, opts.stopTimeout)
+
+	_, _, err = parseProgramOptions([]string{"VINITD_EXEC_STOP_TIMEOUT=soon"})
+	assert.Error(t, err)
+
+	assert.Equal(t, syscall.SIGTERM, opts.stopSignal)
+	opts, _, err = parseProgramOptions([]string{"VINITD_STOP_SIGNAL=quit"})
+	assert.NoError(t, err)
+	assert.Equal(t, syscall.SIGQUIT, opts.stopSignal)
+
+	for _, s := range []string{"SIGNOPE", "KILL", "STOP"} {
+		_, _, err = parseProgramOptions([]string{"VINITD_STOP_SIGNAL=" + s})
+		assert.Error(t, err)
+	}
+
+}
+
+func TestPropagateLogLevel(t *testing.T) {
+
+	logLevel = LogLvWARNING
+	defer func() { logLevel = LogLvDEBUG }()
+
+	env := propagateLogLevel([]string{"A=B"}, "LOG_LEVEL")
+	assert.Equal(t, []string{"A=B", "LOG_LEVEL=warning"}, env)
+
+	// explicit env wins
+	env = propagateLogLevel([]string{"LOG_LEVEL=debug"}, "LOG_LEVEL")
+	assert.Equal(t, []string{"LOG_LEVEL=debug"}, env)
+
+	env = propagateLogLevel([]string{"A=B"}, "")
+	assert.Equal(t, []string{"A=B"}, env)
+
+	l, err := parseLogLevel("Warning")
+	assert.NoError(t, err)
+	assert.Equal(t, LogLevel(LogLvWARNING), l)
+
+	l, err = parseLogLevel("6")
+	assert.NoError(t, err)
+	assert.Equal(t, LogLevel(LogLvINFO), l)
+
+	_, err = parseLogLevel("loud")
+	assert.Error(t, err)
+
+}
+
+func TestSecurityModules(t *testing.T) {
+
+	assert.True(t, hasLSM("capability,yama,apparmor\n", lsmAppArmor))
+	assert.False(t, hasLSM("capability,yama", lsmSELinux))
+
+	profiles := "/usr/bin/app (enforce)\nnginx worker (complain)\n"
+	assert.True(t, hasAppArmorProfile(profiles, "/usr/bin/app"))
+	assert.True(t, hasAppArmorProfile(profiles, "nginx worker"))
+	assert.False(t, hasAppArmorProfile(profiles, "nginx"))
+
+	_, err := execLabel(programOptions{selinuxContext: "a", apparmorProfile: "b"})
+	assert.Error(t, err)
+
+	l, err := execLabel(programOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, l)
+
+}
+
+func TestLaunchPhases(t *testing.T) {
+
+	New(testLogFn)
+
+	// phases are launched in this order
+	assert.True(t, phasePreNetwork < phasePostNetwork)
+	assert.True(t, phasePostNetwork < phasePostMounts)
+	assert.True(t, phasePostMounts < phaseFinal)
+
+	opts, _, err := parseProgramOptions([]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, phasePostMounts, opts.phase)
+
+	_, _, err = parseProgramOptions([]string{"VINITD_PHASE=early"})
+	assert.Error(t, err)
+
+	var progs []*program
+	for _, ph := range []string{"final", "pre-network", "post-mounts", "pre-network"} {
+		opts, _, err := parseProgramOptions([]string{"VINITD_PHASE=" + ph})
+		assert.NoError(t, err)
+		progs = append(progs, &program{opts: opts})
+	}
+
+	assert.Equal(t, []*program{progs[1], progs[3]}, programsInPhase(progs, phasePreNetwork))
+	assert.Empty(t, programsInPhase(progs, phasePostNetwork))
+	assert.Equal(t, []*program{progs[2]}, programsInPhase(progs, phasePostMounts))
+	assert.Equal(t, []*program{progs[0]}, programsInPhase(progs, phaseFinal))
+
+}
+
+func TestNoProgramsAction(t *testing.T) {
+
+	v := New(testLogFn)
+	for _, p := range v.vcfg.Programs {
+		_, err := v.prepProgram(p)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, noProgramsPoweroff, noProgramsAction(len(v.programList()), noProgramsPoweroff))
+	assert.Equal(t, noProgramsHold, noProgramsAction(0, noProgramsHold))
+	assert.Empty(t, noProgramsAction(1, noProgramsPoweroff))
+
+	o, err := parseKernelOptions("vinitd.no-programs=hold")
+	assert.NoError(t, err)
+	assert.Equal(t, noProgramsHold, o.noPrograms)
+
+	_, err = parseKernelOptions("vinitd.no-programs=shell")
+	assert.Error(t, err)
+
+}
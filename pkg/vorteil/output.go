@@ -0,0 +1,192 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	logOutputCombined = "combined"
+	logOutputSeparate = "separate"
+
+	// prefixes for program output on the screen
+	outputPrefixOff   = "off"
+	outputPrefixName  = "name"
+	outputPrefixColor = "color"
+)
+
+// directory for program log files, replaced in tests
+var logsDir = "/vorteil/logs"
+
+// outputPaths returns the files stdout and stderr of the program are written to
+func (p *program) outputPaths() (string, string) {
+
+	switch p.opts.logOutput {
+	case logOutputCombined:
+		f := filepath.Join(logsDir, fmt.Sprintf("%s.log", p.name()))
+		return f, f
+	case logOutputSeparate:
+		return filepath.Join(logsDir, fmt.Sprintf("%s.stdout.log", p.name())),
+			filepath.Join(logsDir, fmt.Sprintf("%s.stderr.log", p.name()))
+	}
+
+	return p.vcfgProg.Stdout, p.vcfgProg.Stderr
+}
+
+// openProgramOutput opens the file for appending. If that fails the output
+// goes to the tty.
+func openProgramOutput(path string) (*os.File, error) {
+
+	// create the directory if it does not exist
+	if _, err := os.Stat(filepath.Dir(path)); os.IsNotExist(err) {
+		os.MkdirAll(filepath.Dir(path), 0755)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err == nil || path == defaultTTY {
+		return f, err
+	}
+
+	logWarn("can not open %s, using %s: %s", path, defaultTTY, err.Error())
+
+	return os.OpenFile(defaultTTY, os.O_WRONLY|os.O_APPEND, 0)
+}
+
+// openOutputs opens stdout and stderr of the program, combined output shares
+// one file
+func (p *program) openOutputs() (*os.File, *os.File, error) {
+
+	out, errOut := p.outputPaths()
+
+	stdout, err := openProgramOutput(out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stderr := stdout
+	if errOut != out {
+		stderr, err = openProgramOutput(errOut)
+		if err != nil {
+			stdout.Close()
+			return nil, nil, err
+		}
+	}
+
+	if kernelOpts.outputPrefix == outputPrefixOff {
+		return stdout, stderr, nil
+	}
+
+	stdout, err = p.prefixTTY(stdout, out)
+	if errOut == out {
+		return stdout, stdout, err
+	}
+	if err != nil {
+		stderr.Close()
+		return nil, nil, err
+	}
+
+	stderr, err = p.prefixTTY(stderr, errOut)
+	if err != nil {
+		stdout.Close()
+		return nil, nil, err
+	}
+
+	return stdout, stderr, nil
+}
+
+// prefixTTY adds the program name to output going to the screen
+func (p *program) prefixTTY(f *os.File, path string) (*os.File, error) {
+
+	if path != defaultTTY {
+		return f, nil
+	}
+
+	pf, err := prefixOutput(p.name(), kernelOpts.outputPrefix == outputPrefixColor, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return pf, nil
+}
+
+// prefixWriter writes each line with the program name in front, partial
+// lines are kept until they are complete
+type prefixWriter struct {
+	prefix string
+	out    *os.File
+	buf    []byte
+}
+
+// ansi colors for the program names
+var prefixColors = []int{31, 32, 33, 34, 35, 36}
+
+func newPrefixWriter(name string, color bool, out *os.File) *prefixWriter {
+
+	prefix := fmt.Sprintf("[%s]", name)
+
+	if color {
+		var h int
+		for _, c := range name {
+			h += int(c)
+		}
+		prefix = fmt.Sprintf("\x1b[%dm%s\x1b[0m", prefixColors[h%len(prefixColors)], prefix)
+	}
+
+	return &prefixWriter{
+		prefix: prefix,
+		out:    out,
+	}
+}
+
+func (w *prefixWriter) Write(b []byte) (int, error) {
+
+	w.buf = append(w.buf, b...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		writeToOut(w.out, "%s %s", w.prefix, string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(b), nil
+}
+
+// Flush writes a remaining partial line
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		writeToOut(w.out, "%s %s", w.prefix, string(w.buf))
+		w.buf = nil
+	}
+}
+
+// prefixOutput returns a pipe for the program output. The lines written to
+// it are passed on to out with the program name in front.
+func prefixOutput(name string, color bool, out *os.File) (*os.File, error) {
+
+	r, wp, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		w := newPrefixWriter(name, color, out)
+		io.Copy(w, r)
+		w.Flush()
+		r.Close()
+		out.Close()
+	}()
+
+	return wp, nil
+}
@@ -0,0 +1,101 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func TestProgramOutput(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "logs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logsDir = filepath.Join(dir, "logs")
+	defer func() {
+		logsDir = "/vorteil/logs"
+	}()
+
+	run := func(p *program) {
+		stdout, stderr, err := p.openOutputs()
+		assert.NoError(t, err)
+		defer stdout.Close()
+		defer stderr.Close()
+
+		cmd := exec.Command("sh", "-c", "echo out; echo err >&2")
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		assert.NoError(t, cmd.Run())
+	}
+
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(logsDir, name))
+		assert.NoError(t, err)
+		return string(b)
+	}
+
+	p := &program{
+		vcfgProg: vcfg.Program{Binary: "/bin/app"},
+		opts:     programOptions{logOutput: logOutputCombined},
+	}
+	run(p)
+	assert.Equal(t, "out\nerr\n", read("app.log"))
+
+	p.opts.logOutput = logOutputSeparate
+	run(p)
+	assert.Equal(t, "out\n", read("app.stdout.log"))
+	assert.Equal(t, "err\n", read("app.stderr.log"))
+
+	// appended on restarts
+	run(p)
+	assert.Equal(t, "out\nout\n", read("app.stdout.log"))
+
+	// without option the vcfg files are used
+	p.opts.logOutput = ""
+	p.vcfgProg.Stdout = filepath.Join(dir, "vcfg", "out")
+	p.vcfgProg.Stderr = filepath.Join(dir, "vcfg", "err")
+	run(p)
+	b, err := ioutil.ReadFile(p.vcfgProg.Stderr)
+	assert.NoError(t, err)
+	assert.Equal(t, "err\n", string(b))
+
+}
+
+func TestPrefixWriter(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "prefix")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := newPrefixWriter("app", false, f)
+
+	for _, c := range []string{"hel", "lo\nwor", "ld\n\nsplit", " line\npartial"} {
+		n, err := w.Write([]byte(c))
+		assert.NoError(t, err)
+		assert.Equal(t, len(c), n)
+	}
+	w.Flush()
+
+	b, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+
+	// lines start with the uptime
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		lines = append(lines, strings.SplitN(l, " ", 2)[1])
+	}
+	assert.Equal(t, []string{"[app] hello", "[app] world", "[app] ", "[app] split line", "[app] partial"}, lines)
+
+	assert.Contains(t, newPrefixWriter("app", true, f).prefix, "\x1b[")
+
+}
@@ -7,58 +7,98 @@ package vorteil
 
 import (
 	"bufio"
-	"fmt"
+	"encoding/binary"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
+)
+
+const (
+	// input event type and code of the power button
+	evKey    = 0x01
+	keyPower = 116
 
-	"golang.org/x/sys/unix"
+	// acpi sends a press for each event, some hypervisors repeat them
+	powerDebounce = 2 * time.Second
 )
 
-func listenPowerLoop(epfd int, events [1]unix.EpollEvent) {
+// replaced in tests
+var shutdownFn = shutdown
+
+// Reboot stops all programs and restarts the machine. The reason is logged.
+func Reboot(reason string) {
+	logAlways("rebooting: %s", reason)
+	shutdownFn(syscall.LINUX_REBOOT_CMD_RESTART, 0)
+}
+
+// Poweroff stops all programs and powers off the machine. The reason is
+// logged.
+func Poweroff(reason string) {
+	logAlways("powering off: %s", reason)
+	shutdownFn(syscall.LINUX_REBOOT_CMD_POWER_OFF, 0)
+}
+
+// Halt stops all programs and halts the machine without powering it off.
+// The reason is logged.
+func Halt(reason string) {
+	logAlways("halting: %s", reason)
+	shutdownFn(syscall.LINUX_REBOOT_CMD_HALT, 0)
+}
+
+// inputEvent is struct input_event of the kernel on 64 bit
+type inputEvent struct {
+	Sec, Usec int64
+	Type      uint16
+	Code      uint16
+	Value     int32
+}
+
+// readPowerEvents calls press for key presses of the power button. Presses
+// within powerDebounce of the last one are ignored.
+func readPowerEvents(r io.Reader, press func()) error {
+
+	var (
+		ev   inputEvent
+		last time.Time
+	)
+
 	for {
-		n, err := unix.EpollWait(epfd, events[:], -1)
+		err := binary.Read(r, binary.LittleEndian, &ev)
 		if err != nil {
-			if e, ok := err.(syscall.Errno); ok {
-				if e.Temporary() {
-					continue
-				}
-			}
+			return err
 		}
 
-		if n == 1 && events[0].Events&unix.EPOLLIN == unix.EPOLLIN {
-			// we don't check, it has to be poweroff
-			shutdown(syscall.LINUX_REBOOT_CMD_POWER_OFF, 0)
+		if ev.Type != evKey || ev.Code != keyPower || ev.Value != 1 {
+			continue
 		}
+
+		now := time.Now()
+		if !last.IsZero() && now.Sub(last) < powerDebounce {
+			logDebug("ignoring repeated power button press")
+			continue
+		}
+		last = now
+
+		press()
 	}
 }
 
 func listenToPowerEventFile(name string) {
 
-	var event unix.EpollEvent
-	var events [1]unix.EpollEvent
-
-	pwr, err := os.Open(fmt.Sprintf("/dev/input/%s", name))
+	pwr, err := os.Open(filepath.Join("/dev/input", name))
 	if err != nil {
-		logWarn("can not listen to power off (dev): %s", err.Error())
+		logDebug("can not listen to power button %s: %s", name, err.Error())
 		return
 	}
 	defer pwr.Close()
-	fd := int(pwr.Fd())
-
-	epfd, err := unix.EpollCreate1(0)
-	if err != nil {
-		logWarn("can not listen to power off (epoll): %s", err.Error())
-	}
-	defer unix.Close(epfd)
 
-	event.Events = unix.EPOLLIN
-	event.Fd = int32(fd)
-	if err := unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, fd, &event); err != nil {
-		logWarn("can not listen to power off (epolladd): %s", err.Error())
-	}
-
-	listenPowerLoop(epfd, events)
+	err = readPowerEvents(pwr, func() {
+		go Poweroff("power button")
+	})
+	logDebug("stopped listening to power button %s: %s", name, err.Error())
 
 }
 
@@ -87,49 +127,56 @@ func prepSbinPower() {
 
 }
 
-func listenToPowerEvent() {
-
-	f, err := os.OpenFile("/proc/bus/input/devices", os.O_RDONLY, os.ModePerm)
-	if err != nil {
-		logWarn("can not listen to power off (devices): %s", err.Error())
-		return
-	}
-	defer f.Close()
+// powerButtonHandlers returns the event devices of all power buttons in
+// /proc/bus/input/devices
+func powerButtonHandlers(r io.Reader) []string {
 
-	sc := bufio.NewScanner(f)
-	toggle := false
+	var (
+		handlers []string
+		button   bool
+	)
 
-	// find the handler string for device
-	var l string
+	sc := bufio.NewScanner(r)
 	for sc.Scan() {
-		l = sc.Text()
-
-		if strings.Contains(l, "Power Button") {
-			toggle = true
+		l := sc.Text()
+
+		switch {
+		case l == "":
+			button = false
+		case strings.HasPrefix(l, "N: ") && strings.Contains(l, "Power Button"):
+			button = true
+		case button && strings.HasPrefix(l, "H: Handlers="):
+			for _, h := range strings.Fields(strings.TrimPrefix(l, "H: Handlers=")) {
+				if strings.HasPrefix(h, "event") {
+					handlers = append(handlers, h)
+				}
+			}
 		}
+	}
 
-		if toggle && strings.Contains(l, "Handlers") {
-			break
-		}
+	return handlers
+}
 
-	}
+// listenToPowerEvent powers off gracefully if the hypervisor presses the
+// power button. Machines without a power button can only be stopped from
+// the inside.
+func listenToPowerEvent() {
 
-	if err := sc.Err(); err != nil {
-		logWarn("can not listen to power off (scan): %s", err.Error())
+	f, err := os.Open("/proc/bus/input/devices")
+	if err != nil {
+		logDebug("can not listen to power button: %s", err.Error())
 		return
 	}
+	handlers := powerButtonHandlers(f)
+	f.Close()
 
-	ss := strings.SplitN(l, "Handlers=", 2)
-
-	if len(ss) != 2 {
-		logWarn("can not listen to power off (handlers): no handler")
+	if len(handlers) == 0 {
+		logDebug("no power button available")
 		return
 	}
 
-	for _, s := range strings.Fields(ss[1]) {
-		if strings.HasPrefix(s, "event") {
-			listenToPowerEventFile(s)
-		}
+	for _, h := range handlers {
+		go listenToPowerEventFile(h)
 	}
 
 }
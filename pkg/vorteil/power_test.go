@@ -0,0 +1,88 @@
+package vorteil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebootPoweroff(t *testing.T) {
+
+	var logged []string
+	New(func(level LogLevel, format string, values ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, values...))
+	})
+
+	sf := shutdownFn
+	defer func() {
+		shutdownFn = sf
+	}()
+
+	var cmds []int
+	shutdownFn = func(cmd, timeout int) {
+		cmds = append(cmds, cmd)
+	}
+
+	Reboot("update installed")
+	Poweroff("power button")
+
+	assert.Equal(t, []int{syscall.LINUX_REBOOT_CMD_RESTART, syscall.LINUX_REBOOT_CMD_POWER_OFF}, cmds)
+	assert.Contains(t, logged, "rebooting: update installed")
+	assert.Contains(t, logged, "powering off: power button")
+
+}
+
+func TestPowerButton(t *testing.T) {
+
+	New(testLogFn)
+
+	devices := `I: Bus=0019 Vendor=0000 Product=0001 Version=0000
+N: Name="Power Button"
+H: Handlers=kbd event0
+
+I: Bus=0011 Vendor=0001 Product=0001 Version=ab41
+N: Name="AT Translated Set 2 keyboard"
+H: Handlers=sysrq kbd event1 leds
+
+I: Bus=0019 Vendor=0000 Product=0001 Version=0000
+N: Name="Power Button"
+H: Handlers=kbd event2
+`
+	assert.Equal(t, []string{"event0", "event2"}, powerButtonHandlers(strings.NewReader(devices)))
+	assert.Empty(t, powerButtonHandlers(strings.NewReader("")))
+
+	sf := shutdownFn
+	defer func() {
+		shutdownFn = sf
+	}()
+
+	var cmds []int
+	shutdownFn = func(cmd, timeout int) {
+		cmds = append(cmds, cmd)
+	}
+
+	// press and release, a repeated press, another key and a sync event
+	var buf bytes.Buffer
+	for _, ev := range []inputEvent{
+		{Type: evKey, Code: keyPower, Value: 1},
+		{Type: evKey, Code: keyPower, Value: 0},
+		{Type: 0, Code: 0, Value: 0},
+		{Type: evKey, Code: keyPower, Value: 1},
+		{Type: evKey, Code: 30, Value: 1},
+	} {
+		binary.Write(&buf, binary.LittleEndian, ev)
+	}
+
+	err := readPowerEvents(&buf, func() {
+		Poweroff("power button")
+	})
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []int{syscall.LINUX_REBOOT_CMD_POWER_OFF}, cmds)
+
+}
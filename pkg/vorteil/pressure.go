@@ -0,0 +1,172 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pressureCPU    = "/proc/pressure/cpu"
+	pressureMemory = "/proc/pressure/memory"
+
+	pressureInterval = 500 * time.Millisecond
+	pressureMaxWait  = 60 * time.Second
+
+	// used if pressure gating is requested but the kernel has no PSI
+	defaultLaunchConcurrency = 1
+)
+
+// launch slot of a program without probe or start timeout is held this
+// long after the start, replaced in tests
+var launchSettle = time.Second
+
+// launchGate limits how many programs are getting launched at the same time
+// and holds launches back while the system is under pressure
+type launchGate struct {
+	slots     chan bool
+	threshold float64
+	psi       bool
+}
+
+// parsePressure returns the 'some avg10' value of a /proc/pressure file
+func parsePressure(content string) (float64, error) {
+
+	for _, l := range strings.Split(content, "\n") {
+
+		f := strings.Fields(l)
+		if len(f) == 0 || f[0] != "some" {
+			continue
+		}
+
+		for _, kv := range f[1:] {
+			if strings.HasPrefix(kv, "avg10=") {
+				return strconv.ParseFloat(strings.TrimPrefix(kv, "avg10="), 64)
+			}
+		}
+
+	}
+
+	return 0, fmt.Errorf("no avg10 value in pressure data")
+}
+
+// systemPressure returns the higher value of cpu and memory pressure
+func systemPressure() (float64, error) {
+
+	var p float64
+
+	for _, f := range []string{pressureCPU, pressureMemory} {
+		c, err := ioutil.ReadFile(f)
+		if err != nil {
+			return 0, err
+		}
+		v, err := parsePressure(string(c))
+		if err != nil {
+			return 0, err
+		}
+		if v > p {
+			p = v
+		}
+	}
+
+	return p, nil
+}
+
+func newLaunchGate(concurrency int, threshold float64) *launchGate {
+
+	g := &launchGate{
+		threshold: threshold,
+	}
+
+	if threshold > 0 {
+		if _, err := systemPressure(); err == nil {
+			g.psi = true
+		} else if concurrency == 0 {
+			logWarn("pressure information not available, launching %d programs at a time", defaultLaunchConcurrency)
+			concurrency = defaultLaunchConcurrency
+		}
+	}
+
+	if concurrency > 0 {
+		g.slots = make(chan bool, concurrency)
+	}
+
+	return g
+}
+
+// acquire blocks until the program can be launched
+func (g *launchGate) acquire(name string) {
+
+	if g.slots != nil {
+		g.slots <- true
+	}
+
+	if !g.psi {
+		return
+	}
+
+	start := time.Now()
+	logged := false
+
+	for {
+		p, err := systemPressure()
+		if err != nil || p <= g.threshold {
+			return
+		}
+
+		if !logged {
+			logAlways("throttling launch of %s, pressure %.2f above %.2f", name, p, g.threshold)
+			logged = true
+		}
+
+		if time.Since(start) > pressureMaxWait {
+			logWarn("pressure still %.2f, launching %s anyway", p, name)
+			return
+		}
+
+		time.Sleep(pressureInterval)
+	}
+
+}
+
+// releaseWhenStarted frees the launch slot once the program has started. A
+// program with a probe has to pass it, one with a start timeout has to keep
+// running for its start window, others hold the slot for launchSettle. The
+// slot is freed as well if the program exits.
+func (g *launchGate) releaseWhenStarted(p *program, done <-chan struct{}) {
+
+	var (
+		ready   <-chan struct{}
+		settled <-chan time.Time
+	)
+
+	switch {
+	case p.opts.hasProbe():
+		ready = p.ready
+	case p.opts.startTimeout > 0:
+		settled = time.After(p.opts.startWindow())
+	default:
+		settled = time.After(launchSettle)
+	}
+
+	select {
+	case <-ready:
+	case <-settled:
+	case <-done:
+	}
+
+	g.release()
+}
+
+func (g *launchGate) release() {
+	if g.slots != nil {
+		<-g.slots
+	}
+}
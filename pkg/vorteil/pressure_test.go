@@ -0,0 +1,82 @@
+package vorteil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePressure(t *testing.T) {
+
+	p, err := parsePressure(`some avg10=12.50 avg60=3.00 avg300=0.10 total=1234
+full avg10=1.00 avg60=0.00 avg300=0.00 total=10
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, 12.5, p)
+
+	_, err = parsePressure("")
+	assert.Error(t, err)
+
+}
+
+func TestLaunchGateConcurrency(t *testing.T) {
+
+	settle := launchSettle
+	defer func() {
+		launchSettle = settle
+	}()
+	launchSettle = 100 * time.Millisecond
+
+	g := newLaunchGate(1, 0)
+
+	// the next launch waits for the release point of the previous program
+	// and starts right after it
+	launchAfter := func(p *program, done chan struct{}, release func()) []string {
+
+		events := make(chan string, 2)
+
+		g.acquire("a")
+		go g.releaseWhenStarted(p, done)
+
+		go func() {
+			g.acquire("b")
+			events <- "b launched"
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		events <- "a started"
+		release()
+
+		order := []string{<-events, <-events}
+		g.release()
+
+		return order
+	}
+
+	expected := []string{"a started", "b launched"}
+
+	// probe passed
+	p := &program{
+		opts:  programOptions{readyTCP: "127.0.0.1:1"},
+		ready: make(chan struct{}),
+	}
+	assert.Equal(t, expected, launchAfter(p, make(chan struct{}), p.markReady))
+
+	// exited before it got ready
+	p = &program{
+		opts:  programOptions{readyTCP: "127.0.0.1:1"},
+		ready: make(chan struct{}),
+	}
+	done := make(chan struct{})
+	assert.Equal(t, expected, launchAfter(p, done, func() { close(done) }))
+
+	// kept running for its start window
+	p = &program{opts: programOptions{startTimeout: 100 * time.Millisecond}}
+	assert.Equal(t, expected, launchAfter(p, make(chan struct{}), func() {}))
+
+	// settle time without probe or start timeout
+	p = &program{}
+	assert.Equal(t, expected, launchAfter(p, make(chan struct{}), func() {}))
+
+}
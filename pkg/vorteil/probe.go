@@ -0,0 +1,165 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultProbeInterval = time.Second
+	defaultProbeTimeout  = 60 * time.Second
+)
+
+var errProbeExited = errors.New("program exited")
+
+// hasProbe reports if the program has to pass a probe to be ready
+func (o programOptions) hasProbe() bool {
+	return o.readyTCP != "" || o.readyHTTP != ""
+}
+
+// readyTimeout returns the time the probe may take, the start timeout if
+// that is shorter. 0 waits forever.
+func (o programOptions) readyTimeout() time.Duration {
+	t := o.probeTimeout
+	if o.startTimeout > 0 && (t == 0 || o.startTimeout < t) {
+		t = o.startTimeout
+	}
+	return t
+}
+
+// probeOnce checks if the program is ready
+func probeOnce(o programOptions) error {
+
+	timeout := o.probeInterval
+	if timeout > time.Second || timeout <= 0 {
+		timeout = time.Second
+	}
+
+	return checkEndpoint(o.readyTCP, o.readyHTTP, timeout)
+}
+
+// checkEndpoint checks if the address accepts connections and the url
+// answers with 2xx. Empty values are not checked.
+func checkEndpoint(addr, url string, timeout time.Duration) error {
+
+	if addr != "" {
+		c, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return err
+		}
+		c.Close()
+	}
+
+	if url != "" {
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("http status %d", resp.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// waitForProbe polls the probe until it passes, the program exits or the
+// probe times out. A timeout of 0 waits forever.
+func waitForProbe(name string, o programOptions, done <-chan struct{}) error {
+
+	timeout := o.readyTimeout()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+
+	for {
+
+		err := probeOnce(o)
+		if err == nil {
+			logDebug("%s probe passed, ready", name)
+			return nil
+		}
+		logDebug("%s probe failed, not ready: %s", name, err.Error())
+
+		select {
+		case <-done:
+			return errProbeExited
+		case <-deadline:
+			return fmt.Errorf("not ready after %v: %s", timeout, err.Error())
+		case <-time.After(o.probeInterval):
+		}
+
+	}
+
+}
+
+// probeReady marks the program ready once its probe passes. On timeout the
+// program is killed, waitForApp restarts it if its restart policy allows it
+// or fails the programs depending on it.
+func (p *program) probeReady() {
+
+	err := waitForProbe(p.name(), p.opts, p.done)
+	switch {
+	case err == nil:
+		p.markStarted()
+		p.markReady()
+		return
+	case err == errProbeExited:
+		// handled by waitForApp like a timeout
+		return
+	}
+
+	logError("program %s %s, killing it", p.name(), err.Error())
+	p.cmd.Process.Signal(syscall.SIGKILL)
+
+}
+
+// startWindow returns how long a program without probe has to keep running
+// to be started, the stability window or the start timeout if that is shorter
+func (o programOptions) startWindow() time.Duration {
+	if o.startTimeout > 0 && o.startTimeout < restartStable {
+		return o.startTimeout
+	}
+	return restartStable
+}
+
+// watchStart marks a program without probe started once it has been
+// running for its start window
+func (p *program) watchStart(done <-chan struct{}) {
+
+	select {
+	case <-done:
+	case <-time.After(p.opts.startWindow()):
+		p.markStarted()
+	}
+
+}
+
+// failedStart reports if the program exited before it had been started.
+// It is restarted regardless of its exit code if it has a start timeout.
+func (p *program) failedStart() bool {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	return p.opts.startTimeout > 0 && !p.started
+}
+
+// markStarted records that the program is running, read by waitForApp
+func (p *program) markStarted() {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	p.started = true
+	p.status.State = stateRunning
+}
@@ -0,0 +1,140 @@
+package vorteil
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessProbe(t *testing.T) {
+
+	New(testLogFn)
+
+	// reserve a port and free it, the program is not listening yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	o := programOptions{
+		readyTCP:      addr,
+		probeInterval: 50 * time.Millisecond,
+		probeTimeout:  5 * time.Second,
+	}
+	assert.Error(t, probeOnce(o))
+
+	p := &program{opts: o, ready: make(chan struct{}), done: make(chan struct{})}
+	go p.probeReady()
+
+	select {
+	case <-p.ready:
+		t.Fatal("ready without listener")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	l, err = net.Listen("tcp", addr)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	select {
+	case <-p.ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("probe did not pass")
+	}
+
+	// http expects 2xx
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	o = programOptions{readyHTTP: srv.URL, probeInterval: 50 * time.Millisecond}
+	assert.Error(t, probeOnce(o))
+	status = http.StatusOK
+	assert.NoError(t, probeOnce(o))
+
+	// timeout and exit while waiting
+	o = programOptions{readyHTTP: srv.URL + "/x", probeInterval: 50 * time.Millisecond, probeTimeout: 100 * time.Millisecond}
+	status = http.StatusNotFound
+	assert.Error(t, waitForProbe("test", o, nil))
+
+	done := make(chan struct{})
+	close(done)
+	o.probeTimeout = 0
+	assert.Equal(t, errProbeExited, waitForProbe("test", o, done))
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_READY_TCP=localhost:80", "VINITD_READY_TIMEOUT=5"})
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:80", opts.readyTCP)
+	assert.Equal(t, 5*time.Second, opts.probeTimeout)
+
+	_, _, err = parseProgramOptions([]string{"VINITD_READY_HTTP=localhost"})
+	assert.Error(t, err)
+
+}
+
+func TestStartTimeout(t *testing.T) {
+
+	New(testLogFn)
+
+	o := programOptions{probeTimeout: time.Minute}
+	assert.Equal(t, time.Minute, o.readyTimeout())
+	o.startTimeout = time.Second
+	assert.Equal(t, time.Second, o.readyTimeout())
+	o.probeTimeout = 0
+	assert.Equal(t, time.Second, o.readyTimeout())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	// never listens, killed after the start timeout
+	cmd := exec.Command("sleep", "5")
+	assert.NoError(t, cmd.Start())
+
+	p := &program{
+		cmd: cmd,
+		opts: programOptions{
+			readyTCP:      addr,
+			probeInterval: 50 * time.Millisecond,
+			probeTimeout:  time.Minute,
+			startTimeout:  200 * time.Millisecond,
+			restart:       restartOnFailure,
+		},
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	start := time.Now()
+	p.probeReady()
+	assert.Error(t, cmd.Wait())
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.True(t, p.failedStart())
+
+	// killed as well without restarts, waitForApp handles the exit
+	cmd = exec.Command("sleep", "5")
+	assert.NoError(t, cmd.Start())
+	p.cmd = cmd
+	p.opts.restart = restartNever
+	p.probeReady()
+	assert.Error(t, cmd.Wait())
+
+	// without probe started once it keeps running
+	p = &program{opts: programOptions{startTimeout: 100 * time.Millisecond}}
+	p.watchStart(nil)
+	assert.False(t, p.failedStart())
+
+	done := make(chan struct{})
+	close(done)
+	p.started = false
+	p.watchStart(done)
+	assert.True(t, p.failedStart())
+
+}
@@ -8,11 +8,15 @@ package vorteil
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -29,11 +33,38 @@ const (
 	procEventExec     = 0x00000002
 
 	busboxScript = "/vorteil/busybox-install.sh"
+	busyboxApp   = "/vorteil/busybox"
 )
 
 var (
-	procs    map[uint32]uint32
-	internal map[uint32]string
+	// apps and internal processes reported by process events
+	procs = newProcessTable()
+
+	// exits are tracked with cmd.Wait if netlink is not available
+	waitFallback bool
+	fallbackLock sync.Mutex
+
+	procSocket = openProcSocket
+
+	// replaced in tests
+	countdownSleep = time.Sleep
+	scriptTimeout  = 5 * time.Minute
+
+	// delays between attempts to reconnect the process event socket
+	procSocketRetry    = 100 * time.Millisecond
+	procSocketMaxRetry = 5 * time.Second
+
+	// time all programs had been launched
+	launchedAt time.Time
+
+	// programs with stop commands, set once launching
+	stopPrograms func() []*program
+
+	// current shutdown phase and its start in seconds since boot, read by
+	// the shutdown watchdog
+	shutdownStep      string
+	shutdownStepStart float64
+	shutdownStepLock  sync.Mutex
 )
 
 // ProcEventHeader ...
@@ -60,7 +91,119 @@ type CbID struct {
 	Val uint32
 }
 
-func killAll() {
+// isInternal reports if the executable is one of vinitd's helpers
+func isInternal(exe string) bool {
+	return strings.HasPrefix(exe, "/vorteil/") && exe != busyboxApp
+}
+
+// signalSelected sends the signals to all processes selected
+func signalSelected(pl []ps.Process, selected func(p ps.Process) bool, sigs ...syscall.Signal) {
+
+	for _, p := range pl {
+
+		// never signal us (pid 1) and kthread (pid 2)
+		if p.Pid() <= 2 || !selected(p) {
+			continue
+		}
+
+		for _, s := range sigs {
+			syscall.Kill(p.Pid(), s)
+		}
+
+	}
+
+}
+
+// programTree returns the pids of the root processes and all descendants
+func programTree(pl []ps.Process, roots map[int]bool) map[int]bool {
+
+	tree := make(map[int]bool)
+	for pid := range roots {
+		tree[pid] = true
+	}
+
+	// add children until nothing changes, the list is not ordered
+	for changed := true; changed; {
+		changed = false
+		for _, p := range pl {
+			if !tree[p.Pid()] && tree[p.PPid()] {
+				tree[p.Pid()] = true
+				changed = true
+			}
+		}
+	}
+
+	return tree
+}
+
+// signalPrograms sends the signal to all running programs and processes
+// started by them. vinitd's internal processes are skipped.
+func signalPrograms(progs []*program, sig syscall.Signal) {
+
+	roots := make(map[int]bool)
+	for _, p := range progs {
+		if p.cmd != nil && p.cmd.Process != nil && !p.exited {
+			roots[p.cmd.Process.Pid] = true
+		}
+	}
+
+	if len(roots) == 0 {
+		return
+	}
+
+	pl, err := ps.Processes()
+	if err != nil {
+		logError("can not get processes: %s", err.Error())
+		return
+	}
+
+	tree := programTree(pl, roots)
+
+	signalSelected(pl, func(p ps.Process) bool {
+		if !tree[p.Pid()] {
+			return false
+		}
+		exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", p.Pid()))
+		return !isInternal(exe)
+	}, sig)
+
+}
+
+// forwardSignals passes the signals vinitd receives on to the programs
+func (v *Vinitd) forwardSignals(sigs []syscall.Signal) {
+
+	if len(sigs) == 0 {
+		return
+	}
+
+	c := make(chan os.Signal, 4)
+	for _, s := range sigs {
+		signal.Notify(c, s)
+	}
+
+	for s := range c {
+		logDebug("forwarding signal %s to programs", unix.SignalName(s.(syscall.Signal)))
+		signalPrograms(v.programList(), s.(syscall.Signal))
+	}
+
+}
+
+// zombie reports if the process has exited but has not been reaped yet
+func zombie(pid int) bool {
+
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+
+	// state follows the command name in brackets
+	i := bytes.LastIndexByte(stat, ')')
+	return i < 0 || len(stat) < i+3 || stat[i+2] == 'Z'
+}
+
+// killSelected sends SIGINT and SIGTERM to the selected processes and waits
+// up to grace for them to exit. Survivors are killed with SIGKILL.
+func killSelected(selected func(p ps.Process) bool, grace time.Duration) {
 
 	pl, err := ps.Processes()
 	if err != nil {
@@ -68,17 +211,110 @@ func killAll() {
 		return
 	}
 
-	// iterate through all processes and send signals
+	signaled := make(map[int]bool)
+
 	// most processes are ok with either SIGINT or SIGTERM
-	for x := range pl {
-		p := pl[x]
+	signalSelected(pl, func(p ps.Process) bool {
+		if selected(p) {
+			signaled[p.Pid()] = true
+			return true
+		}
+		return false
+	}, syscall.SIGINT, syscall.SIGTERM)
+
+	if len(signaled) == 0 {
+		return
+	}
+
+	survivor := func(p ps.Process) bool {
+		return signaled[p.Pid()] && !zombie(p.Pid())
+	}
+
+	deadline := time.Now().Add(grace)
+
+	for {
 
-		// don't kill us (pid 1) and kthread (pid 2)
-		if p.Pid() > 2 && p.PPid() > 2 {
-			syscall.Kill(p.Pid(), syscall.SIGINT)
-			syscall.Kill(p.Pid(), syscall.SIGTERM)
+		pl, err = ps.Processes()
+		if err != nil {
+			logError("can not get processes: %s", err.Error())
+			return
+		}
+
+		var left int
+		for _, p := range pl {
+			if survivor(p) {
+				left++
+			}
 		}
 
+		if left == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			logWarn("%d processes still running after %v, killing", left, grace)
+			signalSelected(pl, survivor, syscall.SIGKILL)
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+}
+
+// killAll signals all processes but vinitd's internal processes and kernel
+// threads and kills them if they have not exited after the grace period
+func killAll(grace time.Duration) {
+	killSelected(killable, grace)
+}
+
+// killable selects every process left on shutdown including orphans
+// reparented to vinitd. Kernel threads are kthreadd (pid 2) and its
+// children.
+func killable(p ps.Process) bool {
+
+	if p.Pid() <= 2 || p.PPid() == 2 {
+		return false
+	}
+
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", p.Pid()))
+	return !isInternal(exe)
+}
+
+// shutdownPhase prints the start of a shutdown phase and how long the
+// previous one took, so a stalled shutdown shows where it hangs
+func shutdownPhase(phase string) {
+
+	shutdownStepLock.Lock()
+	defer shutdownStepLock.Unlock()
+
+	now := uptime()
+	if shutdownStep != "" {
+		logAlways("shutdown: %s done (%.3fs)", shutdownStep, now-shutdownStepStart)
+	}
+
+	shutdownStep = phase
+	shutdownStepStart = now
+	logAlways("shutdown: %s", phase)
+
+}
+
+// currentShutdownStep returns the running shutdown phase
+func currentShutdownStep() string {
+	shutdownStepLock.Lock()
+	defer shutdownStepLock.Unlock()
+	return shutdownStep
+}
+
+// shutdownCountdown waits the seconds before the filesystems are synced,
+// quiet skips the messages
+func shutdownCountdown(seconds int, quiet bool) {
+
+	for i := seconds; i > 0; i-- {
+		if !quiet {
+			logAlways("shutting down in %d...", i)
+		}
+		countdownSleep(time.Second)
 	}
 
 }
@@ -95,54 +331,302 @@ func shutdown(cmd, timeout int) {
 
 	initStatus = statusPoweroff
 
-	logAlways("shutting down applications")
+	// the reboot happens even if a step hangs
+	if kernelOpts.shutdownTimeout > 0 {
+		time.AfterFunc(time.Duration(kernelOpts.shutdownTimeout)*time.Second, func() {
+			logError("shutdown did not finish in %ds, hanging in '%s', forcing", kernelOpts.shutdownTimeout, currentShutdownStep())
+			ioutil.WriteFile("/proc/sysrq-trigger", []byte("s"), 0644)
+			syscall.Reboot(cmd)
+		})
+	}
 
-	killAll()
+	if hooks := loadShutdownHooks(shutdownHookDir); len(hooks) > 0 {
+		shutdownPhase("running shutdown hooks")
+		runShutdownHooks(hooks)
+	}
 
-	time.Sleep(time.Duration(timeout) * time.Millisecond)
+	logAlways("shutting down applications")
 
-	for i := 3; i > 0; i-- {
-		logAlways(fmt.Sprintf("shutting down in %d...", i))
-		time.Sleep(1 * time.Second)
+	if stopPrograms != nil {
+		progs := stopPrograms()
+		shutdownPhase("running stop commands")
+		runStopCommands(progs)
+		shutdownPhase("stopping programs")
+		stopInOrder(progs)
 	}
 
+	grace := defaultKillGrace
+	if timeout > 0 {
+		grace = time.Duration(timeout) * time.Millisecond
+	}
+
+	shutdownPhase(fmt.Sprintf("signaling remaining processes, waiting up to %v", grace))
+	killAll(grace)
+
+	shutdownCountdown(kernelOpts.shutdownCountdown, kernelOpts.countdownQuiet)
+
+	// the kernel has to be loaded while all filesystems are available
+	cmd = rebootCommand(cmd, kernelOpts.kexec)
+
+	shutdownPhase("syncing filesystems")
+	closeDiskLog()
 	ioutil.WriteFile("/proc/sysrq-trigger", []byte("s"), 0644)
+
+	shutdownPhase("flushing filesystems")
+	flushMounts()
+
+	if activeSwap != "" {
+		shutdownPhase("disabling swap")
+		disableSwap()
+	}
+
+	if len(fstabMounts) > 0 {
+		shutdownPhase("unmounting filesystems")
+		unmountFstab()
+	}
+
+	shutdownPhase("remounting filesystems read-only")
 	ioutil.WriteFile("/proc/sysrq-trigger", []byte("u"), 0644)
 
-	// flush disk
-	p, err := bootDisk()
-	if err != nil {
-		logError(fmt.Sprintf("could not get disk name: %s", err.Error()))
-	} else {
-		flushDisk(p)
+	if len(kernelOpts.mdArrays) > 0 || len(kernelOpts.volumeGroups) > 0 {
+		shutdownPhase("stopping volumes and arrays")
+		teardownStorage(kernelOpts.mdArrays, kernelOpts.volumeGroups)
 	}
 
+	// a read-only root has nothing to flush
+	if rootWritable() {
+		shutdownPhase("flushing disk")
+		p, err := bootDisk()
+		if err != nil {
+			logError(fmt.Sprintf("could not get disk name: %s", err.Error()))
+		} else {
+			flushDisk(p)
+		}
+	}
+
+	shutdownPhase("rebooting")
 	syscall.Reboot(cmd)
 }
 
-func listenToProcesses(progs []*program) {
-
-	procs = make(map[uint32]uint32)
-	internal = make(map[uint32]string)
+// openProcSocket subscribes to process events of the kernel. This needs
+// CAP_NET_ADMIN.
+func openProcSocket() (int, error) {
 
 	sock, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM, unix.NETLINK_CONNECTOR)
-
 	if err != nil {
-		logError("socket for process listening failed: %s", err.Error())
-		return
+		return -1, fmt.Errorf("socket for process listening failed: %w", err)
 	}
 
 	addr := &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: cnIDXProc, Pid: uint32(os.Getpid())}
 	err = unix.Bind(sock, addr)
+	if err != nil {
+		unix.Close(sock)
+		return -1, fmt.Errorf("bind for process listening failed: %w", err)
+	}
 
+	err = send(sock, procCNMCASTListen)
 	if err != nil {
-		logError("bind for process listening failed: %s", err.Error())
+		unix.Close(sock)
+		return -1, fmt.Errorf("send for process listening failed: %w", err)
+	}
+
+	return sock, nil
+}
+
+// useWaitFallback tracks program exits by waiting for the programs if
+// process events are not available
+func useWaitFallback(v *Vinitd) {
+
+	fallbackLock.Lock()
+	waitFallback = true
+	fallbackLock.Unlock()
+
+	logWarn("tracking program exits by waiting for the programs")
+
+	// programs might have finished already
+	v.checkProgramsExited()
+
+}
+
+func waitFallbackActive() bool {
+	fallbackLock.Lock()
+	defer fallbackLock.Unlock()
+	return waitFallback
+}
+
+// programsDone reports if no program is running or about to be started or
+// restarted. If waited is set all programs have to be waited for, otherwise
+// only those which can be restarted.
+func programsDone(progs []*program, waited bool) bool {
+
+	for _, p := range progs {
+		if !p.finished(waited) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// finished reports if the program is neither running nor about to be started
+func (p *program) finished(waited bool) bool {
+
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+
+	if p.failed {
+		return true
+	}
+
+	// still starting, e.g. in bootstrap
+	if p.cmd == nil || p.cmd.Process == nil || p.restarting {
+		return false
+	}
+
+	return (!waited && p.opts.restart == restartNever) || p.exited
+}
+
+// checkProgramsExited powers off if all programs have been waited for and
+// none gets restarted. With process events registered apps have to be gone
+// as well.
+func (v *Vinitd) checkProgramsExited() {
+
+	if initStatus != statusLaunched {
 		return
 	}
 
-	err = send(sock, procCNMCASTListen)
+	fallback := waitFallbackActive()
+	if !fallback {
+		if procs.count() > 0 || inRegisterGrace(launchedAt, time.Now(), time.Duration(kernelOpts.registerGrace)*time.Second) {
+			return
+		}
+	}
+
+	if !programsDone(v.programList(), fallback) {
+		return
+	}
+
+	exitAction(kernelOpts.onLastExit, "no programs still running")
+
+}
+
+// exitAction runs the vinitd.on-last-exit action
+func exitAction(action, reason string) {
+
+	switch action {
+	case lastExitReboot:
+		Reboot(reason)
+	case lastExitHalt:
+		Halt(reason)
+	case lastExitKeepRunning:
+		logAlways("%s, keeping the system running", reason)
+	default:
+		Poweroff(reason)
+	}
+
+}
+
+// processTable tracks the pids of running apps and vinitd's internal
+// processes. It is safe for concurrent use.
+type processTable struct {
+	lock     sync.Mutex
+	apps     map[uint32]uint32
+	internal map[uint32]string
+}
+
+func newProcessTable() *processTable {
+	t := &processTable{}
+	t.reset()
+	return t
+}
+
+func (t *processTable) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.apps = make(map[uint32]uint32)
+	t.internal = make(map[uint32]string)
+}
+
+// add registers the process with the executable exe and returns the number
+// of apps
+func (t *processTable) add(pid uint32, exe string) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if isInternal(exe) {
+		t.internal[pid] = exe
+	} else {
+		t.apps[pid] = pid
+	}
+	return len(t.apps)
+}
+
+// remove drops the process. It returns if it had been an internal process or
+// an app and the number of apps left.
+func (t *processTable) remove(pid uint32) (internal, app bool, n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.internal[pid]; ok {
+		delete(t.internal, pid)
+		return true, false, len(t.apps)
+	}
+
+	_, app = t.apps[pid]
+	delete(t.apps, pid)
+	return false, app, len(t.apps)
+}
+
+// sync replaces the tracked processes with the running ones. It fixes the
+// table after process events have been lost.
+func (t *processTable) sync(running map[uint32]string) (added, removed int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for pid := range t.apps {
+		if _, ok := running[pid]; !ok {
+			delete(t.apps, pid)
+			removed++
+		}
+	}
+	for pid := range t.internal {
+		if _, ok := running[pid]; !ok {
+			delete(t.internal, pid)
+		}
+	}
+
+	for pid, exe := range running {
+		if isInternal(exe) {
+			t.internal[pid] = exe
+			continue
+		}
+		if _, ok := t.apps[pid]; !ok {
+			t.apps[pid] = pid
+			added++
+		}
+	}
+
+	return added, removed
+}
+
+// count returns the number of running apps
+func (t *processTable) count() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.apps)
+}
+
+func listenToProcesses(v *Vinitd) {
+
+	procs.reset()
+
+	sock, err := procSocket()
 	if err != nil {
-		logError("send for process listening failed: %s", err.Error())
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+			logError("%s: process events need CAP_NET_ADMIN, add the capability to the environment running vinitd", err.Error())
+		} else {
+			logError("%s", err.Error())
+		}
+		useWaitFallback(v)
 		return
 	}
 
@@ -152,35 +636,130 @@ func listenToProcesses(progs []*program) {
 		nlmessages, err := recv(p, sock)
 
 		if err != nil {
+			// events got dropped, the socket itself is still fine
+			if errors.Is(err, unix.ENOBUFS) {
+				logWarn("process events lost, resyncing processes")
+				resyncProcesses()
+				v.checkProgramsExited()
+				continue
+			}
+			if socketBroken(err) {
+				sock = reconnectProcSocket(sock, err)
+				continue
+			}
 			logWarn("error receiving netlink message: %s", err.Error())
 			continue
 		}
 
 		for _, m := range nlmessages {
-			parseNetlinkMessage(m, progs)
+			parseNetlinkMessage(m, v.programList())
+		}
+	}
+}
+
+// socketBroken reports if the process event socket can not be used anymore
+func socketBroken(err error) bool {
+	for _, e := range []error{unix.EBADF, unix.ENOTSOCK, unix.ENOTCONN,
+		unix.ECONNRESET, unix.EPIPE} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// reconnectProcSocket closes the failed socket and subscribes to process
+// events again. It retries until it succeeds.
+func reconnectProcSocket(sock int, cause error) int {
+
+	logWarn("process event socket failed, reconnecting: %s", cause.Error())
+	unix.Close(sock)
+
+	delay := procSocketRetry
+	for {
+		s, err := procSocket()
+		if err == nil {
+			logWarn("process event socket reconnected")
+			return s
+		}
+
+		logWarn("can not reconnect process event socket, retrying in %v: %s", delay, err.Error())
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > procSocketMaxRetry {
+			delay = procSocketMaxRetry
 		}
 	}
+
+}
+
+// runningProcesses returns the executables of all user space processes.
+// Kernel threads have no executable and are skipped.
+func runningProcesses() (map[uint32]string, error) {
+
+	pl, err := ps.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	running := make(map[uint32]string)
+	for _, p := range pl {
+		if p.Pid() <= 2 {
+			continue
+		}
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", p.Pid()))
+		if err != nil {
+			continue
+		}
+		running[uint32(p.Pid())] = exe
+	}
+
+	return running, nil
+}
+
+// resyncProcesses rebuilds the tracked processes from the process list,
+// e.g. if exit events have been dropped
+func resyncProcesses() {
+
+	running, err := runningProcesses()
+	if err != nil {
+		logError("can not resync processes: %s", err.Error())
+		return
+	}
+
+	added, removed := procs.sync(running)
+	logDebug("resynced processes, %d added, %d removed, procs %d", added, removed, procs.count())
+
+}
+
+// inRegisterGrace reports if exits of unregistered processes are still
+// ignored because the apps might not have been registered yet
+func inRegisterGrace(launched, now time.Time, grace time.Duration) bool {
+	return now.Sub(launched) < grace
 }
 
 func handleExit(hdr *ProcEventHeader, progs []*program) {
 	if hdr.ProcessTgid == hdr.ProcessPid {
 
+		internal, app, n := procs.remove(hdr.ProcessTgid)
+
 		// check if internal process
-		if len(internal[hdr.ProcessTgid]) > 0 {
-			delete(internal, hdr.ProcessTgid)
+		if internal {
 			return
 		}
 
-		// the apps have started but haven't done netlink
-		if len(procs) == 0 && initStatus >= statusLaunched {
+		// the apps have started but haven't done netlink. after the grace
+		// window exits are counted even if nothing has been registered
+		if !app && n == 0 && initStatus >= statusLaunched &&
+			inRegisterGrace(launchedAt, time.Now(), time.Duration(kernelOpts.registerGrace)*time.Second) {
 			logDebug("apps launched but not registered")
 			return
 		}
 
-		logDebug("remove app pid %d, procs %v", hdr.ProcessTgid, procs)
+		logDebug("remove app pid %d, procs %d", hdr.ProcessTgid, n)
 
-		delete(procs, hdr.ProcessTgid)
-		if len(procs) == 0 {
+		if n == 0 {
 
 			// if not all apps had been started we return
 			if initStatus < statusLaunched {
@@ -188,16 +767,14 @@ func handleExit(hdr *ProcEventHeader, progs []*program) {
 				return
 			}
 
-			// check if all apps have started. they might be in bootstrap
-			for _, p := range progs {
-				if p.cmd == nil || p.cmd.Process == nil {
-					logDebug("apps still starting")
-					return
-				}
+			// check if all apps have started. they might be in bootstrap or
+			// get restarted
+			if !programsDone(progs, false) {
+				logDebug("apps still starting")
+				return
 			}
 
-			logAlways("no programs still running")
-			shutdown(syscall.LINUX_REBOOT_CMD_POWER_OFF, 0)
+			exitAction(kernelOpts.onLastExit, "no programs still running")
 		}
 	}
 }
@@ -220,13 +797,8 @@ func parseNetlinkMessage(m syscall.NetlinkMessage, progs []*program) {
 					// app probably already finished
 					return
 				}
-				if !strings.HasPrefix(st, "/vorteil/") || st == "/vorteil/busybox" {
-					procs[hdr.ProcessTgid] = hdr.ProcessTgid
-				} else {
-					internal[hdr.ProcessTgid] = st
-				}
-
-				logDebug("add application %s, pid %d, procs %d", st, hdr.ProcessTgid, len(procs))
+				n := procs.add(hdr.ProcessTgid, st)
+				logDebug("add application %s, pid %d, procs %d", st, hdr.ProcessTgid, n)
 				break
 			}
 		case procEventExit:
@@ -264,14 +836,15 @@ func send(sock int, msg uint32) error {
 func recv(p []byte, sock int) ([]syscall.NetlinkMessage, error) {
 	nr, from, err := unix.Recvfrom(sock, p, 0)
 
-	if sockaddrNl, ok := from.(*unix.SockaddrNetlink); !ok || sockaddrNl.Pid != 0 {
-		return nil, fmt.Errorf("can not create netlink sockaddr")
-	}
-
+	// there is no sender on errors
 	if err != nil {
 		return nil, err
 	}
 
+	if sockaddrNl, ok := from.(*unix.SockaddrNetlink); !ok || sockaddrNl.Pid != 0 {
+		return nil, fmt.Errorf("can not create netlink sockaddr")
+	}
+
 	if nr < unix.NLMSG_HDRLEN {
 		return nil, fmt.Errorf("number of bytes too small, received %d bytes", nr)
 	}
@@ -285,19 +858,90 @@ func recv(p []byte, sock int) ([]syscall.NetlinkMessage, error) {
 	return nlmessages, nil
 }
 
+// runBusyboxScript runs the busybox install script, vinitd.busybox-script
+// replaces the default one
 func runBusyboxScript() error {
+	return runScript(kernelOpts.busyboxScript)
+}
+
+// shellCommand runs sh with the arguments, either a script or -c and a command
+func shellCommand(args ...string) (*exec.Cmd, error) {
+
+	if _, err := os.Stat(busyboxApp); err == nil {
+		return exec.Command(busyboxApp, append([]string{"sh"}, args...)...), nil
+	}
+
+	sh := findTool("sh")
+	if sh == "" {
+		return nil, fmt.Errorf("no shell available to run %s", strings.Join(args, " "))
+	}
+
+	return exec.Command(sh, args...), nil
+}
+
+// lineLogger logs everything written to it line by line
+type lineLogger struct {
+	log    func(format string, values ...interface{})
+	prefix string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+
+	l.buf = append(l.buf, p...)
+
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log("%s: %s", l.prefix, l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+
+	return len(p), nil
+}
 
-	if _, err := os.Stat(busboxScript); err == nil {
+// flush logs an unterminated last line
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		l.log("%s: %s", l.prefix, l.buf)
+		l.buf = nil
+	}
+}
+
+// runScript runs the script if it exists. Its output is logged, stdout on
+// debug and stderr on error level.
+func runScript(script string) error {
 
-		cmd := exec.Command(busboxScript)
+	fi, err := os.Stat(script)
+	if err != nil {
+		// nothing to do if there is no script
+		return nil
+	}
 
-		err = cmd.Start()
+	cmd := exec.Command(script)
+
+	if fi.Mode()&0111 == 0 {
+		logWarn("%s is not executable, running with shell", script)
+		cmd, err = shellCommand(script)
 		if err != nil {
+			logError("can not run %s: %s", script, err.Error())
 			return err
 		}
+	}
 
-		cmd.Wait()
+	name := filepath.Base(script)
+	stdout := &lineLogger{log: logDebug, prefix: name}
+	stderr := &lineLogger{log: logError, prefix: name}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
 
+	err = runWithTimeout(cmd, scriptTimeout)
+	stdout.flush()
+	stderr.flush()
+
+	if err != nil {
+		return fmt.Errorf("%s failed: %s", script, err.Error())
 	}
 
 	return nil
@@ -0,0 +1,627 @@
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	ps "github.com/mitchellh/go-ps"
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+	"golang.org/x/sys/unix"
+)
+
+func TestRunScriptNotExecutable(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "script")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "install.sh")
+	out := filepath.Join(dir, "out")
+
+	err = ioutil.WriteFile(script, []byte("echo -n ran > "+out+"\n"), 0644)
+	assert.NoError(t, err)
+
+	err = runScript(script)
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "ran", string(b))
+
+	// missing scripts are not an error
+	err = runScript(filepath.Join(dir, "missing.sh"))
+	assert.NoError(t, err)
+
+}
+
+func TestRunScriptOutput(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "script")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		lock sync.Mutex
+		logs []string
+	)
+
+	New(func(level LogLevel, format string, values ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		logs = append(logs, fmt.Sprintf("%d %s", level, fmt.Sprintf(format, values...)))
+	})
+	defer New(testLogFn)
+
+	script := filepath.Join(dir, "install.sh")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\necho installing\necho -n broken >&2\nexit 3\n"), 0755)
+	assert.NoError(t, err)
+
+	err = runScript(script)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 3")
+	assert.Contains(t, logs, fmt.Sprintf("%d install.sh: installing", LogLvDEBUG))
+	assert.Contains(t, logs, fmt.Sprintf("%d install.sh: broken", LogLvSTDERR))
+
+	old := scriptTimeout
+	defer func() {
+		scriptTimeout = old
+	}()
+	scriptTimeout = 100 * time.Millisecond
+
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\nsleep 10\n"), 0755)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	err = runScript(script)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+}
+
+func TestRunBusyboxScript(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "busybox")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o, err := parseKernelOptions("vinitd.busybox-script=" + dir + "/install.sh")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "install.sh"), o.busyboxScript)
+
+	_, err = parseKernelOptions("vinitd.busybox-script=install.sh")
+	assert.Error(t, err)
+
+	old := kernelOpts
+	defer func() {
+		kernelOpts = old
+	}()
+	kernelOpts = o
+
+	out := filepath.Join(dir, "out")
+	err = ioutil.WriteFile(o.busyboxScript, []byte("#!/bin/sh\necho -n custom > "+out+"\n"), 0755)
+	assert.NoError(t, err)
+
+	err = runBusyboxScript()
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "custom", string(b))
+
+}
+
+func TestLastProgramExited(t *testing.T) {
+
+	New(testLogFn)
+
+	sf, st, la := shutdownFn, initStatus, launchedAt
+	defer func() {
+		shutdownFn, initStatus, launchedAt = sf, st, la
+		kernelOpts = defaultKernelOptions()
+		procs.reset()
+	}()
+
+	var cmds []int
+	shutdownFn = func(cmd, timeout int) {
+		cmds = append(cmds, cmd)
+	}
+
+	exited := &program{
+		cmd:    &exec.Cmd{Process: &os.Process{Pid: 4242}},
+		exited: true,
+	}
+	v := &Vinitd{programs: []*program{exited}}
+
+	launchedAt = time.Now().Add(-time.Hour)
+
+	for _, c := range []struct {
+		action string
+		cmds   []int
+	}{
+		{lastExitPoweroff, []int{syscall.LINUX_REBOOT_CMD_POWER_OFF}},
+		{lastExitReboot, []int{syscall.LINUX_REBOOT_CMD_RESTART}},
+		{lastExitHalt, []int{syscall.LINUX_REBOOT_CMD_HALT}},
+		{lastExitKeepRunning, nil},
+	} {
+
+		o, err := parseKernelOptions("vinitd.on-last-exit=" + c.action)
+		assert.NoError(t, err)
+		kernelOpts = o
+
+		// last registered app exits
+		cmds = nil
+		initStatus = statusLaunched
+		procs.add(4242, "/bin/app")
+		handleExit(&ProcEventHeader{ProcessPid: 4242, ProcessTgid: 4242}, v.programList())
+		assert.Equal(t, c.cmds, cmds, c.action)
+
+		// last program waited for
+		cmds = nil
+		initStatus = statusLaunched
+		v.checkProgramsExited()
+		assert.Equal(t, c.cmds, cmds, c.action)
+	}
+
+	_, err := parseKernelOptions("vinitd.on-last-exit=sleep")
+	assert.Error(t, err)
+
+}
+
+func TestListenToProcessesFallback(t *testing.T) {
+
+	v := New(testLogFn)
+
+	procSocket = func() (int, error) {
+		return -1, fmt.Errorf("bind for process listening failed: %w", unix.EPERM)
+	}
+	defer func() {
+		procSocket = openProcSocket
+		waitFallback = false
+	}()
+
+	// returns instead of listening on a dead socket
+	listenToProcesses(v)
+	assert.True(t, waitFallbackActive())
+
+}
+
+func TestInRegisterGrace(t *testing.T) {
+
+	launched := time.Now()
+	grace := 10 * time.Second
+
+	assert.True(t, inRegisterGrace(launched, launched, grace))
+	assert.True(t, inRegisterGrace(launched, launched.Add(grace-time.Millisecond), grace))
+	assert.False(t, inRegisterGrace(launched, launched.Add(grace), grace))
+	assert.False(t, inRegisterGrace(launched, launched.Add(time.Minute), grace))
+
+	// no window
+	assert.False(t, inRegisterGrace(launched, launched, 0))
+
+}
+
+func TestRunStopCommand(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "stop")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+
+	p := &program{
+		path:     "app",
+		vcfgProg: vcfg.Program{Cwd: dir},
+		opts: programOptions{
+			execStop:    "echo -n drained > out",
+			stopTimeout: time.Second,
+		},
+	}
+
+	err = p.runStopCommand()
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "drained", string(b))
+
+	p.opts.execStop = "exit 3"
+	assert.Error(t, p.runStopCommand())
+
+	p.opts.execStop = "sleep 5"
+	p.opts.stopTimeout = 100 * time.Millisecond
+	err = p.runStopCommand()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+
+}
+
+func TestShimReaping(t *testing.T) {
+
+	New(testLogFn)
+
+	// orphans are reaped by the subreaper, i.e. the shim
+	assert.NoError(t, unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0))
+	defer unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 0, 0, 0, 0)
+
+	cmd := exec.Command("sh", "-c", "(sleep 0.1 &); sleep 0.3; exit 5")
+	assert.NoError(t, cmd.Start())
+
+	code, reaped := shimWait(cmd.Process.Pid)
+	assert.Equal(t, 5, code)
+
+	// orphaned sleep and the program itself
+	assert.Equal(t, 2, reaped)
+
+}
+
+func TestSignalPrograms(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "signal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+
+	cmd := exec.Command("sh", "-c", "trap 'echo -n usr1 > "+out+"; exit 0' USR1; while true; do sleep 0.05; done")
+	assert.NoError(t, cmd.Start())
+
+	p := &program{cmd: cmd}
+
+	// give the shell time to install the trap
+	time.Sleep(100 * time.Millisecond)
+	signalPrograms([]*program{p}, syscall.SIGUSR1)
+
+	assert.NoError(t, cmd.Wait())
+
+	b, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "usr1", string(b))
+
+	sigs, err := parseSignals("usr1,SIGUSR2")
+	assert.NoError(t, err)
+	assert.Equal(t, []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2}, sigs)
+
+	// reloads the programs
+	_, err = parseSignals("HUP")
+	assert.Error(t, err)
+
+	_, err = parseSignals("TERM")
+	assert.Error(t, err)
+
+	_, err = parseSignals("NOPE")
+	assert.Error(t, err)
+
+}
+
+func TestStopSignal(t *testing.T) {
+
+	New(testLogFn)
+
+	// exits cleanly on SIGQUIT only
+	p := &program{
+		path: "app",
+		cmd:  exec.Command("sh", "-c", "trap 'exit 0' QUIT; trap '' TERM; while true; do sleep 0.01; done"),
+		opts: programOptions{
+			stopSignal:  syscall.SIGQUIT,
+			stopTimeout: 5 * time.Second,
+		},
+		done: make(chan struct{}),
+	}
+	assert.NoError(t, p.cmd.Start())
+	go func() {
+		p.cmd.Wait()
+		close(p.done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	p.stop()
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.True(t, p.cmd.ProcessState.Success())
+
+}
+
+func TestStopInOrder(t *testing.T) {
+
+	New(testLogFn)
+
+	var (
+		order []string
+		lock  sync.Mutex
+	)
+
+	start := func(name, script string, phase launchPhase) *program {
+
+		cmd := exec.Command("sh", "-c", script)
+		assert.NoError(t, cmd.Start())
+
+		p := &program{
+			path: name,
+			cmd:  cmd,
+			done: make(chan struct{}),
+			opts: programOptions{
+				phase:       phase,
+				stopTimeout: 200 * time.Millisecond,
+			},
+		}
+
+		go func() {
+			cmd.Wait()
+			lock.Lock()
+			order = append(order, name)
+			lock.Unlock()
+			p.exited = true
+			close(p.done)
+		}()
+
+		return p
+	}
+
+	loop := "while true; do sleep 0.05; done"
+
+	progs := []*program{
+		start("db", loop, phasePostNetwork),
+		start("worker", loop, phasePostNetwork),
+		start("stubborn", "trap '' TERM; "+loop, phaseFinal),
+		start("early", loop, phasePreNetwork),
+	}
+
+	// give the shells time to install the traps
+	time.Sleep(100 * time.Millisecond)
+	stopInOrder(progs)
+
+	assert.Equal(t, []string{"stubborn", "worker", "db", "early"}, order)
+
+	// ignored SIGTERM, killed after the timeout
+	ws := progs[2].cmd.ProcessState.Sys().(syscall.WaitStatus)
+	assert.True(t, ws.Signaled())
+	assert.Equal(t, syscall.SIGKILL, ws.Signal())
+
+	ws = progs[0].cmd.ProcessState.Sys().(syscall.WaitStatus)
+	assert.Equal(t, syscall.SIGTERM, ws.Signal())
+
+}
+
+func TestKillSelected(t *testing.T) {
+
+	New(testLogFn)
+
+	cmd := exec.Command("sh", "-c", "trap '' INT TERM; while true; do sleep 0.05; done")
+	assert.NoError(t, cmd.Start())
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+
+	// give the shell time to install the trap
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	killSelected(func(p ps.Process) bool {
+		return p.Pid() == cmd.Process.Pid
+	}, 300*time.Millisecond)
+	<-done
+
+	assert.True(t, time.Since(start) >= 300*time.Millisecond)
+
+	ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	assert.True(t, ws.Signaled())
+	assert.Equal(t, syscall.SIGKILL, ws.Signal())
+
+	// processes exiting in time are not waited for the full grace period
+	cmd = exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	go cmd.Wait()
+
+	start = time.Now()
+	killSelected(func(p ps.Process) bool {
+		return p.Pid() == cmd.Process.Pid
+	}, 5*time.Second)
+
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+}
+
+type testProcess struct {
+	pid, ppid int
+}
+
+func (p testProcess) Pid() int           { return p.pid }
+func (p testProcess) PPid() int          { return p.ppid }
+func (p testProcess) Executable() string { return "" }
+
+func TestKillable(t *testing.T) {
+
+	// orphans reparented to vinitd are killed as well
+	self, err := ps.FindProcess(os.Getpid())
+	assert.NoError(t, err)
+	assert.True(t, killable(self))
+	assert.True(t, killable(testProcess{pid: os.Getpid(), ppid: 1}))
+
+	// vinitd and kernel threads
+	assert.False(t, killable(testProcess{pid: 1}))
+	assert.False(t, killable(testProcess{pid: 2}))
+	assert.False(t, killable(testProcess{pid: 100, ppid: 2}))
+
+}
+
+func TestReconnectProcSocket(t *testing.T) {
+
+	New(testLogFn)
+
+	assert.True(t, socketBroken(fmt.Errorf("recv: %w", unix.EPIPE)))
+	assert.False(t, socketBroken(unix.ENOBUFS))
+	assert.True(t, socketBroken(unix.EBADF))
+	assert.False(t, socketBroken(unix.EINTR))
+	assert.False(t, socketBroken(fmt.Errorf("number of bytes too small")))
+
+	// a closed socket fails like a broken one
+	_, err := recv(make([]byte, 1024), -1)
+	assert.True(t, socketBroken(err))
+
+	var attempts int
+	procSocket = func() (int, error) {
+		attempts++
+		if attempts < 3 {
+			return -1, unix.ENOMEM
+		}
+		return 42, nil
+	}
+	procSocketRetry = time.Millisecond
+	defer func() {
+		procSocket = openProcSocket
+		procSocketRetry = 100 * time.Millisecond
+	}()
+
+	assert.Equal(t, 42, reconnectProcSocket(-1, unix.EBADF))
+	assert.Equal(t, 3, attempts)
+
+}
+
+func TestProcessTable(t *testing.T) {
+
+	pt := newProcessTable()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+		go func(pid uint32) {
+			defer wg.Done()
+			pt.add(pid, "/usr/bin/app")
+			pt.add(pid+1000, "/vorteil/vinitd")
+			pt.count()
+		}(uint32(i))
+	}
+	wg.Wait()
+	assert.Equal(t, 50, pt.count())
+
+	internal, app, n := pt.remove(1001)
+	assert.True(t, internal)
+	assert.False(t, app)
+	assert.Equal(t, 50, n)
+
+	internal, app, n = pt.remove(1)
+	assert.False(t, internal)
+	assert.True(t, app)
+	assert.Equal(t, 49, n)
+
+	_, app, _ = pt.remove(1)
+	assert.False(t, app)
+
+	pt.reset()
+	assert.Equal(t, 0, pt.count())
+
+}
+
+func TestProcessTableSync(t *testing.T) {
+
+	pt := newProcessTable()
+	pt.add(100, "/usr/bin/app")
+	pt.add(101, "/usr/bin/app")
+	pt.add(102, "/vorteil/vinitd")
+
+	// exits of 101 and 102 and the start of 103 got lost
+	added, removed := pt.sync(map[uint32]string{
+		100: "/usr/bin/app",
+		103: "/usr/bin/worker",
+	})
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, 2, pt.count())
+
+	internal, _, _ := pt.remove(102)
+	assert.False(t, internal)
+
+	// all apps gone
+	pt.sync(map[uint32]string{})
+	assert.Equal(t, 0, pt.count())
+
+	running, err := runningProcesses()
+	assert.NoError(t, err)
+	assert.Contains(t, running, uint32(os.Getpid()))
+
+}
+
+func TestShutdownCountdown(t *testing.T) {
+
+	var logged []string
+	New(func(level LogLevel, format string, values ...interface{}) {
+		if level == LogLvSTDERR {
+			logged = append(logged, fmt.Sprintf(format, values...))
+		}
+	})
+
+	cs := countdownSleep
+	defer func() {
+		countdownSleep = cs
+	}()
+
+	var slept time.Duration
+	countdownSleep = func(d time.Duration) {
+		slept += d
+	}
+
+	shutdownCountdown(0, false)
+	assert.Zero(t, slept)
+	assert.Empty(t, logged)
+
+	shutdownCountdown(5, false)
+	assert.Equal(t, 5*time.Second, slept)
+	assert.Len(t, logged, 5)
+	assert.Contains(t, logged[0], "shutting down in 5...")
+
+	logged, slept = nil, 0
+	shutdownCountdown(2, true)
+	assert.Equal(t, 2*time.Second, slept)
+	assert.Empty(t, logged)
+
+}
+
+func TestShutdownPhase(t *testing.T) {
+
+	New(testLogFn)
+
+	defer func() {
+		shutdownStep, shutdownStepStart = "", 0
+	}()
+
+	// the watchdog reads the phase while shutdown moves on
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			shutdownPhase(fmt.Sprintf("phase %d", i))
+		}
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			assert.Equal(t, "phase 99", currentShutdownStep())
+			return
+		default:
+			currentShutdownStep()
+		}
+	}
+
+}
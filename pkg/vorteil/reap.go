@@ -0,0 +1,168 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	// reaping takes the write lock. starting a command and registering it
+	// holds the read lock so its exit status does not get reaped in between.
+	reapLock sync.RWMutex
+
+	// programs get their exit status from the reaper
+	reapWaiters     = make(map[int]chan syscall.WaitStatus)
+	reapWaitersLock sync.Mutex
+)
+
+// startReaped starts the command and returns a channel which receives its
+// exit status once it has been reaped
+func startReaped(cmd *exec.Cmd, start func() error) (chan syscall.WaitStatus, error) {
+
+	// no reaping between start and registration
+	reapLock.RLock()
+	defer reapLock.RUnlock()
+
+	err := start()
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan syscall.WaitStatus, 1)
+
+	reapWaitersLock.Lock()
+	reapWaiters[cmd.Process.Pid] = c
+	reapWaitersLock.Unlock()
+
+	return c, nil
+}
+
+// exitStatusError is returned for commands which failed but were reaped
+// before cmd.Wait got their exit status
+type exitStatusError struct {
+	ws syscall.WaitStatus
+}
+
+func (e *exitStatusError) Error() string {
+	if e.ws.Signaled() {
+		return fmt.Sprintf("signal: %s", e.ws.Signal())
+	}
+	return fmt.Sprintf("exit status %d", e.ws.ExitStatus())
+}
+
+// ExitCode matches exec.ExitError, -1 if the command got killed
+func (e *exitStatusError) ExitCode() int {
+	return e.ws.ExitStatus()
+}
+
+// waitReaped waits for a command started with startReaped. cmd.Wait and the
+// reaper race for the exit status, if the reaper wins it is passed on the
+// channel. cmd.Wait waits for the output to be copied in both cases.
+func waitReaped(cmd *exec.Cmd, exit chan syscall.WaitStatus) error {
+
+	err := cmd.Wait()
+	if errors.Is(err, syscall.ECHILD) {
+		ws := <-exit
+		if ws.Exited() && ws.ExitStatus() == 0 {
+			return nil
+		}
+		return &exitStatusError{ws: ws}
+	}
+
+	// the reaper must not pass on the status of a reused pid
+	reapWaitersLock.Lock()
+	if reapWaiters[cmd.Process.Pid] == exit {
+		delete(reapWaiters, cmd.Process.Pid)
+	}
+	reapWaitersLock.Unlock()
+
+	return err
+}
+
+// runReaped runs a short lived command without racing with the reaper
+func runReaped(cmd *exec.Cmd) error {
+
+	exit, err := startReaped(cmd, cmd.Start)
+	if err != nil {
+		return err
+	}
+
+	return waitReaped(cmd, exit)
+}
+
+// outputReaped is runReaped returning stdout and stderr of the command
+func outputReaped(cmd *exec.Cmd) ([]byte, error) {
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := runReaped(cmd)
+
+	return out.Bytes(), err
+}
+
+// reapChildren reaps all exited children without blocking. Exit states of
+// programs are passed on, everything else is an orphan. It returns the number
+// of reaped processes.
+func reapChildren() int {
+
+	reapLock.Lock()
+	defer reapLock.Unlock()
+
+	n := 0
+
+	for {
+		var ws syscall.WaitStatus
+
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return n
+		}
+
+		n++
+
+		reapWaitersLock.Lock()
+		c, ok := reapWaiters[pid]
+		delete(reapWaiters, pid)
+		reapWaitersLock.Unlock()
+
+		if ok {
+			c <- ws
+			continue
+		}
+
+		logDebug("reaped process %d", pid)
+	}
+
+}
+
+// reapProcs reaps children on SIGCHLD. As pid 1 vinitd inherits all
+// orphaned processes.
+func reapProcs() {
+
+	sigs := make(chan os.Signal, 16)
+	signal.Notify(sigs, syscall.SIGCHLD)
+
+	// children might have exited before
+	reapChildren()
+
+	for range sigs {
+		reapChildren()
+	}
+
+}
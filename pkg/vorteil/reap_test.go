@@ -0,0 +1,90 @@
+package vorteil
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestReapOrphans(t *testing.T) {
+
+	New(testLogFn)
+
+	// orphans of the test get reparented to the test process like to pid 1
+	assert.NoError(t, unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0))
+	defer unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 0, 0, 0, 0)
+
+	cmd := exec.Command("sh", "-c", "sleep 0.2 & exit 4")
+	exit, err := startReaped(cmd, cmd.Start)
+	assert.NoError(t, err)
+
+	reaped := 0
+	for i := 0; i < 100 && reaped < 2; i++ {
+		reaped += reapChildren()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the program and the orphaned sleep
+	assert.Equal(t, 2, reaped)
+	assert.Equal(t, 4, exitCode(<-exit))
+
+	// no zombies left
+	var ws syscall.WaitStatus
+	_, err = syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+	assert.Equal(t, syscall.ECHILD, err)
+
+}
+
+func TestRunReapedWhileReaping(t *testing.T) {
+
+	New(testLogFn)
+
+	stop := make(chan bool)
+	defer close(stop)
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				reapChildren()
+			}
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runWithTimeout(exec.Command("sh", "-c", "sleep 0.3; exit 3"), 5*time.Second)
+	}()
+
+	// the reaper is not blocked while the command runs
+	time.Sleep(50 * time.Millisecond)
+	reaped := make(chan bool)
+	go func() {
+		reapChildren()
+		close(reaped)
+	}()
+	select {
+	case <-reaped:
+	case <-time.After(100 * time.Millisecond):
+		assert.Fail(t, "reaper blocked by running command")
+	}
+
+	var ee interface{ ExitCode() int }
+	err := <-done
+	assert.True(t, errors.As(err, &ee))
+	assert.Equal(t, 3, ee.ExitCode())
+
+	for i := 0; i < 20; i++ {
+		out, err := outputReaped(exec.Command("sh", "-c", "echo out; echo err >&2"))
+		assert.NoError(t, err)
+		assert.Equal(t, "out\nerr\n", string(out))
+	}
+
+}
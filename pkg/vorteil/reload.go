@@ -0,0 +1,170 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+// programKey identifies a program definition. A changed definition is a
+// different program.
+func programKey(p vcfg.Program) string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+// reconcilePrograms compares the configured programs with the running ones.
+// It returns the definitions to start and the programs to stop.
+func reconcilePrograms(desired []vcfg.Program, running []*program) ([]vcfg.Program, []*program) {
+
+	var (
+		add    []vcfg.Program
+		remove []*program
+	)
+
+	// the same definition can be configured more than once
+	current := make(map[string][]*program)
+	for _, p := range running {
+		current[p.key] = append(current[p.key], p)
+	}
+
+	for _, d := range desired {
+		k := programKey(d)
+		if len(current[k]) > 0 {
+			current[k] = current[k][1:]
+			continue
+		}
+		add = append(add, d)
+	}
+
+	// whatever has not been matched is not in the configuration anymore
+	for _, p := range running {
+		for _, r := range current[p.key] {
+			if r == p {
+				remove = append(remove, p)
+			}
+		}
+	}
+
+	return add, remove
+}
+
+// isRemoved reports if a reload took the program out of the configuration
+func (v *Vinitd) isRemoved(p *program) bool {
+	v.programsLock.Lock()
+	defer v.programsLock.Unlock()
+	return p.removed
+}
+
+// stopProgram removes the program and stops it like on shutdown, with its
+// stop command first and killed after the stop timeout
+func (v *Vinitd) stopProgram(p *program) {
+
+	v.programsLock.Lock()
+	for i, r := range v.programs {
+		if r == p {
+			v.programs = append(v.programs[:i], v.programs[i+1:]...)
+			break
+		}
+	}
+	p.removed = true
+	v.programsLock.Unlock()
+
+	if p.cmd == nil || p.cmd.Process == nil {
+		return
+	}
+
+	logAlways("stopping %s (pid %d)", p.path, p.cmd.Process.Pid)
+	runStopCommands([]*program{p})
+	p.stop()
+
+}
+
+// reload reads the configuration from disk again and starts new programs
+// and stops removed ones. Other changes require a reboot.
+func (v *Vinitd) reload() error {
+
+	c, err := loadVCFG(v.diskname)
+	if err != nil {
+		return err
+	}
+
+	for _, np := range v.replacePrograms(c.Programs) {
+		go func(np *program) {
+			err := v.startProgram(np)
+			if err != nil {
+				logError("can not start %s: %s", np.vcfgProg.Binary, err.Error())
+				v.checkProgramsExited()
+			}
+		}(np)
+	}
+
+	return nil
+}
+
+// replacePrograms stops the removed programs and returns the added ones
+// to start. The added programs are in the list before the removed ones
+// exit, so replacing all programs does not look like the last one exited.
+func (v *Vinitd) replacePrograms(desired []vcfg.Program) []*program {
+
+	add, remove := reconcilePrograms(desired, v.programList())
+	logDebug("reload: %d programs to start, %d to stop", len(add), len(remove))
+
+	var added []*program
+	for _, p := range add {
+		np, err := v.prepProgram(p)
+		if err != nil {
+			logError("can not add program %s: %s", p.Binary, err.Error())
+			continue
+		}
+		added = append(added, np)
+	}
+
+	// changed programs are stopped before their new definition starts
+	var wg sync.WaitGroup
+	for _, p := range remove {
+		wg.Add(1)
+		go func(p *program) {
+			defer wg.Done()
+			v.stopProgram(p)
+		}(p)
+	}
+	wg.Wait()
+
+	v.programsLock.Lock()
+	v.vcfg.Programs = desired
+	v.programsLock.Unlock()
+
+	return added
+}
+
+// waitForReload reloads the programs on SIGHUP
+func (v *Vinitd) waitForReload() {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+
+		if initStatus != statusLaunched {
+			continue
+		}
+
+		logAlways("reloading programs")
+		err := v.reload()
+		if err != nil {
+			logError("can not reload configuration: %s", err.Error())
+		}
+
+	}
+
+}
@@ -0,0 +1,141 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func TestReconcilePrograms(t *testing.T) {
+
+	a := vcfg.Program{Binary: "/a"}
+	b := vcfg.Program{Binary: "/b"}
+	c := vcfg.Program{Binary: "/c"}
+
+	running := []*program{
+		{key: programKey(a)},
+		{key: programKey(b)},
+	}
+
+	// unchanged
+	add, remove := reconcilePrograms([]vcfg.Program{a, b}, running)
+	assert.Empty(t, add)
+	assert.Empty(t, remove)
+
+	// add c, remove b
+	add, remove = reconcilePrograms([]vcfg.Program{a, c}, running)
+	assert.Equal(t, []vcfg.Program{c}, add)
+	assert.Equal(t, []*program{running[1]}, remove)
+
+	// changed definitions are replaced
+	b2 := vcfg.Program{Binary: "/b", Args: "-v"}
+	add, remove = reconcilePrograms([]vcfg.Program{a, b2}, running)
+	assert.Equal(t, []vcfg.Program{b2}, add)
+	assert.Equal(t, []*program{running[1]}, remove)
+
+	// duplicates are counted
+	add, remove = reconcilePrograms([]vcfg.Program{a, a, b}, running)
+	assert.Equal(t, []vcfg.Program{a}, add)
+	assert.Empty(t, remove)
+
+}
+
+func TestStopRemovedProgram(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "reload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	v := &Vinitd{}
+	p, err := v.prepProgram(vcfg.Program{Binary: "/bin/app"})
+	assert.NoError(t, err)
+
+	stopped := filepath.Join(dir, "stopped")
+	p.opts.execStop = "touch " + stopped
+	p.opts.stopTimeout = 100 * time.Millisecond
+	p.vcfgProg.Cwd = dir
+
+	// ignores SIGTERM and has to be killed
+	p.cmd = exec.Command("sh", "-c", "trap '' TERM; sleep 10")
+	assert.NoError(t, p.cmd.Start())
+	p.done = make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(p.done)
+	}()
+
+	// the shell needs to install the trap
+	time.Sleep(50 * time.Millisecond)
+
+	v.stopProgram(p)
+
+	assert.True(t, p.removed)
+	assert.Empty(t, v.programList())
+	assert.FileExists(t, stopped)
+	assert.Equal(t, "signal: killed", p.cmd.ProcessState.String())
+
+}
+
+func TestReplaceAllPrograms(t *testing.T) {
+
+	New(testLogFn)
+
+	sf, st, la := shutdownFn, initStatus, launchedAt
+	defer func() {
+		shutdownFn, initStatus, launchedAt = sf, st, la
+		procs.reset()
+	}()
+
+	var cmds []int
+	shutdownFn = func(cmd, timeout int) {
+		cmds = append(cmds, cmd)
+	}
+
+	initStatus = statusLaunched
+	launchedAt = time.Now().Add(-time.Hour)
+
+	var wg sync.WaitGroup
+
+	v := &Vinitd{}
+	for _, b := range []string{"/bin/a", "/bin/b"} {
+		p, err := v.prepProgram(vcfg.Program{Binary: b})
+		assert.NoError(t, err)
+
+		p.opts.stopTimeout = time.Second
+		p.cmd = exec.Command("sleep", "10")
+		assert.NoError(t, p.cmd.Start())
+		p.done = make(chan struct{})
+
+		exit := make(chan syscall.WaitStatus, 1)
+		go func(p *program) {
+			p.cmd.Wait()
+			exit <- p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+		}(p)
+		wg.Add(1)
+		go func(p *program) {
+			waitForApp(p, exit)
+			wg.Done()
+		}(p)
+	}
+
+	// the exits of the old programs do not shut the system down
+	desired := []vcfg.Program{{Binary: "/bin/c"}, {Binary: "/bin/d"}}
+	added := v.replacePrograms(desired)
+	wg.Wait()
+
+	assert.Len(t, added, 2)
+	assert.Equal(t, added, v.programList())
+	assert.Equal(t, desired, v.vcfg.Programs)
+	assert.Empty(t, cmds)
+
+}
@@ -0,0 +1,105 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+var (
+	// replaced in tests
+	rescueShell = runRescueShell
+
+	// only the first failure gets a shell, others wait for it
+	rescueOnce sync.Once
+	rescueRan  bool
+)
+
+// readOnlyMounts remounts the filesystems of the boot and data devices
+// read-only, so they can be checked and repaired in the rescue shell
+func readOnlyMounts() {
+
+	syscall.Sync()
+
+	f, err := os.Open(procMounts)
+	if err != nil {
+		logWarn("can not read mounts: %s", err.Error())
+		return
+	}
+	targets := writableMounts(f)
+	f.Close()
+
+	for _, t := range targets {
+		err := mountFn("", t, "", syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+		if err != nil {
+			logWarn("can not remount %s read-only: %s", t, err.Error())
+			continue
+		}
+		logDebug("remounted %s read-only", t)
+	}
+
+}
+
+// runRescueShell runs an interactive busybox shell on the console until it
+// exits. Only errors starting the shell are returned.
+func runRescueShell() error {
+
+	readOnlyMounts()
+
+	tty, err := os.OpenFile(defaultTTY, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+
+	cmd := exec.Command(busyboxApp, "sh")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.Env = []string{
+		"PATH=/vorteil:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"HOME=/",
+		"PS1=rescue# ",
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:  true,
+		Setctty: true,
+	}
+
+	exit, err := startReaped(cmd, cmd.Start)
+	if err != nil {
+		return err
+	}
+
+	err = waitReaped(cmd, exit)
+	if err != nil {
+		logDebug("rescue shell exited: %s", err.Error())
+	}
+
+	return nil
+}
+
+// rescue starts the rescue shell after a failed boot if enabled. It returns
+// true if the shell ran and the system should reboot.
+func rescue(enabled bool) bool {
+
+	if !enabled {
+		return false
+	}
+
+	rescueOnce.Do(func() {
+		logAlways("starting rescue shell with read-only filesystems, vfsck checks the boot disk, the system reboots when the shell exits")
+		err := rescueShell()
+		if err != nil {
+			logError("rescue shell failed: %s", err.Error())
+			return
+		}
+		rescueRan = true
+	})
+
+	return rescueRan
+}
@@ -0,0 +1,96 @@
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRescue(t *testing.T) {
+
+	New(testLogFn)
+
+	old := rescueShell
+	defer func() {
+		rescueShell = old
+		rescueOnce = sync.Once{}
+		rescueRan = false
+	}()
+
+	var shellErr error
+	calls := 0
+	rescueShell = func() error {
+		calls++
+		return shellErr
+	}
+
+	// disabled, no shell
+	assert.False(t, rescue(false))
+	assert.Equal(t, 0, calls)
+
+	// enabled, reboot after the shell exits
+	assert.True(t, rescue(true))
+	assert.Equal(t, 1, calls)
+
+	// only one shell per boot
+	assert.True(t, rescue(true))
+	assert.Equal(t, 1, calls)
+
+	// shell can not start
+	rescueOnce = sync.Once{}
+	rescueRan = false
+	shellErr = fmt.Errorf("no busybox")
+	assert.False(t, rescue(true))
+	assert.Equal(t, 2, calls)
+
+}
+
+func TestReadOnlyMounts(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "rescue")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pm, mf := procMounts, mountFn
+	defer func() {
+		procMounts, mountFn = pm, mf
+	}()
+
+	procMounts = filepath.Join(dir, "mounts")
+	assert.NoError(t, ioutil.WriteFile(procMounts, []byte(`/dev/vda2 / ext4 rw,noatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/vdb /data ext4 rw,relatime 0 0
+/dev/vdc /backup xfs ro,relatime 0 0
+tmpfs /tmp tmpfs rw,size=1024k 0 0
+`), 0644))
+
+	var remounted []string
+	mountFn = func(source, target, fstype string, flags uintptr, data string) error {
+		assert.Equal(t, uintptr(syscall.MS_REMOUNT|syscall.MS_RDONLY), flags)
+		remounted = append(remounted, target)
+		return nil
+	}
+
+	// data devices first, the boot disk last
+	readOnlyMounts()
+	assert.Equal(t, []string{"/data", "/"}, remounted)
+
+	f, err := os.Open(procMounts)
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.False(t, mountedReadOnly(f, "/"))
+
+	assert.True(t, mountedReadOnly(strings.NewReader(`rootfs / rootfs rw 0 0
+/dev/vda2 / ext4 ro,noatime 0 0
+`), "/"))
+
+}
@@ -0,0 +1,202 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	restartActionPanic    = "panic"
+	restartActionPoweroff = "poweroff"
+
+	// restart policies of programs
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+
+	// restart delays, reset if the program ran longer than restartStable
+	restartInitialDelay = 100 * time.Millisecond
+	restartMaxDelay     = 30 * time.Second
+	restartStable       = 10 * time.Second
+
+	// restarts of one program within the window considered a crash loop
+	crashLoopLimit  = 5
+	crashLoopWindow = 60 * time.Second
+)
+
+// backoff doubles the delay between restarts of a crashing program
+type backoff struct {
+	initial, max, stable time.Duration
+
+	delay    time.Duration
+	attempts int
+	started  time.Time
+}
+
+func newBackoff(max time.Duration) *backoff {
+	return &backoff{
+		initial: restartInitialDelay,
+		max:     max,
+		stable:  restartStable,
+	}
+}
+
+// start records the launch of the program
+func (b *backoff) start(now time.Time) {
+	b.started = now
+}
+
+// next returns the delay before the next restart. A program which has been
+// running longer than the stability window starts with the initial delay
+// again.
+func (b *backoff) next(now time.Time) time.Duration {
+
+	if !b.started.IsZero() && now.Sub(b.started) >= b.stable {
+		b.delay = 0
+		b.attempts = 0
+	}
+
+	b.attempts++
+
+	if b.delay == 0 {
+		b.delay = b.initial
+	} else {
+		b.delay *= 2
+	}
+
+	if b.delay > b.max {
+		b.delay = b.max
+	}
+
+	return b.delay
+}
+
+// restartGuard counts restarts in a sliding window to detect flapping
+type restartGuard struct {
+	lock   sync.Mutex
+	limit  int
+	window time.Duration
+	times  []time.Time
+}
+
+// restarts of all programs, nil until programs are launched
+var systemRestarts *restartGuard
+
+func newRestartGuard(limit int, window time.Duration) *restartGuard {
+	return &restartGuard{
+		limit:  limit,
+		window: window,
+	}
+}
+
+// expire drops restarts older than the window, needs the lock
+func (g *restartGuard) expire(now time.Time) {
+	i := 0
+	for i < len(g.times) && now.Sub(g.times[i]) >= g.window {
+		i++
+	}
+	g.times = g.times[i:]
+}
+
+// record adds a restart and reports if the limit has been exceeded
+func (g *restartGuard) record(now time.Time) bool {
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.expire(now)
+	g.times = append(g.times, now)
+
+	return g.limit > 0 && len(g.times) > g.limit
+}
+
+// count returns the number of restarts in the window
+func (g *restartGuard) count(now time.Time) int {
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.expire(now)
+	return len(g.times)
+}
+
+// recordSystemRestart tracks restarts across all programs. If too many
+// programs are flapping the system is considered broken.
+func recordSystemRestart(name string) {
+
+	if systemRestarts == nil || !systemRestarts.record(time.Now()) {
+		return
+	}
+
+	n := systemRestarts.count(time.Now())
+
+	switch kernelOpts.restartAction {
+	case restartActionPoweroff:
+		logError("%d program restarts within %v, last %s, powering off", n, systemRestarts.window, name)
+		shutdown(syscall.LINUX_REBOOT_CMD_POWER_OFF, 0)
+	default:
+		SystemPanic("%d program restarts within %v, last %s, system unhealthy", n, systemRestarts.window, name)
+	}
+
+}
+
+// needsRestart decides with the restart policy if a program gets restarted
+func needsRestart(policy string, code int) bool {
+
+	switch policy {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return code != 0
+	}
+
+	return false
+}
+
+// restartProgram launches a program again after it exited. Restarts
+// requested on the control socket are not delayed and do not count as
+// crashes.
+func (v *Vinitd) restartProgram(p *program, code int, requested bool) {
+
+	p.recordRestart()
+	logAlways("restarting %s, exit code %d", p.path, code)
+
+	if !requested {
+
+		// a program restarting all the time means a broken image
+		if p.crashLoop.record(time.Now()) {
+			SystemPanic("%s is crash looping, more than %d restarts within %v, last exit code %d",
+				p.path, p.crashLoop.limit, p.crashLoop.window, code)
+		}
+
+		d := p.backoff.next(time.Now())
+		logDebug("restarting %s (attempt %d, waiting %v)", p.path, p.backoff.attempts, d)
+
+		recordSystemRestart(p.path)
+
+		time.Sleep(d)
+	}
+
+	// removed or shutting down while waiting
+	if v.isRemoved(p) || initStatus == statusPoweroff {
+		p.setRestarting(false)
+		return
+	}
+
+	err := v.launchProgram(p, nil)
+	p.setRestarting(false)
+	if err != nil {
+		err = v.launchFailed(p, err)
+	}
+	if err != nil {
+		logError("can not restart %s: %s", p.path, err.Error())
+		v.checkProgramsExited()
+	}
+
+}
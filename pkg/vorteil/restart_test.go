@@ -0,0 +1,185 @@
+package vorteil
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartGuard(t *testing.T) {
+
+	g := newRestartGuard(3, time.Minute)
+	now := time.Now()
+
+	assert.False(t, g.record(now))
+	assert.False(t, g.record(now.Add(10*time.Second)))
+	assert.False(t, g.record(now.Add(20*time.Second)))
+	assert.Equal(t, 3, g.count(now.Add(20*time.Second)))
+
+	// fourth restart within the window crosses the threshold
+	assert.True(t, g.record(now.Add(30*time.Second)))
+
+	// the window slides, the first two are expired
+	assert.Equal(t, 2, g.count(now.Add(70*time.Second)))
+	assert.False(t, g.record(now.Add(70*time.Second)))
+
+	// no limit
+	g = newRestartGuard(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		assert.False(t, g.record(now))
+	}
+
+}
+
+func TestRestartPolicy(t *testing.T) {
+
+	assert.False(t, needsRestart(restartNever, 1))
+	assert.False(t, needsRestart(restartOnFailure, 0))
+	assert.True(t, needsRestart(restartOnFailure, 2))
+	assert.True(t, needsRestart(restartAlways, 0))
+
+	// exit codes from the reaper
+	code := func(script string) int {
+		cmd := exec.Command("sh", "-c", script)
+		exit, err := startReaped(cmd, cmd.Start)
+		assert.NoError(t, err)
+		for len(exit) == 0 {
+			reapChildren()
+			time.Sleep(10 * time.Millisecond)
+		}
+		return exitCode(<-exit)
+	}
+
+	assert.Equal(t, 0, code("exit 0"))
+	assert.Equal(t, 3, code("exit 3"))
+	assert.Equal(t, 137, code("kill -9 $$"))
+
+}
+
+func TestProgramsDone(t *testing.T) {
+
+	started := func(restart string, exited bool) *program {
+		return &program{
+			cmd:    &exec.Cmd{Process: &os.Process{Pid: 100}},
+			exited: exited,
+			opts:   programOptions{restart: restart},
+		}
+	}
+
+	// exit of the only program without restart shuts down
+	assert.True(t, programsDone([]*program{started(restartNever, false)}, false))
+	assert.False(t, programsDone([]*program{started(restartNever, false)}, true))
+
+	// restartable programs have to be waited for
+	p := started(restartOnFailure, false)
+	assert.False(t, programsDone([]*program{p}, false))
+
+	p.exited = true
+	assert.True(t, programsDone([]*program{p}, false))
+
+	// and must not be restarting
+	p.restarting = true
+	assert.False(t, programsDone([]*program{p}, false))
+
+	// not started yet
+	assert.False(t, programsDone([]*program{{}}, false))
+
+}
+
+func TestRestartBackoff(t *testing.T) {
+
+	b := newBackoff(time.Second)
+	now := time.Now()
+
+	// crashing right after start doubles the delay up to the maximum
+	var delays []time.Duration
+	for i := 0; i < 6; i++ {
+		b.start(now)
+		now = now.Add(time.Millisecond)
+		delays = append(delays, b.next(now))
+	}
+
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}, delays)
+	assert.Equal(t, 6, b.attempts)
+
+	// running longer than the stability window resets it
+	b.start(now)
+	now = now.Add(restartStable)
+	assert.Equal(t, 100*time.Millisecond, b.next(now))
+	assert.Equal(t, 1, b.attempts)
+
+}
+
+func TestCrashLoop(t *testing.T) {
+
+	New(testLogFn)
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_RESTART=always"})
+	assert.NoError(t, err)
+
+	g := newRestartGuard(opts.crashLoopLimit, opts.crashLoopWindow)
+	now := time.Now()
+
+	// rapid exits, the sixth restart within a minute is a crash loop
+	for i := 0; i < crashLoopLimit; i++ {
+		assert.False(t, g.record(now.Add(time.Duration(i)*time.Second)))
+	}
+	assert.True(t, g.record(now.Add(5*time.Second)))
+
+	// restarts spread out over more than the window are fine
+	g = newRestartGuard(opts.crashLoopLimit, opts.crashLoopWindow)
+	for i := 0; i < 20; i++ {
+		assert.False(t, g.record(now.Add(time.Duration(i)*15*time.Second)))
+	}
+
+	opts, _, err = parseProgramOptions([]string{"VINITD_CRASH_LOOP_LIMIT=0", "VINITD_CRASH_LOOP_WINDOW=10"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, opts.crashLoopLimit)
+	assert.Equal(t, 10*time.Second, opts.crashLoopWindow)
+
+}
+
+func TestRequestedRestart(t *testing.T) {
+
+	New(testLogFn)
+
+	restarts := systemRestarts
+	defer func() {
+		systemRestarts = restarts
+	}()
+	systemRestarts = newRestartGuard(1, time.Minute)
+
+	// removed while restarting, nothing gets launched
+	p := &program{
+		removed:   true,
+		backoff:   newBackoff(restartMaxDelay),
+		crashLoop: newRestartGuard(1, time.Minute),
+	}
+
+	// restarts on the control socket are no crashes
+	v := &Vinitd{}
+	for i := 0; i < 10; i++ {
+		p.restarting = true
+		v.restartProgram(p, 0, true)
+		assert.False(t, p.restarting)
+	}
+	assert.Equal(t, 0, p.crashLoop.count(time.Now()))
+	assert.Equal(t, 0, systemRestarts.count(time.Now()))
+	assert.Equal(t, 0, p.backoff.attempts)
+	assert.Equal(t, 10, p.currentStatus().Restarts)
+
+	v.restartProgram(p, 1, false)
+	assert.Equal(t, 1, p.crashLoop.count(time.Now()))
+	assert.Equal(t, 1, systemRestarts.count(time.Now()))
+
+}
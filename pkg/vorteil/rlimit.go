@@ -0,0 +1,228 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// AppExec is the name vinitd runs as if it sets up a program before exec
+	AppExec = "vexec"
+
+	// settings for the exec wrapper, not passed to the program
+	execSetupEnv = "VINITD_EXEC_SETUP"
+
+	nrOpenFile = "/proc/sys/fs/nr_open"
+)
+
+var (
+	rlimitNames = map[string]int{
+		"NOFILE": unix.RLIMIT_NOFILE,
+		"NPROC":  unix.RLIMIT_NPROC,
+		"CORE":   unix.RLIMIT_CORE,
+		"AS":     unix.RLIMIT_AS,
+	}
+
+	// binary running the exec wrapper, replaced in tests
+	execWrapper = vinitdApp
+)
+
+// execSetup is applied by the exec wrapper in the child before exec
+type execSetup struct {
+	Rlimits map[int]unix.Rlimit
+
+	// capabilities left in the bounding set
+	DropCapabilities bool
+	Capabilities     []int
+
+	// allowed system calls and the seccomp action for all others
+	Seccomp       []uint32
+	SeccompAction uint32
+}
+
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "unlimited" || value == "infinity" {
+		return unix.RLIM_INFINITY, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// parseRlimit reads soft:hard or a single value for both
+func parseRlimit(value string) (unix.Rlimit, error) {
+
+	var l unix.Rlimit
+
+	sh := strings.SplitN(value, ":", 2)
+
+	cur, err := parseRlimitValue(sh[0])
+	if err != nil {
+		return l, fmt.Errorf("invalid limit %s", sh[0])
+	}
+
+	max := cur
+	if len(sh) == 2 {
+		max, err = parseRlimitValue(sh[1])
+		if err != nil {
+			return l, fmt.Errorf("invalid limit %s", sh[1])
+		}
+	}
+
+	if cur > max {
+		return l, fmt.Errorf("soft limit %s is higher than hard limit %s", sh[0], sh[1])
+	}
+
+	l.Cur, l.Max = cur, max
+
+	return l, nil
+}
+
+// clampRlimits limits the open files to what the kernel allows
+func clampRlimits(limits map[int]unix.Rlimit) map[int]unix.Rlimit {
+
+	l, ok := limits[unix.RLIMIT_NOFILE]
+	if !ok {
+		return limits
+	}
+
+	b, err := ioutil.ReadFile(nrOpenFile)
+	if err != nil {
+		return limits
+	}
+
+	max, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return limits
+	}
+
+	if l.Max > max {
+		logWarn("open file limit %d higher than kernel maximum, using %d", l.Max, max)
+		l.Max = max
+	}
+	if l.Cur > max {
+		l.Cur = max
+	}
+	limits[unix.RLIMIT_NOFILE] = l
+
+	return limits
+}
+
+// raiseHardLimits raises the hard limits of vinitd, so programs running as
+// users can set their limits up to them
+func raiseHardLimits(limits map[int]unix.Rlimit) error {
+
+	for r, l := range limits {
+
+		var cur unix.Rlimit
+		err := unix.Getrlimit(r, &cur)
+		if err != nil {
+			return err
+		}
+
+		if cur.Max == unix.RLIM_INFINITY || (l.Max != unix.RLIM_INFINITY && cur.Max >= l.Max) {
+			continue
+		}
+
+		cur.Max = l.Max
+		err = unix.Setrlimit(r, &cur)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+}
+
+// wrapExec runs the command through the exec wrapper which applies the
+// setup before it executes the command
+func wrapExec(cmd *exec.Cmd, setup execSetup) error {
+
+	s, err := json.Marshal(setup)
+	if err != nil {
+		return err
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	cmd.Args = append([]string{AppExec, cmd.Path}, cmd.Args...)
+	cmd.Path = execWrapper
+	cmd.Env = append(env, fmt.Sprintf("%s=%s", execSetupEnv, string(s)))
+
+	return nil
+}
+
+// RunExec applies the setup passed by vinitd and replaces itself with the
+// program. Arguments are the path of the program and its arguments.
+func RunExec(args []string) int {
+
+	vlog = LogFnKernel
+
+	if len(args) < 2 {
+		logError("exec wrapper needs a program to run")
+		return 1
+	}
+
+	var (
+		setup execSetup
+		env   []string
+	)
+
+	for _, e := range os.Environ() {
+		if strings.HasPrefix(e, execSetupEnv+"=") {
+			err := json.Unmarshal([]byte(strings.TrimPrefix(e, execSetupEnv+"=")), &setup)
+			if err != nil {
+				logError("invalid exec setup: %s", err.Error())
+				return 1
+			}
+			continue
+		}
+		env = append(env, e)
+	}
+
+	for r, l := range setup.Rlimits {
+		l := l
+		err := unix.Setrlimit(r, &l)
+		if err != nil {
+			logError("can not set resource limit %d for %s: %s", r, args[0], err.Error())
+			return 1
+		}
+	}
+
+	if setup.DropCapabilities {
+		err := dropCapabilities(setup.Capabilities)
+		if err != nil {
+			logError("can not drop capabilities for %s: %s", args[0], err.Error())
+			return 1
+		}
+	}
+
+	// last, the setup above might need blocked system calls
+	if setup.Seccomp != nil {
+		err := installSeccomp(seccompFilter(setup.Seccomp, setup.SeccompAction))
+		if err != nil {
+			logError("can not install seccomp filter for %s: %s", args[0], err.Error())
+			return 1
+		}
+	}
+
+	err := syscall.Exec(args[0], args[1:], env)
+	logError("can not execute %s: %s", args[0], err.Error())
+
+	return codeExecFailed
+}
@@ -0,0 +1,54 @@
+package vorteil
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// TestExecHelper is the exec wrapper when started by TestRlimits
+func TestExecHelper(t *testing.T) {
+	if os.Getenv(execSetupEnv) == "" {
+		return
+	}
+	os.Exit(RunExec(flag.Args()))
+}
+
+func TestRlimits(t *testing.T) {
+
+	New(testLogFn)
+
+	l, err := parseRlimit("256:512")
+	assert.NoError(t, err)
+	assert.Equal(t, unix.Rlimit{Cur: 256, Max: 512}, l)
+
+	l, err = parseRlimit("unlimited")
+	assert.NoError(t, err)
+	assert.Equal(t, unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY}, l)
+
+	_, err = parseRlimit("512:256")
+	assert.Error(t, err)
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_RLIMIT_NOFILE=256:512", "VINITD_RLIMIT_CORE=0"})
+	assert.NoError(t, err)
+
+	// the test binary is the wrapper
+	execWrapper = os.Args[0]
+	defer func() {
+		execWrapper = vinitdApp
+	}()
+
+	cmd := exec.Command("sh", "-c", "ulimit -Sn; ulimit -Hn; ulimit -c")
+	assert.NoError(t, wrapExec(cmd, execSetup{Rlimits: clampRlimits(opts.rlimits)}))
+	cmd.Args = append([]string{cmd.Args[0], "-test.run=^TestExecHelper$", "--"}, cmd.Args[1:]...)
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"256", "512", "0"}, strings.Fields(string(out)))
+
+}
@@ -0,0 +1,180 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// MS_LAZYTIME 1 << 25
+const rootMountFlags = syscall.MS_NOATIME | (1 << 25)
+
+// root filesystem as configured in pre-setup, needed to remount
+var (
+	rootDev, rootFSType, rootOpts string
+	rootFlags                     uintptr
+
+	// read-only root, only remounted read-write for writes by vinitd
+	rootReadOnly bool
+	rootLock     sync.Mutex
+
+	// upper and work directories of overlays on a tmpfs
+	overlayBase = "/vorteil/overlay"
+
+	// replaced in tests
+	mountFn = syscall.Mount
+)
+
+func remountRoot(readonly bool) error {
+
+	flags := syscall.MS_REMOUNT | rootFlags
+	mode := "read-write"
+	if readonly {
+		flags |= syscall.MS_RDONLY
+		mode = "read-only"
+	}
+
+	err := mountFn(rootDev, "/", rootFSType, flags, rootOpts)
+	if err != nil {
+		return fmt.Errorf("can not remount / %s: %s", mode, err.Error())
+	}
+
+	logDebug("remounted / %s", mode)
+
+	return nil
+}
+
+// setRootReadOnly mounts the root filesystem read-only. Writes by vinitd
+// have to use withWritableRoot afterwards.
+func setRootReadOnly() error {
+
+	rootLock.Lock()
+	defer rootLock.Unlock()
+
+	err := remountRoot(true)
+	if err != nil {
+		return err
+	}
+
+	rootReadOnly = true
+	logAlways("root filesystem read-only")
+
+	return nil
+}
+
+// withWritableRoot runs fn with the root filesystem mounted read-write. If the
+// root is read-only it gets remounted read-only again after fn returns.
+func withWritableRoot(reason string, fn func() error) error {
+
+	rootLock.Lock()
+	defer rootLock.Unlock()
+
+	if !rootReadOnly {
+		return fn()
+	}
+
+	logDebug("root read-write for %s", reason)
+	err := remountRoot(false)
+	if err != nil {
+		return err
+	}
+
+	ferr := fn()
+
+	err = remountRoot(true)
+	if err != nil {
+		logError("%s", err.Error())
+	}
+
+	return ferr
+}
+
+// parseOverlays reads a comma separated list of directories
+func parseOverlays(value string) ([]string, error) {
+
+	var paths []string
+
+	for _, p := range strings.Split(value, ",") {
+		c := filepath.Clean(p)
+		if !filepath.IsAbs(p) || c == "/" {
+			return nil, fmt.Errorf("invalid overlay path '%s'", p)
+		}
+		paths = append(paths, c)
+	}
+
+	return paths, nil
+}
+
+// bootOverlays returns the configured overlays. With a read-only root the
+// program log files are kept in memory as well.
+func bootOverlays(o kernelOptions) []string {
+
+	if !o.readOnlyRoot {
+		return o.overlays
+	}
+
+	for _, p := range o.overlays {
+		if p == logsDir {
+			return o.overlays
+		}
+	}
+
+	return append(append([]string{}, o.overlays...), logsDir)
+}
+
+// setupOverlays mounts an overlay on each directory. The content of the
+// directory stays visible and changes are kept in memory, so the directories
+// are writable on a read-only root.
+func setupOverlays(paths []string) error {
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	err := os.MkdirAll(overlayBase, 0700)
+	if err != nil {
+		return err
+	}
+
+	err = mountFn("tmpfs", overlayBase, "tmpfs", 0, fmt.Sprintf("size=%s,mode=0700", tmpfsDefaultSize))
+	if err != nil {
+		return fmt.Errorf("can not mount tmpfs for overlays: %s", err.Error())
+	}
+
+	for i, p := range paths {
+
+		upper := filepath.Join(overlayBase, fmt.Sprintf("%d", i), "upper")
+		work := filepath.Join(overlayBase, fmt.Sprintf("%d", i), "work")
+
+		for _, d := range []string{p, upper, work} {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				return err
+			}
+		}
+
+		opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", p, upper, work)
+		err = mountFn("overlay", p, "overlay", 0, opts)
+		if err != nil {
+			return fmt.Errorf("can not mount overlay on %s: %s", p, err.Error())
+		}
+
+		logDebug("mounted overlay on %s", p)
+	}
+
+	return nil
+}
+
+// rootWritable reports if the root filesystem can have changes to flush
+func rootWritable() bool {
+	rootLock.Lock()
+	defer rootLock.Unlock()
+	return !rootReadOnly
+}
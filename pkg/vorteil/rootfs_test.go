@@ -0,0 +1,125 @@
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordMounts replaces mountFn and returns the calls as target, type and
+// options
+func recordMounts() (*[]string, func()) {
+
+	var calls []string
+
+	mf := mountFn
+	mountFn = func(source, target, fstype string, flags uintptr, data string) error {
+		calls = append(calls, fmt.Sprintf("%s %s %x %s", target, fstype, flags, data))
+		return nil
+	}
+
+	return &calls, func() {
+		mountFn = mf
+	}
+}
+
+func TestRemountRoot(t *testing.T) {
+
+	New(testLogFn)
+
+	calls, restore := recordMounts()
+	defer restore()
+
+	rootFSType, rootOpts, rootFlags = "ext4", "nodiscard", syscall.MS_NOATIME
+	defer func() {
+		rootFSType, rootOpts, rootFlags = "", "", 0
+		rootReadOnly = false
+	}()
+
+	ro := fmt.Sprintf("%x", syscall.MS_REMOUNT|syscall.MS_NOATIME|syscall.MS_RDONLY)
+	rw := fmt.Sprintf("%x", syscall.MS_REMOUNT|syscall.MS_NOATIME)
+
+	assert.True(t, rootWritable())
+	assert.NoError(t, setRootReadOnly())
+	assert.False(t, rootWritable())
+	assert.Equal(t, []string{"/ ext4 " + ro + " nodiscard"}, *calls)
+
+	// writes remount read-write and back
+	*calls = nil
+	written := false
+	assert.NoError(t, withWritableRoot("test", func() error {
+		written = true
+		return nil
+	}))
+	assert.True(t, written)
+	assert.Equal(t, []string{"/ ext4 " + rw + " nodiscard", "/ ext4 " + ro + " nodiscard"}, *calls)
+
+}
+
+func TestSetupOverlays(t *testing.T) {
+
+	New(testLogFn)
+
+	calls, restore := recordMounts()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "overlay")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	base := overlayBase
+	defer func() {
+		overlayBase = base
+	}()
+	overlayBase = filepath.Join(dir, "overlay")
+
+	paths, err := parseOverlays(filepath.Join(dir, "var") + "," + filepath.Join(dir, "etc") + "/")
+	assert.NoError(t, err)
+
+	assert.NoError(t, setupOverlays(paths))
+	assert.Equal(t, []string{
+		overlayBase + " tmpfs 0 size=10%,mode=0700",
+		fmt.Sprintf("%s/var overlay 0 lowerdir=%s/var,upperdir=%s/0/upper,workdir=%s/0/work", dir, dir, overlayBase, overlayBase),
+		fmt.Sprintf("%s/etc overlay 0 lowerdir=%s/etc,upperdir=%s/1/upper,workdir=%s/1/work", dir, dir, overlayBase, overlayBase),
+	}, *calls)
+	assert.DirExists(t, filepath.Join(overlayBase, "1", "work"))
+
+	_, err = parseOverlays("/var,tmp")
+	assert.Error(t, err)
+
+	_, err = parseOverlays("/")
+	assert.Error(t, err)
+
+	// program log files stay writable on a read-only root
+	o := kernelOptions{overlays: []string{"/var"}}
+	assert.Equal(t, []string{"/var"}, bootOverlays(o))
+	o.readOnlyRoot = true
+	assert.Equal(t, []string{"/var", logsDir}, bootOverlays(o))
+	o.overlays = []string{logsDir}
+	assert.Equal(t, []string{logsDir}, bootOverlays(o))
+
+}
+
+func TestRootMountOptions(t *testing.T) {
+
+	opts, err := rootMountOptions("ext4")
+	assert.NoError(t, err)
+	assert.Equal(t, "nodiscard,commit=30,inode_readahead_blks=64", opts)
+
+	opts, err = rootMountOptions("xfs")
+	assert.NoError(t, err)
+	assert.Equal(t, "nodiscard,attr2,inode64,noquota", opts)
+
+	opts, err = rootMountOptions("ext2")
+	assert.NoError(t, err)
+	assert.Empty(t, opts)
+
+	_, err = rootMountOptions("btrfs")
+	assert.Error(t, err)
+
+}
@@ -0,0 +1,174 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+	"golang.org/x/sys/unix"
+)
+
+// staticRoute is a route added after the interfaces are configured. Without
+// gateway the destination is reached directly on the interface.
+type staticRoute struct {
+	dst    *net.IPNet
+	gw     net.IP
+	ifc    string
+	metric int
+}
+
+func (r staticRoute) String() string {
+	s := r.dst.String()
+	if r.gw != nil {
+		s = fmt.Sprintf("%s via %s", s, r.gw)
+	}
+	if r.ifc != "" {
+		s = fmt.Sprintf("%s dev %s", s, r.ifc)
+	}
+	if r.metric > 0 {
+		s = fmt.Sprintf("%s metric %d", s, r.metric)
+	}
+	return s
+}
+
+func newStaticRoute(dst, gw, ifc string, metric int) (staticRoute, error) {
+
+	r := staticRoute{
+		ifc:    ifc,
+		metric: metric,
+	}
+
+	_, nw, err := net.ParseCIDR(dst)
+	if err != nil {
+		return r, fmt.Errorf("invalid route destination '%s'", dst)
+	}
+	r.dst = nw
+
+	if gw != "" {
+		r.gw = net.ParseIP(gw)
+		if r.gw == nil || (r.gw.To4() == nil) != (nw.IP.To4() == nil) {
+			return r, fmt.Errorf("invalid gateway '%s' for %s", gw, dst)
+		}
+	}
+
+	if r.gw == nil && ifc == "" {
+		return r, fmt.Errorf("route to %s needs a gateway or interface", dst)
+	}
+
+	return r, nil
+}
+
+// parseRoutes reads comma separated destination@gateway@interface@metric
+// entries, everything after the destination is optional
+func parseRoutes(value string) ([]staticRoute, error) {
+
+	var routes []staticRoute
+
+	for _, e := range strings.Split(value, ",") {
+
+		f := strings.Split(e, "@")
+		if len(f) > 4 {
+			return nil, fmt.Errorf("'%s' not in format destination@gateway@interface@metric", e)
+		}
+		f = append(f, make([]string, 4-len(f))...)
+
+		var (
+			metric int
+			err    error
+		)
+		if f[3] != "" {
+			metric, err = strconv.Atoi(f[3])
+			if err != nil || metric < 0 {
+				return nil, fmt.Errorf("invalid route metric '%s'", f[3])
+			}
+		}
+
+		r, err := newStaticRoute(f[0], f[1], f[2], metric)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+// vcfgRoutes converts the routes of the configuration, invalid ones are
+// logged and skipped
+func vcfgRoutes(routes []vcfg.Route) []staticRoute {
+
+	var sr []staticRoute
+
+	for _, r := range routes {
+		s, err := newStaticRoute(r.Destination, r.Gateway, r.Interface, 0)
+		if err != nil {
+			logError("can not set route: %s", err.Error())
+			continue
+		}
+		sr = append(sr, s)
+	}
+
+	return sr
+}
+
+// configRoutes adds the routes to the main table. Routes to the same
+// destination with the same metric and already existing ones are skipped.
+func (v *Vinitd) configRoutes(routes []staticRoute) {
+
+	seen := make(map[string]bool)
+
+	for _, r := range routes {
+
+		key := fmt.Sprintf("%s/%d", r.dst, r.metric)
+		if seen[key] {
+			logWarn("route to %s set multiple times, skipping %s", r.dst, r)
+			continue
+		}
+		seen[key] = true
+
+		nr := &netlink.Route{
+			Dst:      r.dst,
+			Gw:       r.gw,
+			Priority: r.metric,
+		}
+
+		if r.ifc != "" {
+
+			name := r.ifc
+			if i, ok := v.ifcs[r.ifc]; ok {
+				name = i.netIfc.Name
+			}
+
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				logError("can not set route %s: %s", r, err.Error())
+				continue
+			}
+			nr.LinkIndex = link.Attrs().Index
+
+			// the gateway does not have to be in the network of the interface
+			if r.gw != nil && r.gw.To4() != nil {
+				nr.Flags = int(netlink.FLAG_ONLINK)
+			}
+		}
+
+		logDebug("adding route %s", r)
+		err := netlink.RouteAdd(nr)
+		if errors.Is(err, unix.EEXIST) {
+			logWarn("route to %s exists, skipping %s", r.dst, r)
+		} else if err != nil {
+			logError("can not set route %s: %s", r, err.Error())
+		}
+	}
+
+}
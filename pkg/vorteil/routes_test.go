@@ -0,0 +1,73 @@
+package vorteil
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func TestParseRoutes(t *testing.T) {
+
+	routes, err := parseRoutes("169.254.0.0/16@10.0.1.1@eth1@100,2001:db8:5::/48@fe80::1,10.9.0.0/16@@eth0")
+	assert.NoError(t, err)
+	assert.Len(t, routes, 3)
+	assert.Equal(t, "169.254.0.0/16 via 10.0.1.1 dev eth1 metric 100", routes[0].String())
+	assert.Equal(t, "2001:db8:5::/48 via fe80::1", routes[1].String())
+	assert.Equal(t, "10.9.0.0/16 dev eth0", routes[2].String())
+
+	for _, v := range []string{"10.0.0.0/8", "10.0.0.1@10.0.0.2", "10.0.0.0/8@fe80::1",
+		"10.0.0.0/8@10.0.0.1@eth0@-1", "10.0.0.0/8@10.0.0.1@eth0@1@2"} {
+		_, err = parseRoutes(v)
+		assert.Error(t, err, v)
+	}
+
+	New(testLogFn)
+	assert.Len(t, vcfgRoutes([]vcfg.Route{
+		{Destination: "10.1.0.0/16", Gateway: "10.0.0.1", Interface: "eth0"},
+		{Destination: "invalid", Gateway: "10.0.0.1"},
+	}), 1)
+
+}
+
+func TestConfigRoutes(t *testing.T) {
+
+	New(testLogFn)
+
+	inNetns(t, func(lo netlink.Link) {
+
+		// loopback can not route to gateways
+		routes, err := parseRoutes("10.10.0.0/16@@eth0@50,10.10.0.0/16@@eth0@50,10.10.0.0/16@@eth0@60,2001:db8:5::/48@@eth0")
+		assert.NoError(t, err)
+
+		v := &Vinitd{ifcs: map[string]*ifc{
+			"eth0": {name: "eth0", netIfc: net.Interface{Name: "lo"}},
+		}}
+		v.configRoutes(routes)
+
+		// existing routes are skipped
+		v.configRoutes(routes[:1])
+
+		var metrics []int
+		rl, err := netlink.RouteList(lo, netlink.FAMILY_V4)
+		assert.NoError(t, err)
+		for _, r := range rl {
+			if r.Dst != nil && r.Dst.String() == "10.10.0.0/16" {
+				metrics = append(metrics, r.Priority)
+			}
+		}
+		assert.ElementsMatch(t, []int{50, 60}, metrics)
+
+		rl, err = netlink.RouteList(lo, netlink.FAMILY_V6)
+		assert.NoError(t, err)
+		var found bool
+		for _, r := range rl {
+			found = found || (r.Dst != nil && r.Dst.String() == "2001:db8:5::/48")
+		}
+		assert.True(t, found)
+
+	})
+
+}
@@ -0,0 +1,56 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	rtcDevice = "/dev/rtc0"
+
+	// reads the time from the open rtc, replaced in tests
+	rtcReadTime = unix.IoctlGetRTCTime
+)
+
+func rtcTime(t *unix.RTCTime) time.Time {
+	return time.Date(int(t.Year)+1900, time.Month(t.Mon+1), int(t.Mday),
+		int(t.Hour), int(t.Min), int(t.Sec), 0, time.UTC)
+}
+
+// setClockFromRTC initializes the system clock from the hardware clock
+// before ntp is available. The rtc is expected to run in utc.
+func setClockFromRTC() {
+
+	f, err := os.Open(rtcDevice)
+	if err != nil {
+		logDebug("no rtc available: %s", err.Error())
+		return
+	}
+	defer f.Close()
+
+	rt, err := rtcReadTime(int(f.Fd()))
+	if err != nil {
+		logWarn("can not read rtc: %s", err.Error())
+		return
+	}
+
+	t := rtcTime(rt)
+	if t.Before(minValidTime) {
+		logWarn("rtc time %s is not valid", t.Format(time.RFC3339))
+		return
+	}
+
+	if err = setClock(t); err != nil {
+		logWarn("can not set clock from rtc: %s", err.Error())
+		return
+	}
+
+	logDebug("clock set from rtc to %s", t.Format(time.RFC3339))
+}
@@ -0,0 +1,60 @@
+package vorteil
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestClockFromRTC(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "rtc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dev, read, sc := rtcDevice, rtcReadTime, setClock
+	defer func() {
+		rtcDevice, rtcReadTime, setClock = dev, read, sc
+	}()
+
+	var stepped time.Time
+	setClock = func(t time.Time) error {
+		stepped = t
+		return nil
+	}
+
+	rt := &unix.RTCTime{Year: 121, Mon: 2, Mday: 15, Hour: 8, Min: 30, Sec: 5}
+	rtcReadTime = func(fd int) (*unix.RTCTime, error) {
+		return rt, nil
+	}
+
+	// no device
+	rtcDevice = filepath.Join(dir, "rtc0")
+	setClockFromRTC()
+	assert.True(t, stepped.IsZero())
+
+	assert.NoError(t, ioutil.WriteFile(rtcDevice, nil, 0644))
+	setClockFromRTC()
+	assert.Equal(t, time.Date(2021, 3, 15, 8, 30, 5, 0, time.UTC), stepped)
+
+	// rtc not set
+	stepped = time.Time{}
+	rt = &unix.RTCTime{Year: 70, Mday: 1}
+	setClockFromRTC()
+	assert.True(t, stepped.IsZero())
+
+	rtcReadTime = func(fd int) (*unix.RTCTime, error) {
+		return nil, errors.New("ioctl failed")
+	}
+	setClockFromRTC()
+	assert.True(t, stepped.IsZero())
+
+}
@@ -0,0 +1,216 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// missing in x/sys
+const (
+	seccompSetModeFilter  = 1
+	seccompFilterFlagSync = 1
+	seccompFilterFlagLog  = 2
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+)
+
+const (
+	seccompProfileDefault = "default"
+	seccompProfileStrict  = "strict"
+
+	seccompActionErrno = "errno"
+	seccompActionKill  = "kill"
+	seccompActionLog   = "log"
+)
+
+var (
+	seccompActions = map[string]uint32{
+		seccompActionErrno: seccompRetErrno | uint32(unix.EPERM),
+		seccompActionKill:  seccompRetKillProcess,
+		seccompActionLog:   seccompRetLog,
+	}
+
+	// system calls the default profile blocks, all others are allowed
+	seccompDenied = []string{
+		"acct", "add_key", "adjtimex", "bpf", "clock_adjtime", "clock_settime",
+		"create_module", "delete_module", "finit_module", "fsconfig", "fsmount",
+		"fsopen", "fspick", "get_kernel_syms", "init_module", "ioperm", "iopl",
+		"kcmp", "kexec_file_load", "kexec_load", "keyctl", "lookup_dcookie",
+		"mount", "move_mount", "name_to_handle_at", "nfsservctl",
+		"open_by_handle_at", "open_tree", "perf_event_open", "pivot_root",
+		"process_vm_readv", "process_vm_writev", "ptrace", "query_module",
+		"quotactl", "reboot", "request_key", "setdomainname", "sethostname",
+		"setns", "settimeofday", "swapoff", "swapon", "_sysctl", "syslog",
+		"umount2", "unshare", "uselib", "userfaultfd", "ustat", "vhangup",
+	}
+
+	// file, memory, signal, time and socket basics
+	seccompStrict = []string{
+		"accept", "accept4", "access", "arch_prctl", "bind", "brk", "chdir",
+		"clock_getres", "clock_gettime", "clock_nanosleep", "clone", "close",
+		"connect", "dup", "dup2", "dup3", "epoll_create", "epoll_create1",
+		"epoll_ctl", "epoll_pwait", "epoll_wait", "eventfd2", "exit",
+		"exit_group", "faccessat", "fcntl", "fdatasync", "fstat", "fsync",
+		"ftruncate", "futex", "getcwd", "getdents64", "getegid", "geteuid",
+		"getgid", "getgroups", "getpeername", "getpid", "getppid", "getrandom",
+		"getrlimit", "getsockname", "getsockopt", "gettid", "gettimeofday",
+		"getuid", "ioctl", "kill", "listen", "lseek", "lstat", "madvise",
+		"mkdir", "mkdirat", "mmap", "mprotect", "mremap", "munmap", "nanosleep",
+		"newfstatat", "open", "openat", "pipe", "pipe2", "poll", "ppoll",
+		"pread64", "prlimit64", "pselect6", "pwrite64", "read", "readlink",
+		"readlinkat", "readv", "recvfrom", "recvmsg", "rename", "renameat",
+		"rseq", "rt_sigaction", "rt_sigprocmask", "rt_sigreturn",
+		"sched_getaffinity", "sched_yield", "select", "sendmsg", "sendto",
+		"set_robust_list", "set_tid_address", "setsockopt", "shutdown",
+		"sigaltstack", "socket", "stat", "statx", "sysinfo", "tgkill", "time",
+		"umask", "uname", "unlink", "unlinkat", "wait4", "write", "writev",
+	}
+)
+
+// seccompProfile returns the system calls of a built-in profile
+func seccompProfile(name string) ([]string, bool) {
+
+	switch name {
+	case seccompProfileDefault:
+		deny := make(map[string]bool)
+		for _, d := range seccompDenied {
+			deny[d] = true
+		}
+		var allowed []string
+		for s := range syscallNumbers {
+			if !deny[s] {
+				allowed = append(allowed, s)
+			}
+		}
+		return allowed, true
+	case seccompProfileStrict:
+		return seccompStrict, true
+	}
+
+	return nil, false
+}
+
+// parseSeccomp reads a comma separated list of profiles and system calls
+// allowed, e.g. "strict,mknod". execve is always allowed, the program is
+// executed after the filter is installed.
+func parseSeccomp(value string) ([]uint32, error) {
+
+	names := []string{"execve"}
+
+	for _, n := range strings.Split(value, ",") {
+
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n == "" {
+			continue
+		}
+
+		if p, ok := seccompProfile(n); ok {
+			names = append(names, p...)
+			continue
+		}
+
+		if _, ok := syscallNumbers[n]; !ok {
+			return nil, fmt.Errorf("unknown system call %s", n)
+		}
+		names = append(names, n)
+	}
+
+	seen := make(map[uint32]bool)
+	var nrs []uint32
+	for _, n := range names {
+		nr, ok := syscallNumbers[n]
+		if !ok || seen[nr] {
+			continue
+		}
+		seen[nr] = true
+		nrs = append(nrs, nr)
+	}
+
+	sort.Slice(nrs, func(i, j int) bool { return nrs[i] < nrs[j] })
+
+	return nrs, nil
+}
+
+// seccompFilter builds a bpf program allowing the system calls. Others get
+// the action, system calls unknown to vinitd fail with ENOSYS so programs
+// can fall back to older ones.
+func seccompFilter(allowed []uint32, action uint32) []unix.SockFilter {
+
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+
+	var max uint32
+	for _, nr := range syscallNumbers {
+		if nr > max {
+			max = nr
+		}
+	}
+
+	f := []unix.SockFilter{
+		// seccomp_data.arch
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 4),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, seccompArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess),
+
+		// seccomp_data.nr
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 0),
+		jump(unix.BPF_JMP|unix.BPF_JGT|unix.BPF_K, max, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetErrno|uint32(unix.ENOSYS)),
+	}
+
+	for _, nr := range allowed {
+		f = append(f,
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow))
+	}
+
+	return append(f, stmt(unix.BPF_RET|unix.BPF_K, action))
+}
+
+// installSeccomp applies the filter to all threads. Blocked system calls
+// are logged by the kernel if it supports it.
+func installSeccomp(f []unix.SockFilter) error {
+
+	runtime.LockOSThread()
+
+	err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	prog := unix.SockFprog{
+		Len:    uint16(len(f)),
+		Filter: &f[0],
+	}
+
+	flags := uintptr(seccompFilterFlagSync | seccompFilterFlagLog)
+	_, _, e := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, flags, uintptr(unsafe.Pointer(&prog)))
+
+	// kernels before 4.14 can not log
+	if e == unix.EINVAL {
+		_, _, e = unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagSync, uintptr(unsafe.Pointer(&prog)))
+	}
+
+	if e != 0 {
+		return e
+	}
+
+	return nil
+}
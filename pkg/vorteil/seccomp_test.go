@@ -0,0 +1,90 @@
+package vorteil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+const seccompHelperEnv = "VINITD_TEST_SECCOMP"
+
+// TestSeccompHelper tries a system call the default profile blocks when
+// started by TestSeccomp
+func TestSeccompHelper(t *testing.T) {
+	if os.Getenv(seccompHelperEnv) == "" {
+		return
+	}
+	err := unix.Unshare(0)
+	fmt.Printf("unshare: %v\n", err)
+	os.Exit(0)
+}
+
+func TestSeccomp(t *testing.T) {
+
+	New(testLogFn)
+
+	nrs, err := parseSeccomp("read, write,exit_group")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{unix.SYS_READ, unix.SYS_WRITE, unix.SYS_EXECVE, unix.SYS_EXIT_GROUP}, nrs)
+
+	nrs, err = parseSeccomp("strict,mknod")
+	assert.NoError(t, err)
+	assert.Contains(t, nrs, uint32(unix.SYS_MKNOD))
+	assert.Contains(t, nrs, uint32(unix.SYS_OPENAT))
+	assert.NotContains(t, nrs, uint32(unix.SYS_MOUNT))
+
+	_, err = parseSeccomp("read,fly")
+	assert.Error(t, err)
+
+	opts, _, err := parseProgramOptions([]string{"VINITD_SECCOMP=default", "VINITD_SECCOMP_ACTION=kill"})
+	assert.NoError(t, err)
+	assert.Contains(t, opts.seccomp, uint32(unix.SYS_CLONE))
+	assert.NotContains(t, opts.seccomp, uint32(unix.SYS_UNSHARE))
+	assert.Equal(t, seccompActionKill, opts.seccompAction)
+
+	_, _, err = parseProgramOptions([]string{"VINITD_SECCOMP_ACTION=ignore"})
+	assert.Error(t, err)
+
+	// the test binary is the wrapper and the program
+	execWrapper = os.Args[0]
+	defer func() {
+		execWrapper = vinitdApp
+	}()
+
+	run := func(action string) (string, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSeccompHelper$")
+		cmd.Env = append(os.Environ(), seccompHelperEnv+"=1")
+		assert.NoError(t, wrapExec(cmd, execSetup{
+			Seccomp:       opts.seccomp,
+			SeccompAction: seccompActions[action],
+		}))
+		cmd.Args = append([]string{cmd.Args[0], "-test.run=^TestExecHelper$", "--"}, cmd.Args[1:]...)
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	out, err := run(seccompActionErrno)
+	assert.NoError(t, err)
+	assert.Equal(t, "unshare: operation not permitted", out)
+
+	_, err = run(seccompActionKill)
+	var ee *exec.ExitError
+	assert.True(t, errors.As(err, &ee))
+	if ee != nil {
+		ws := ee.Sys().(syscall.WaitStatus)
+		assert.True(t, ws.Signaled())
+		assert.Equal(t, syscall.SIGSYS, ws.Signal())
+	}
+
+	out, err = run(seccompActionLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "unshare: <nil>", out)
+
+}
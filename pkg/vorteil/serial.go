@@ -0,0 +1,49 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// serialSink mirrors all messages to a serial console, e.g. /dev/ttyS0
+type serialSink struct {
+	lock sync.Mutex
+	out  *bufio.Writer
+	next logFn
+
+	copies screenCopies
+}
+
+func newSerialSink(out io.Writer, next logFn) *serialSink {
+	return &serialSink{
+		out:  bufio.NewWriter(out),
+		next: next,
+	}
+}
+
+// log passes the message on and writes it as one line to the serial device
+func (s *serialSink) log(level LogLevel, format string, values ...interface{}) {
+
+	s.next(level, format, values...)
+
+	msg := fmt.Sprintf(format, values...)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.copies.skip(level, msg) {
+		return
+	}
+
+	fmt.Fprintf(s.out, "%s %s\n", logPrefix(kernelOpts.logTimestamp, time.Now(), uptime()), msg)
+	s.out.Flush()
+
+}
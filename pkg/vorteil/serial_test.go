@@ -0,0 +1,44 @@
+package vorteil
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerialSink(t *testing.T) {
+
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+
+	var local []LogLevel
+	New(func(level LogLevel, format string, values ...interface{}) {
+		local = append(local, level)
+	})
+	s := newSerialSink(w, vlog)
+	vlog = s.log
+
+	lines := bufio.NewScanner(r)
+	next := func() string {
+		assert.True(t, lines.Scan())
+		return lines.Text()
+	}
+
+	// every line is flushed right away
+	logWarn("disk %s slow", "sda")
+	assert.True(t, strings.HasSuffix(next(), "] disk sda slow"))
+
+	// messages for screen and kernel log are written once
+	logAlways("starting")
+	logDebug("details")
+	assert.True(t, strings.HasSuffix(next(), "] starting"))
+	assert.True(t, strings.HasSuffix(next(), "] details"))
+
+	assert.Equal(t, []LogLevel{LogLvWARNING, LogLvSTDERR, LogLvDEBUG, LogLvDEBUG}, local)
+
+}
@@ -0,0 +1,181 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// AppShim is the name vinitd runs as if it is the init of a pid namespace
+	AppShim = "vshim"
+
+	vinitdApp = "/vorteil/vinitd"
+
+	// exit code if the program can not be executed, like a shell
+	codeExecFailed = 127
+)
+
+// binary running the shim, replaced in tests
+var shimBinary = vinitdApp
+
+// signals the shim passes on to the program. SIGCHLD is the shim's own and
+// SIGURG is used by the go runtime.
+var shimSignals = []os.Signal{
+	syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM,
+	syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGWINCH, syscall.SIGCONT,
+	syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGALRM,
+	syscall.SIGPWR,
+}
+
+// group names of VINITD_PID_NAMESPACE
+var pidGroupRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// parsePIDNamespace reads a boolean or the name of a group sharing the
+// namespace
+func parsePIDNamespace(value string) (bool, string, error) {
+
+	if b, err := boolean(value); err == nil {
+		return b, "", nil
+	}
+
+	if !pidGroupRegex.MatchString(value) {
+		return false, "", fmt.Errorf("invalid pid namespace group '%s'", value)
+	}
+
+	return true, value, nil
+}
+
+// pidNamespaceInit returns the pid of the running shim of the program's
+// group, 0 if there is none and the program starts the namespace
+func (p *program) pidNamespaceInit() int {
+
+	if p.opts.pidGroup == "" || p.vinitd == nil {
+		return 0
+	}
+
+	for _, o := range p.vinitd.programList() {
+		if o == p || o.opts.pidGroup != p.opts.pidGroup || o.joinPID != 0 {
+			continue
+		}
+		if o.cmd != nil && o.cmd.Process != nil && !o.exited {
+			return o.cmd.Process.Pid
+		}
+	}
+
+	return 0
+}
+
+// joinPIDNamespace makes the namespace of the process the one of children
+// started by the calling thread. The thread has to be locked.
+func joinPIDNamespace(pid int) error {
+
+	f, err := os.Open(fmt.Sprintf("/proc/%d/ns/pid", pid))
+	if err != nil {
+		return fmt.Errorf("can not join pid namespace of %d: %s", pid, err.Error())
+	}
+	defer f.Close()
+
+	err = unix.Setns(int(f.Fd()), unix.CLONE_NEWPID)
+	if err != nil {
+		return fmt.Errorf("can not join pid namespace of %d: %s", pid, err.Error())
+	}
+
+	return nil
+}
+
+// shimCommand runs the program through the shim in a new pid namespace
+func shimCommand(path string, args []string) *exec.Cmd {
+
+	cmd := exec.Command(shimBinary, append([]string{path}, args...)...)
+	cmd.Args[0] = AppShim
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWPID,
+	}
+
+	return cmd
+}
+
+func exitCode(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// shimWait reaps all children until the main process exits. It returns the
+// exit code of the main process and the number of reaped processes.
+func shimWait(pid int) (int, int) {
+
+	reaped := 0
+
+	for {
+		var ws syscall.WaitStatus
+
+		p, err := syscall.Wait4(-1, &ws, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			logError("shim can not wait for %d: %s", pid, err.Error())
+			return 1, reaped
+		}
+
+		reaped++
+		if p == pid {
+			return exitCode(ws), reaped
+		}
+	}
+
+}
+
+// RunShim is the init of a pid namespace. It starts the program, forwards
+// signals to it and reaps orphaned processes. It exits with the exit code of
+// the program which ends the namespace.
+func RunShim(args []string) int {
+
+	vlog = LogFnKernel
+
+	if len(args) == 0 {
+		logError("shim needs a program to run")
+		return 1
+	}
+
+	// without a namespace orphans would not be ours otherwise
+	unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+
+	sigs := make(chan os.Signal, 16)
+	signal.Notify(sigs, shimSignals...)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Start()
+	if err != nil {
+		logError("shim can not start %s: %s", args[0], err.Error())
+		return codeExecFailed
+	}
+
+	go func() {
+		for s := range sigs {
+			cmd.Process.Signal(s)
+		}
+	}()
+
+	code, _ := shimWait(cmd.Process.Pid)
+	signal.Stop(sigs)
+
+	return code
+}
@@ -0,0 +1,101 @@
+package vorteil
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const shimHelperEnv = "VINITD_TEST_SHIM"
+
+// TestShimHelper is the shim when started by TestPIDNamespace
+func TestShimHelper(t *testing.T) {
+	if os.Getenv(shimHelperEnv) == "" {
+		return
+	}
+	os.Exit(RunShim(flag.Args()))
+}
+
+func TestPIDNamespace(t *testing.T) {
+
+	New(testLogFn)
+
+	b, g, err := parsePIDNamespace("true")
+	assert.NoError(t, err)
+	assert.True(t, b)
+	assert.Equal(t, "", g)
+
+	b, g, err = parsePIDNamespace("web-tier")
+	assert.NoError(t, err)
+	assert.True(t, b)
+	assert.Equal(t, "web-tier", g)
+
+	_, _, err = parsePIDNamespace("web tier")
+	assert.Error(t, err)
+
+	// runtime and child signals stay with the shim
+	assert.Contains(t, shimSignals, syscall.SIGTERM)
+	assert.NotContains(t, shimSignals, syscall.SIGURG)
+	assert.NotContains(t, shimSignals, syscall.SIGCHLD)
+
+	if os.Getuid() != 0 {
+		t.Skip("pid namespaces need root")
+	}
+
+	// the test binary is the shim
+	shimBinary = os.Args[0]
+	defer func() {
+		shimBinary = vinitdApp
+	}()
+
+	shim := func(script string) *exec.Cmd {
+		cmd := shimCommand("/bin/sh", []string{"-c", script})
+		cmd.Args = append([]string{cmd.Args[0], "-test.run=^TestShimHelper$", "--"}, cmd.Args[1:]...)
+		cmd.Env = append(os.Environ(), shimHelperEnv+"=1")
+		return cmd
+	}
+
+	// the program's parent, the shim, is pid 1
+	out, err := shim("echo $PPID").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", strings.TrimSpace(string(out)))
+
+	// a second program of the group joins the namespace of the first
+	v := &Vinitd{}
+	first := &program{opts: programOptions{pidNamespace: true, pidGroup: "g"}, vinitd: v}
+	second := &program{opts: programOptions{pidNamespace: true, pidGroup: "g"}, vinitd: v}
+	v.programs = []*program{first, second}
+
+	assert.Equal(t, 0, second.pidNamespaceInit())
+
+	first.cmd = shim("sleep 10")
+	assert.NoError(t, first.cmd.Start())
+	defer func() {
+		first.cmd.Process.Kill()
+		first.cmd.Wait()
+	}()
+
+	pid := second.pidNamespaceInit()
+	assert.Equal(t, first.cmd.Process.Pid, pid)
+
+	ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid))
+	assert.NoError(t, err)
+
+	cmd := exec.Command("/bin/sh", "-c", "readlink /proc/self/ns/pid")
+	var sb strings.Builder
+	cmd.Stdout = &sb
+	assert.NoError(t, startIsolated(cmd, "", nil, pid))
+	assert.NoError(t, cmd.Wait())
+	assert.Equal(t, ns, strings.TrimSpace(sb.String()))
+
+	self, err := os.Readlink("/proc/self/ns/pid")
+	assert.NoError(t, err)
+	assert.NotEqual(t, self, ns)
+
+}
@@ -0,0 +1,136 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// states of a program over its life
+const (
+	stateStarting = "starting"
+	stateRunning  = "running"
+	stateFailed   = "failed"
+	stateStopped  = "stopped"
+)
+
+// checked until all programs are ready for the boot summary
+var summaryPoll = 100 * time.Millisecond
+
+// programStatus is what a program did since boot
+type programStatus struct {
+	Name     string
+	State    string
+	ExitCode int
+	ExitTime time.Time
+	Restarts int
+}
+
+func (p *program) setState(state string) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	p.status.State = state
+}
+
+// recordExit stores the exit code. Programs exiting with an error are failed
+// until they get restarted, stopped programs have been asked to exit.
+func (p *program) recordExit(code int, now time.Time, stopped bool) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+
+	p.status.ExitCode = code
+	p.status.ExitTime = now
+
+	p.status.State = stateStopped
+	if code != 0 && !stopped {
+		p.status.State = stateFailed
+	}
+}
+
+func (p *program) recordRestart() {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	p.status.Restarts++
+	p.status.State = stateStarting
+}
+
+// setRestarting is changed by the control socket as well
+func (p *program) setRestarting(restarting bool) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	p.restarting = restarting
+}
+
+// currentStatus returns a copy of the program's status
+func (p *program) currentStatus() programStatus {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	s := p.status
+	s.Name = p.name()
+	return s
+}
+
+// programStatus returns the status of all programs in launch order
+func (v *Vinitd) programStatus() []programStatus {
+
+	var status []programStatus
+	for _, p := range v.programList() {
+		status = append(status, p.currentStatus())
+	}
+
+	return status
+}
+
+// settled reports if the program is ready or will never be
+func (p *program) settled() bool {
+	select {
+	case <-p.ready:
+		return true
+	default:
+	}
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	return p.failed || (p.exited && !p.restarting)
+}
+
+// bootSummary logs one line with the boot time and the pid and readiness
+// latency of every program once all of them are ready
+func (v *Vinitd) bootSummary() {
+
+	progs := v.programList()
+	for _, p := range progs {
+		for !p.settled() {
+			time.Sleep(summaryPoll)
+		}
+	}
+
+	logAlways("%s", summaryLine(uptime(), progs))
+
+}
+
+func summaryLine(up float64, progs []*program) string {
+
+	fields := []string{fmt.Sprintf("uptime=%.3fs", up)}
+
+	for _, p := range progs {
+
+		pid := 0
+		if p.cmd != nil && p.cmd.Process != nil {
+			pid = p.cmd.Process.Pid
+		}
+
+		ready := "none"
+		if !p.readyAt.IsZero() && !p.launchedAt.IsZero() {
+			ready = p.readyAt.Sub(p.launchedAt).Round(time.Millisecond).String()
+		}
+
+		fields = append(fields, fmt.Sprintf("%s.pid=%d %s.ready=%s", p.name(), pid, p.name(), ready))
+	}
+
+	return fmt.Sprintf("system up %s", strings.Join(fields, " "))
+}
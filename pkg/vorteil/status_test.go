@@ -0,0 +1,112 @@
+package vorteil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vorteil/vorteil/pkg/vcfg"
+)
+
+func TestProgramStatus(t *testing.T) {
+
+	New(testLogFn)
+
+	v := &Vinitd{}
+	p, err := v.prepProgram(vcfg.Program{
+		Binary: "/bin/app",
+		Env:    []string{"VINITD_NAME=app"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, stateStarting, p.currentStatus().State)
+
+	p.setState(stateRunning)
+
+	now := time.Now()
+	p.recordExit(3, now, false)
+	s := p.currentStatus()
+	assert.Equal(t, "app", s.Name)
+	assert.Equal(t, stateFailed, s.State)
+	assert.Equal(t, 3, s.ExitCode)
+	assert.Equal(t, now, s.ExitTime)
+
+	p.recordRestart()
+	p.recordRestart()
+	s = p.currentStatus()
+	assert.Equal(t, stateStarting, s.State)
+	assert.Equal(t, 2, s.Restarts)
+
+	p.recordExit(0, now, false)
+	assert.Equal(t, stateStopped, p.currentStatus().State)
+
+	// killed on shutdown
+	p.recordExit(143, now, true)
+	assert.Equal(t, stateStopped, p.currentStatus().State)
+
+	status := v.programStatus()
+	assert.Len(t, status, 1)
+	assert.Equal(t, 2, status[0].Restarts)
+	assert.Equal(t, 143, status[0].ExitCode)
+
+}
+
+func TestBootSummary(t *testing.T) {
+
+	var summaries []string
+	New(func(level LogLevel, format string, values ...interface{}) {
+		if level == LogLvSTDERR && strings.HasPrefix(format, "%s") {
+			summaries = append(summaries, fmt.Sprintf(format, values...))
+		}
+	})
+
+	v := &Vinitd{}
+	for _, n := range []string{"web", "db"} {
+		_, err := v.prepProgram(vcfg.Program{
+			Binary: "/bin/" + n,
+			Env:    []string{"VINITD_NAME=" + n},
+		})
+		assert.NoError(t, err)
+	}
+
+	progs := v.programList()
+	now := time.Now()
+	for _, p := range progs {
+		p.launchedAt = now
+		p.cmd = &exec.Cmd{Process: &os.Process{Pid: 100}}
+	}
+
+	summaryPoll = 10 * time.Millisecond
+	defer func() {
+		summaryPoll = 100 * time.Millisecond
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		v.bootSummary()
+		close(done)
+	}()
+
+	// db is not ready yet
+	progs[0].markReady()
+	select {
+	case <-done:
+		t.Fatal("summary before all programs are ready")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	progs[1].markReady()
+	<-done
+
+	assert.Len(t, summaries, 1)
+	assert.Regexp(t, `^system up uptime=[0-9.]+s web.pid=100 web.ready=[0-9.]+m?s db.pid=100 db.ready=[0-9.]+m?s$`, summaries[0])
+
+	// failed programs are never ready
+	p := &program{vcfgProg: vcfg.Program{Binary: "/bin/cache"}, ready: make(chan struct{}), failed: true}
+	assert.True(t, p.settled())
+	assert.Equal(t, "system up uptime=1.500s cache.pid=0 cache.ready=none", summaryLine(1.5, []*program{p}))
+
+}
@@ -0,0 +1,163 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// runStopCommand runs the stop command of the program and logs its output.
+// The command gets killed after the stop timeout.
+func (p *program) runStopCommand() error {
+	return p.runHook("stop", p.opts.execStop, p.opts.stopTimeout)
+}
+
+// runHook runs a shell command in the environment and working directory of
+// the program and logs its output
+func (p *program) runHook(kind, command string, timeout time.Duration) error {
+
+	cmd, err := shellCommand("-c", command)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	cmd.Env = p.env
+	cmd.Dir = p.vcfgProg.Cwd
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	logAlways("running %s command for %s: %s", kind, p.path, command)
+
+	err = runWithTimeout(cmd, timeout)
+
+	for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if l != "" {
+			logAlways("%s %s: %s", p.path, kind, l)
+		}
+	}
+
+	return err
+}
+
+// runWithTimeout runs the command in its own process group, so children of
+// shells get killed as well if it has not finished within the timeout
+func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	exit, err := startReaped(cmd, cmd.Start)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitReaped(cmd, exit)
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+
+	return err
+}
+
+// runStopCommands runs the stop commands of all running programs in parallel.
+// Programs are signaled after this anyway, so failed commands are only logged.
+func runStopCommands(progs []*program) {
+
+	var wg sync.WaitGroup
+
+	for _, p := range progs {
+
+		if p.opts.execStop == "" || p.cmd == nil || p.cmd.Process == nil || p.exited {
+			continue
+		}
+
+		wg.Add(1)
+		go func(p *program) {
+			defer wg.Done()
+			err := p.runStopCommand()
+			if err != nil {
+				logWarn("stop command for %s failed, falling back to signals: %s", p.path, err.Error())
+				return
+			}
+			logAlways("stop command for %s finished", p.path)
+		}(p)
+
+	}
+
+	wg.Wait()
+
+}
+
+// stopOrder returns the programs in reverse launch order, programs of later
+// phases first
+func stopOrder(progs []*program) []*program {
+
+	var order []*program
+
+	for ph := phaseFinal; ph >= phasePreNetwork; ph-- {
+		pp := programsInPhase(progs, ph)
+		for i := len(pp) - 1; i >= 0; i-- {
+			order = append(order, pp[i])
+		}
+	}
+
+	return order
+}
+
+// stop sends the stop signal to the program and kills it if it has not
+// exited within the stop timeout
+func (p *program) stop() {
+
+	if p.cmd == nil || p.cmd.Process == nil || p.exited {
+		return
+	}
+
+	done := p.done
+
+	sig := p.opts.stopSignal
+	if sig == 0 {
+		sig = syscall.SIGTERM
+	}
+
+	logDebug("stopping %s with %s", p.path, unix.SignalName(sig))
+	p.cmd.Process.Signal(sig)
+
+	select {
+	case <-done:
+		return
+	case <-time.After(p.opts.stopTimeout):
+	}
+
+	logWarn("%s did not stop within %v, killing", p.path, p.opts.stopTimeout)
+	p.cmd.Process.Signal(syscall.SIGKILL)
+
+	<-done
+
+}
+
+// stopInOrder stops the programs one after another in reverse launch order
+// so programs can rely on the ones started before them until they exit
+func stopInOrder(progs []*program) {
+	for _, p := range stopOrder(progs) {
+		p.stop()
+	}
+}
@@ -0,0 +1,188 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mdArray is a software raid from the vinitd.md kernel argument
+type mdArray struct {
+	device  string
+	members []string
+}
+
+func devPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join("/dev", name)
+}
+
+// parseMDArrays reads a comma separated list of name:member+member, e.g.
+// md0:vdb+vdc
+func parseMDArrays(value string) ([]mdArray, error) {
+
+	var arrays []mdArray
+
+	for _, a := range strings.Split(value, ",") {
+
+		nm := strings.SplitN(a, ":", 2)
+		if len(nm) != 2 || nm[0] == "" || nm[1] == "" {
+			return nil, fmt.Errorf("array '%s' not in format name:member+member", a)
+		}
+
+		md := mdArray{
+			device: devPath(nm[0]),
+		}
+
+		for _, m := range strings.Split(nm[1], "+") {
+			if m == "" {
+				return nil, fmt.Errorf("array '%s' has an empty member", a)
+			}
+			md.members = append(md.members, devPath(m))
+		}
+
+		arrays = append(arrays, md)
+	}
+
+	return arrays, nil
+}
+
+func parseVolumeGroups(value string) ([]string, error) {
+
+	var vgs []string
+
+	for _, vg := range strings.Split(value, ",") {
+		if vg == "" || strings.ContainsAny(vg, "/ ") {
+			return nil, fmt.Errorf("invalid volume group '%s'", vg)
+		}
+		vgs = append(vgs, vg)
+	}
+
+	return vgs, nil
+}
+
+// waitForDevices waits until all devices exist or the timeout has passed
+func waitForDevices(devs []string, timeout time.Duration) error {
+
+	start := time.Now()
+
+	for {
+
+		var missing []string
+		for _, d := range devs {
+			if _, err := os.Stat(d); err != nil {
+				missing = append(missing, d)
+			}
+		}
+
+		if len(missing) == 0 {
+			return nil
+		}
+
+		if time.Since(start) > timeout {
+			return fmt.Errorf("devices %s did not appear in %v", strings.Join(missing, ", "), timeout)
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+
+}
+
+func runTool(name string, args ...string) (string, error) {
+
+	tool := findTool(name)
+	if tool == "" {
+		return "", fmt.Errorf("%s not available", name)
+	}
+
+	out, err := outputReaped(exec.Command(tool, args...))
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %s %s", name, strings.Join(args, " "),
+			err.Error(), strings.TrimSpace(string(out)))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func mdInfo(dev string) string {
+
+	sys := filepath.Join("/sys/block", filepath.Base(dev))
+
+	read := func(f string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(sys, f))
+		return strings.TrimSpace(string(b))
+	}
+
+	var sectors int64
+	fmt.Sscanf(read("size"), "%d", &sectors)
+
+	return fmt.Sprintf("%s, %s disks, %d MB", read("md/level"), read("md/raid_disks"),
+		sectors*512/(1024*1024))
+}
+
+// assembleStorage assembles the configured md arrays and activates the lvm
+// volume groups which might live on them
+func assembleStorage(arrays []mdArray, vgs []string, timeout time.Duration) error {
+
+	for _, md := range arrays {
+
+		err := waitForDevices(md.members, timeout)
+		if err != nil {
+			return fmt.Errorf("can not assemble %s: %s", md.device, err.Error())
+		}
+
+		_, err = runTool("mdadm", append([]string{"--assemble", md.device}, md.members...)...)
+		if err != nil {
+			return err
+		}
+
+		logAlways("assembled %s from %s: %s", md.device, strings.Join(md.members, ", "), mdInfo(md.device))
+	}
+
+	for _, vg := range vgs {
+
+		_, err := runTool("lvm", "vgchange", "-ay", vg)
+		if err != nil {
+			return err
+		}
+
+		lvs, err := runTool("lvm", "lvs", "--noheadings", "-o", "lv_name,lv_size", vg)
+		if err != nil {
+			logWarn("can not list volumes of %s: %s", vg, err.Error())
+		}
+		logAlways("activated volume group %s: %s", vg, strings.Join(strings.Fields(lvs), " "))
+	}
+
+	return nil
+}
+
+// teardownStorage deactivates volume groups and stops arrays on shutdown.
+// Filesystems still in use keep them busy which is only logged.
+func teardownStorage(arrays []mdArray, vgs []string) {
+
+	for _, vg := range vgs {
+		_, err := runTool("lvm", "vgchange", "-an", vg)
+		if err != nil {
+			logDebug("can not deactivate %s: %s", vg, err.Error())
+		}
+	}
+
+	for _, md := range arrays {
+		_, err := runTool("mdadm", "--stop", md.device)
+		if err != nil {
+			logDebug("can not stop %s: %s", md.device, err.Error())
+		}
+	}
+
+}
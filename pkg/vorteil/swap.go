@@ -0,0 +1,256 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	swapMagic = "SWAPSPACE2"
+
+	// space left on the filesystem after creating a swap file
+	swapMinFree = 64 * 1024 * 1024
+
+	// smallest swap area mkswap creates
+	swapMinPages = 10
+)
+
+// swapConfig is the swap partition or file from the vinitd.swap kernel
+// argument. Files with a size get created if they do not exist.
+type swapConfig struct {
+	path string
+	size uint64
+}
+
+var (
+	// bytes with optional k, m or g suffix
+	swapSizeRegex = regexp.MustCompile(`^([0-9]+)([kKmMgG]?)$`)
+
+	// replaced in tests
+	swapOn  = swapon
+	swapOff = swapoff
+
+	// enabled swap, turned off on shutdown
+	activeSwap string
+)
+
+func swapon(path string, flags int) error {
+
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	_, _, e := unix.Syscall(unix.SYS_SWAPON, uintptr(unsafe.Pointer(p)), uintptr(flags), 0)
+	if e != 0 {
+		return e
+	}
+
+	return nil
+}
+
+func swapoff(path string) error {
+
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	_, _, e := unix.Syscall(unix.SYS_SWAPOFF, uintptr(unsafe.Pointer(p)), 0, 0)
+	if e != 0 {
+		return e
+	}
+
+	return nil
+}
+
+// parseSwap reads path[:size]
+func parseSwap(value string) (*swapConfig, error) {
+
+	ps := strings.SplitN(value, ":", 2)
+
+	s := &swapConfig{
+		path: filepath.Clean(ps[0]),
+	}
+
+	if !filepath.IsAbs(ps[0]) || s.path == "/" {
+		return nil, fmt.Errorf("invalid swap path '%s'", ps[0])
+	}
+
+	if len(ps) == 1 {
+		return s, nil
+	}
+
+	m := swapSizeRegex.FindStringSubmatch(ps[1])
+	if m == nil {
+		return nil, fmt.Errorf("invalid swap size '%s'", ps[1])
+	}
+
+	s.size, _ = strconv.ParseUint(m[1], 10, 64)
+	switch strings.ToLower(m[2]) {
+	case "k":
+		s.size *= 1024
+	case "m":
+		s.size *= 1024 * 1024
+	case "g":
+		s.size *= 1024 * 1024 * 1024
+	}
+
+	if s.size < swapMinPages*uint64(os.Getpagesize()) {
+		return nil, fmt.Errorf("swap size '%s' too small", ps[1])
+	}
+
+	return s, nil
+}
+
+// swapHeader returns the first page of a swap area of the size
+func swapHeader(size uint64, pageSize int) []byte {
+
+	h := make([]byte, pageSize)
+
+	// version, last page and number of bad pages after the boot block
+	binary.LittleEndian.PutUint32(h[1024:], 1)
+	binary.LittleEndian.PutUint32(h[1028:], uint32(size/uint64(pageSize)-1))
+
+	copy(h[pageSize-len(swapMagic):], swapMagic)
+
+	return h
+}
+
+// isSwap checks for the swap signature at the end of the first page
+func isSwap(path string) bool {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	ps := os.Getpagesize()
+	b := make([]byte, len(swapMagic))
+	_, err = f.ReadAt(b, int64(ps-len(swapMagic)))
+
+	return err == nil && string(b) == swapMagic
+}
+
+// swapFits checks if the filesystem has space for a swap file and the
+// minimum free space afterwards
+func swapFits(size, avail uint64) error {
+	if size+swapMinFree > avail {
+		return fmt.Errorf("%d MB swap file does not fit, %d MB available",
+			size/(1024*1024), avail/(1024*1024))
+	}
+	return nil
+}
+
+// createSwapFile creates a swap file of the size. An existing swap file of
+// that size is used as it is.
+func createSwapFile(path string, size uint64) error {
+
+	fi, err := os.Stat(path)
+	if err == nil && uint64(fi.Size()) == size && isSwap(path) {
+		return nil
+	}
+
+	var s unix.Statfs_t
+	err = unix.Statfs(filepath.Dir(path), &s)
+	if err != nil {
+		return err
+	}
+
+	avail := s.Bavail * uint64(s.Bsize)
+	if fi != nil {
+		avail += uint64(fi.Size())
+	}
+
+	err = swapFits(size, avail)
+	if err != nil {
+		return err
+	}
+
+	logDebug("creating %d MB swap file %s", size/(1024*1024), path)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// swap files must not have holes
+	err = unix.Fallocate(int(f.Fd()), 0, 0, int64(size))
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	_, err = f.WriteAt(swapHeader(size, os.Getpagesize()), 0)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return f.Sync()
+}
+
+// setupSwap enables the configured swap partition or file
+func setupSwap(s *swapConfig) error {
+
+	if s == nil {
+		return nil
+	}
+
+	if s.size > 0 {
+		err := createSwapFile(s.path, s.size)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !isSwap(s.path) {
+		return fmt.Errorf("%s is not a swap area", s.path)
+	}
+
+	err := swapOn(s.path, 0)
+	if err != nil {
+		return err
+	}
+	activeSwap = s.path
+
+	var info unix.Sysinfo_t
+	if unix.Sysinfo(&info) == nil {
+		logAlways("swap enabled on %s, %d MB", s.path,
+			uint64(info.Totalswap)*uint64(info.Unit)/(1024*1024))
+	} else {
+		logAlways("swap enabled on %s", s.path)
+	}
+
+	return nil
+}
+
+// disableSwap turns off swap before the filesystems are remounted read-only
+func disableSwap() {
+
+	if activeSwap == "" {
+		return
+	}
+
+	err := swapOff(activeSwap)
+	if err != nil {
+		logWarn("can not disable swap on %s: %s", activeSwap, err.Error())
+		return
+	}
+	activeSwap = ""
+}
@@ -0,0 +1,91 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSwap(t *testing.T) {
+
+	s, err := parseSwap("/dev/vdb")
+	assert.NoError(t, err)
+	assert.Equal(t, &swapConfig{path: "/dev/vdb"}, s)
+
+	s, err = parseSwap("/swapfile:512m")
+	assert.NoError(t, err)
+	assert.Equal(t, &swapConfig{path: "/swapfile", size: 512 * 1024 * 1024}, s)
+
+	for _, v := range []string{"swapfile", "/", "/swapfile:1x", "/swapfile:1k"} {
+		_, err = parseSwap(v)
+		assert.Error(t, err, v)
+	}
+
+}
+
+func TestSwapFits(t *testing.T) {
+
+	assert.NoError(t, swapFits(1<<30, 1<<31))
+	assert.NoError(t, swapFits(1<<30, 1<<30+swapMinFree))
+	assert.Error(t, swapFits(1<<30, 1<<30+swapMinFree-1))
+
+}
+
+func TestSetupSwap(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "swap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	on, off := swapOn, swapOff
+	defer func() {
+		swapOn, swapOff = on, off
+		activeSwap = ""
+	}()
+
+	var enabled []string
+	swapOn = func(path string, flags int) error {
+		enabled = append(enabled, path)
+		return nil
+	}
+	swapOff = func(path string) error {
+		enabled = enabled[:0]
+		return nil
+	}
+
+	size := uint64(64 * os.Getpagesize())
+	p := filepath.Join(dir, "swapfile")
+
+	assert.NoError(t, setupSwap(&swapConfig{path: p, size: size}))
+	assert.Equal(t, []string{p}, enabled)
+	assert.True(t, isSwap(p))
+
+	fi, err := os.Stat(p)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(size), fi.Size())
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	disableSwap()
+	assert.Empty(t, enabled)
+	assert.Equal(t, "", activeSwap)
+
+	// the existing file is used again
+	mt := fi.ModTime()
+	assert.NoError(t, setupSwap(&swapConfig{path: p, size: size}))
+	fi, _ = os.Stat(p)
+	assert.Equal(t, mt, fi.ModTime())
+
+	// files without signature are not enabled
+	np := filepath.Join(dir, "other")
+	assert.NoError(t, ioutil.WriteFile(np, make([]byte, size), 0600))
+	assert.Error(t, setupSwap(&swapConfig{path: np}))
+
+	// too big for the filesystem
+	assert.Error(t, setupSwap(&swapConfig{path: filepath.Join(dir, "big"), size: 1 << 60}))
+
+}
@@ -0,0 +1,138 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syslogAppName = "vinitd"
+
+	// daemon facility
+	syslogFacility = 3
+
+	// connecting or sending must never hold up boot
+	syslogTimeout = time.Second
+
+	// time before connecting again after a failure
+	syslogRetry = 10 * time.Second
+)
+
+// syslogSink sends messages to a remote syslog collector. Messages which
+// can not be sent go to the local log instead.
+type syslogSink struct {
+	lock    sync.Mutex
+	network string
+	addr    string
+	conn    net.Conn
+	failed  time.Time
+
+	local logFn
+}
+
+// parseSyslogAddr reads udp://host:port, tcp://host:port or host:port which
+// uses udp
+func parseSyslogAddr(value string) (string, string, error) {
+
+	network := "udp"
+
+	kv := strings.SplitN(value, "://", 2)
+	if len(kv) == 2 {
+		network = kv[0]
+		value = kv[1]
+	}
+
+	if network != "udp" && network != "tcp" {
+		return "", "", fmt.Errorf("unsupported network %s", network)
+	}
+
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		return "", "", err
+	}
+
+	return network, value, nil
+}
+
+func newSyslogSink(network, addr string, local logFn) *syslogSink {
+	return &syslogSink{
+		network: network,
+		addr:    addr,
+		local:   local,
+	}
+}
+
+// syslogSeverity maps log levels to syslog severities. Messages for the
+// screen are errors.
+func syslogSeverity(level LogLevel) int {
+	if level > LogLvDEBUG {
+		return int(LogLvERR)
+	}
+	return int(level)
+}
+
+// syslogFrame formats the message as RFC 5424 syslog message
+func syslogFrame(level LogLevel, now time.Time, hostname, msg string) string {
+
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s", syslogFacility*8+syslogSeverity(level),
+		now.UTC().Format(time.RFC3339Nano), hostname, syslogAppName, os.Getpid(), msg)
+}
+
+func (s *syslogSink) send(frame string) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+
+		if !s.failed.IsZero() && time.Since(s.failed) < syslogRetry {
+			return fmt.Errorf("not connected")
+		}
+
+		c, err := net.DialTimeout(s.network, s.addr, syslogTimeout)
+		if err != nil {
+			s.failed = time.Now()
+			return err
+		}
+		s.conn = c
+	}
+
+	// octet counting framing for streams
+	if s.network == "tcp" {
+		frame = fmt.Sprintf("%d %s", len(frame), frame)
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err := s.conn.Write([]byte(frame))
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.failed = time.Now()
+	}
+
+	return err
+}
+
+// log is the logFn of the sink. Screen messages are always shown locally.
+func (s *syslogSink) log(level LogLevel, format string, values ...interface{}) {
+
+	hn, _ := os.Hostname()
+	err := s.send(syslogFrame(level, time.Now(), hn, fmt.Sprintf(format, values...)))
+
+	if err != nil || level == LogLvSTDERR {
+		s.local(level, format, values...)
+	}
+
+}
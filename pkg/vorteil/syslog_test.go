@@ -0,0 +1,99 @@
+package vorteil
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogSink(t *testing.T) {
+
+	New(testLogFn)
+
+	n, a, err := parseSyslogAddr("10.0.0.1:514")
+	assert.NoError(t, err)
+	assert.Equal(t, "udp", n)
+	assert.Equal(t, "10.0.0.1:514", a)
+	n, _, err = parseSyslogAddr("tcp://collector:601")
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp", n)
+	_, _, err = parseSyslogAddr("http://collector:601")
+	assert.Error(t, err)
+	_, _, err = parseSyslogAddr("collector")
+	assert.Error(t, err)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	var local []LogLevel
+	s := newSyslogSink("udp", pc.LocalAddr().String(), func(level LogLevel, format string, values ...interface{}) {
+		local = append(local, level)
+	})
+
+	s.log(LogLvWARNING, "disk %s full", "sda")
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	l, _, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+
+	hn, _ := os.Hostname()
+	re := regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) vinitd (\d+) - - (.*)$`)
+	m := re.FindStringSubmatch(string(buf[:l]))
+	assert.Len(t, m, 6)
+	assert.Equal(t, "28", m[1])
+	_, err = time.Parse(time.RFC3339Nano, m[2])
+	assert.NoError(t, err)
+	assert.Equal(t, hn, m[3])
+	assert.Equal(t, strconv.Itoa(os.Getpid()), m[4])
+	assert.Equal(t, "disk sda full", m[5])
+
+	// screen messages are shown locally as well
+	assert.Empty(t, local)
+	s.log(LogLvSTDERR, "error")
+	assert.Equal(t, []LogLevel{LogLvSTDERR}, local)
+	assert.Equal(t, 27, syslogFacility*8+syslogSeverity(LogLvSTDERR))
+
+	// tcp with octet counting
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	frames := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var size int
+		r := bufio.NewReader(c)
+		fmt.Fscanf(r, "%d ", &size)
+		b := make([]byte, size)
+		r.Read(b)
+		frames <- string(b)
+	}()
+
+	s = newSyslogSink("tcp", ln.Addr().String(), testLogFn)
+	s.log(LogLvINFO, "hello")
+	assert.Regexp(t, `^<30>1 .* vinitd \d+ - - hello$`, <-frames)
+
+	// unreachable collectors fall back to the local log
+	local = nil
+	s = newSyslogSink("tcp", ln.Addr().String(), func(level LogLevel, format string, values ...interface{}) {
+		local = append(local, level)
+	})
+	ln.Close()
+	s.log(LogLvINFO, "lost")
+	s.log(LogLvINFO, "lost")
+	assert.Equal(t, []LogLevel{LogLvINFO, LogLvINFO}, local)
+
+}
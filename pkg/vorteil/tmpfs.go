@@ -0,0 +1,117 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// tmpfsMount is a size limited tmpfs from the vinitd.tmpfs kernel argument
+type tmpfsMount struct {
+	path string
+	size string
+}
+
+var (
+	// tmpfs accepts sizes in bytes with suffix or as percentage of memory
+	tmpfsSizeRegex = regexp.MustCompile(`^[0-9]+[kKmMgG%]?$`)
+
+	tmpfsDefaultSizes = map[string]string{
+		"/tmp":     "25%",
+		"/run":     "10%",
+		"/dev/shm": "25%",
+	}
+)
+
+const tmpfsDefaultSize = "10%"
+
+// parseTmpfsMounts reads a comma separated list of path[:size]
+func parseTmpfsMounts(value string) ([]tmpfsMount, error) {
+
+	var mounts []tmpfsMount
+
+	for _, m := range strings.Split(value, ",") {
+
+		ps := strings.SplitN(m, ":", 2)
+		t := tmpfsMount{
+			path: filepath.Clean(ps[0]),
+		}
+
+		if !filepath.IsAbs(ps[0]) || t.path == "/" {
+			return nil, fmt.Errorf("invalid tmpfs path '%s'", ps[0])
+		}
+
+		t.size = tmpfsDefaultSizes[t.path]
+		if t.size == "" {
+			t.size = tmpfsDefaultSize
+		}
+
+		if len(ps) == 2 {
+			if !tmpfsSizeRegex.MatchString(ps[1]) {
+				return nil, fmt.Errorf("invalid tmpfs size '%s'", ps[1])
+			}
+			t.size = ps[1]
+		}
+
+		mounts = append(mounts, t)
+	}
+
+	return mounts, nil
+}
+
+func (t tmpfsMount) options(inodes int) string {
+
+	mode := "0755"
+	if t.path == "/tmp" || t.path == "/dev/shm" {
+		mode = "1777"
+	}
+
+	opts := fmt.Sprintf("size=%s,mode=%s", t.size, mode)
+	if inodes > 0 {
+		opts = fmt.Sprintf("%s,nr_inodes=%d", opts, inodes)
+	}
+
+	return opts
+}
+
+// setupTmpfs mounts the configured tmpfs filesystems before anything writes
+// to them
+func setupTmpfs(mounts []tmpfsMount, inodes int) {
+
+	for _, t := range mounts {
+
+		err := mountFs(t.path, "tmpfs", t.options(inodes))
+		if err != nil {
+			logError("can not mount tmpfs on %s: %s", t.path, err.Error())
+			continue
+		}
+
+		var s syscall.Statfs_t
+		err = syscall.Statfs(t.path, &s)
+		if err != nil {
+			logDebug("mounted tmpfs on %s", t.path)
+			continue
+		}
+
+		logDebug("mounted tmpfs on %s, %d MB, %d inodes", t.path,
+			uint64(s.Blocks)*uint64(s.Bsize)/(1024*1024), s.Files)
+
+	}
+
+}
+
+func tmpfsConfigured(path string) bool {
+	for _, t := range kernelOpts.tmpfs {
+		if t.path == path {
+			return true
+		}
+	}
+	return false
+}
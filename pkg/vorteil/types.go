@@ -8,6 +8,8 @@ package vorteil
 import (
 	"net"
 	"os/exec"
+	"sync"
+	"time"
 
 	"github.com/vorteil/vorteil/pkg/vcfg"
 )
@@ -107,6 +109,10 @@ type ifc struct {
 	netIfc net.Interface
 	addr   *net.IPNet
 	gw     net.IP
+
+	// static ipv6 configuration
+	addr6 []*net.IPNet
+	gw6   net.IP
 }
 
 type hv struct {
@@ -118,7 +124,20 @@ type hv struct {
 // Vinitd contains all information to run and manage this instance
 type Vinitd struct {
 	diskname string
-	hostname string
+
+	// changed by dhcp, read on the control socket
+	hostname     string
+	hostnameLock sync.Mutex
+
+	// set during setup, read on the control socket
+	machineID     string
+	machineIDLock sync.Mutex
+
+	// connected to the control socket
+	controlClients int32
+
+	// offered by dhcp, used if the configuration has no hostname
+	dhcpHostname string
 
 	// user running applications
 	user string
@@ -128,18 +147,35 @@ type Vinitd struct {
 	vcfg vcfg.VCFG
 
 	// programs to run
-	programs []*program
+	programs     []*program
+	programsLock sync.Mutex
+
+	// process tracking and launch throttling, started with the first program
+	trackingOnce sync.Once
+	gate         *launchGate
 
 	// interfaces list
 	ifcs map[string]*ifc
 
-	// configured dns servers
-	dns []net.IP
+	// closed once the network is ready
+	netReady chan struct{}
+
+	// configured dns servers and search domains
+	dns       []net.IP
+	dnsSearch []string
 }
 
 type program struct {
+	// identifies the program definition for reloads
+	key string
+
+	// removed from the configuration, do not start again. guarded by the
+	// programs lock of vinitd
+	removed bool
+
 	path     string
 	vcfgProg vcfg.Program
+	opts     programOptions
 
 	env  []string
 	args []string
@@ -148,6 +184,47 @@ type program struct {
 	// cmd.Process is not nil once started. app counter uses this
 	cmd *exec.Cmd
 
+	// set once the process has been waited for
+	exited bool
+
+	// could not be started and does not get restarted
+	failed bool
+
+	// passed its probe or kept running, reset on every launch
+	started bool
+
+	// exits and restarts since boot
+	status     programStatus
+	statusLock sync.Mutex
+
+	// closed once the process has been waited for
+	done chan struct{}
+
+	// cgroup directory of the running process
+	cgroup string
+
+	// closed once the program has been launched the first time, readyErr
+	// is set if it never will be
+	ready     chan struct{}
+	readyOnce sync.Once
+	readyErr  error
+
+	// first launch and when the program got ready
+	launchedAt time.Time
+	readyAt    time.Time
+
+	// exited and about to be launched again
+	restarting bool
+	backoff    *backoff
+	crashLoop  *restartGuard
+
+	// restart asked for on the control socket, regardless of the policy
+	restartRequested bool
+
+	// init of the group's pid namespace joined, 0 if the program runs the
+	// shim itself
+	joinPID int
+
 	vinitd *Vinitd
 }
 
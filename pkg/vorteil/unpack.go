@@ -0,0 +1,198 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// marker in the target directory, the archive is not extracted again
+	unpackMarker = ".vinitd-unpacked"
+
+	// progress is only logged for large archives
+	unpackProgressSize = 10 * 1024 * 1024
+)
+
+// one extraction at a time, programs can share archives
+var unpackLock sync.Mutex
+
+// progressReader logs every 10% of the archive read
+type progressReader struct {
+	r    io.Reader
+	name string
+	size int64
+	read int64
+	step int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	if p.size > unpackProgressSize && p.read*10/p.size > p.step {
+		p.step = p.read * 10 / p.size
+		logAlways("extracting %s: %d%%", p.name, p.step*10)
+	}
+
+	return n, err
+}
+
+func fileSHA256(path string) (string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// safeJoin returns the path of an archive entry in the target directory and
+// rejects entries outside of it
+func safeJoin(target, name string) (string, error) {
+
+	p := filepath.Join(target, name)
+	if p != filepath.Clean(target) && !strings.HasPrefix(p, filepath.Clean(target)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %s outside of %s", name, target)
+	}
+
+	return p, nil
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, path string) error {
+
+	mode := os.FileMode(hdr.Mode).Perm()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		err := os.MkdirAll(path, mode)
+		if err != nil {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		os.Remove(path)
+		err := os.Symlink(hdr.Linkname, path)
+		if err != nil {
+			return err
+		}
+	default:
+		logDebug("skipping %s, unsupported type %c", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+
+	os.Lchown(path, hdr.Uid, hdr.Gid)
+
+	return nil
+}
+
+// unpackArchive extracts a tar or tar.gz archive into the target directory.
+// If a checksum is provided the archive is verified before extraction. A
+// marker file makes sure it is only extracted once.
+func unpackArchive(archive, target, checksum string) error {
+
+	unpackLock.Lock()
+	defer unpackLock.Unlock()
+
+	marker := filepath.Join(target, unpackMarker)
+	if _, err := os.Stat(marker); err == nil {
+		logDebug("%s already extracted to %s", archive, target)
+		return nil
+	}
+
+	if checksum != "" {
+		sum, err := fileSHA256(archive)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, checksum) {
+			return fmt.Errorf("checksum mismatch for %s, expected %s, got %s", archive, checksum, sum)
+		}
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = &progressReader{
+		r:    f,
+		name: archive,
+		size: fi.Size(),
+	}
+
+	if strings.HasSuffix(archive, ".gz") || strings.HasSuffix(archive, ".tgz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	logAlways("extracting %s to %s", archive, target)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("can not read %s: %s", archive, err.Error())
+		}
+
+		path, err := safeJoin(target, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		err = extractEntry(tr, hdr, path)
+		if err != nil {
+			return fmt.Errorf("can not extract %s: %s", hdr.Name, err.Error())
+		}
+	}
+
+	return ioutil.WriteFile(marker, []byte(archive), 0644)
+}
@@ -0,0 +1,87 @@
+package vorteil
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestArchive(t *testing.T, path string, files map[string]string) string {
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for n, c := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     n,
+			Mode:     0644,
+			Size:     int64(len(c)),
+			Typeflag: tar.TypeReg,
+			Uid:      os.Getuid(),
+			Gid:      os.Getgid(),
+		}))
+		_, err := tw.Write([]byte(c))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUnpackArchive(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "unpack")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "assets.tar.gz")
+	target := filepath.Join(dir, "assets")
+
+	sum := writeTestArchive(t, archive, map[string]string{
+		"index.html":    "hello",
+		"css/style.css": "body {}",
+	})
+
+	// wrong checksum extracts nothing
+	err = unpackArchive(archive, target, "00ff")
+	assert.Error(t, err)
+	_, err = os.Stat(target)
+	assert.True(t, os.IsNotExist(err))
+
+	err = unpackArchive(archive, target, sum)
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(target, "css/style.css"))
+	assert.NoError(t, err)
+	assert.Equal(t, "body {}", string(b))
+
+	// marker prevents a second extraction
+	os.Remove(filepath.Join(target, "index.html"))
+	err = unpackArchive(archive, target, sum)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(target, "index.html"))
+	assert.True(t, os.IsNotExist(err))
+
+	// entries outside of the target are rejected
+	writeTestArchive(t, archive, map[string]string{"../escape": "x"})
+	err = unpackArchive(archive, filepath.Join(dir, "other"), "")
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(dir, "escape"))
+	assert.True(t, os.IsNotExist(err))
+
+}
@@ -0,0 +1,136 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// lookupEntry finds the line with the name or id in a passwd or group file
+// and returns its fields
+func lookupEntry(file, nameOrID string) ([]string, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fs := strings.Split(s.Text(), ":")
+		if len(fs) < 4 {
+			continue
+		}
+		if fs[0] == nameOrID || fs[2] == nameOrID {
+			return fs, nil
+		}
+	}
+
+	return nil, s.Err()
+}
+
+// lookupUser returns the uid and primary gid of a user name or uid. Numeric
+// ids do not need to exist, their gid is the same as the uid then.
+func lookupUser(user string) (uint32, uint32, error) {
+
+	fs, err := lookupEntry(passwdFile, user)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can not read users: %s", err.Error())
+	}
+
+	if fs == nil {
+		id, err := strconv.ParseUint(user, 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("user %s does not exist", user)
+		}
+		return uint32(id), uint32(id), nil
+	}
+
+	uid, err := strconv.ParseUint(fs[2], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid for user %s", user)
+	}
+
+	gid, err := strconv.ParseUint(fs[3], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gid for user %s", user)
+	}
+
+	return uint32(uid), uint32(gid), nil
+}
+
+// lookupGroup returns the gid of a group name or gid
+func lookupGroup(group string) (uint32, error) {
+
+	fs, err := lookupEntry(groupFile, group)
+	if err != nil {
+		return 0, fmt.Errorf("can not read groups: %s", err.Error())
+	}
+
+	if fs == nil {
+		id, err := strconv.ParseUint(group, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("group %s does not exist", group)
+		}
+		return uint32(id), nil
+	}
+
+	gid, err := strconv.ParseUint(fs[2], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gid for group %s", group)
+	}
+
+	return uint32(gid), nil
+}
+
+// credential returns the user and groups configured for the program or nil
+// if the privilege setting of vcfg applies
+func (o programOptions) credential() (*syscall.Credential, error) {
+
+	if o.user == "" && o.group == "" && len(o.groups) == 0 {
+		return nil, nil
+	}
+
+	var (
+		c   syscall.Credential
+		err error
+	)
+
+	if o.user != "" {
+		c.Uid, c.Gid, err = lookupUser(o.user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.group != "" {
+		c.Gid, err = lookupGroup(o.group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, g := range o.groups {
+		gid, err := lookupGroup(g)
+		if err != nil {
+			return nil, err
+		}
+		c.Groups = append(c.Groups, gid)
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,64 @@
+package vorteil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgramCredential(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "user")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	passwdFile = filepath.Join(dir, "passwd")
+	groupFile = filepath.Join(dir, "group")
+	defer func() {
+		passwdFile = "/etc/passwd"
+		groupFile = "/etc/group"
+	}()
+
+	ioutil.WriteFile(passwdFile, []byte("root:x:0:0:root:/:/bin/false\napp:x:1000:1000:app:/:/bin/false\n"), 0644)
+	ioutil.WriteFile(groupFile, []byte("root:x:0:root\napp:x:1000:app\nlogs:x:1500:\n"), 0644)
+
+	c, err := programOptions{}.credential()
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	c, err = programOptions{user: "app", groups: []string{"logs", "2000"}}.credential()
+	assert.NoError(t, err)
+	assert.Equal(t, &syscall.Credential{Uid: 1000, Gid: 1000, Groups: []uint32{1500, 2000}}, c)
+
+	c, err = programOptions{user: "1234", group: "logs"}.credential()
+	assert.NoError(t, err)
+	assert.Equal(t, &syscall.Credential{Uid: 1234, Gid: 1500}, c)
+
+	_, err = programOptions{user: "missing"}.credential()
+	assert.EqualError(t, err, "user missing does not exist")
+
+	_, err = programOptions{user: "app", group: "missing"}.credential()
+	assert.Error(t, err)
+
+	if os.Getuid() != 0 {
+		t.Skip("dropping privileges needs root")
+	}
+
+	c, err = programOptions{user: "app", groups: []string{"logs"}}.credential()
+	assert.NoError(t, err)
+
+	cmd := exec.Command("sh", "-c", "id -u; id -g; id -G")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: c}
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1000", "1000", "1000", "1500"}, strings.Fields(string(out)))
+
+}
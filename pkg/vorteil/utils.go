@@ -7,16 +7,20 @@ package vorteil
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"strconv"
 	"strings"
-)
+	"time"
+	"unsafe"
 
-const (
-	procFile = "/proc/uptime"
+	"golang.org/x/sys/unix"
 )
 
+// replaced in tests
+var procFile = "/proc/uptime"
+
 func uniqueIPs(ipSlice []net.IP) []net.IP {
 	keys := make(map[string]bool)
 	list := []net.IP{}
@@ -29,6 +33,18 @@ func uniqueIPs(ipSlice []net.IP) []net.IP {
 	return list
 }
 
+func uniqueStrings(s []string) []string {
+	keys := make(map[string]bool)
+	list := []string{}
+	for _, entry := range s {
+		if !keys[entry] {
+			keys[entry] = true
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
 func min(x, y uint32) uint32 {
 	if x < y {
 		return x
@@ -43,26 +59,97 @@ func max(x, y int) int {
 	return x
 }
 
-func ip2networkInt(ip net.IP) uint32 {
-	if len(ip) == 16 {
-		return binary.LittleEndian.Uint32(ip[12:16])
+// nativeEndian is the byte order of the host
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+func ipv4Bytes(ip net.IP) (net.IP, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("%s is not an ipv4 address", ip)
+	}
+	return ip4, nil
+}
+
+// ip2networkInt returns the IPv4 address in network byte order, i.e. the
+// value of s_addr in a sockaddr_in. Its bytes in memory are the bytes of the
+// address on every host. IPv6 addresses do not fit and return an error.
+func ip2networkInt(ip net.IP) (uint32, error) {
+	ip4, err := ipv4Bytes(ip)
+	if err != nil {
+		return 0, err
 	}
-	return binary.LittleEndian.Uint32(ip)
+	return nativeEndian.Uint32(ip4), nil
 }
 
+// vinitd's start, used if the monotonic clock can not be read
+var startTime = time.Now()
+
+// uptime returns the seconds since boot from the monotonic clock, no file
+// has to be read for every log message
 func uptime() float64 {
 
-	up, err := ioutil.ReadFile(procFile)
+	var ts unix.Timespec
+	err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	if err != nil {
+		return time.Since(startTime).Seconds()
+	}
+
+	return float64(ts.Sec) + float64(ts.Nsec)/1e9
+}
+
+// parseUptime returns both values of /proc/uptime. The idle time is the sum
+// of all cpus.
+func parseUptime(content string) (float64, float64, error) {
+
+	f := strings.Fields(content)
+	if len(f) != 2 {
+		return 0, 0, fmt.Errorf("unexpected uptime format '%s'", strings.TrimSpace(content))
+	}
+
+	up, err := strconv.ParseFloat(f[0], 64)
 	if err != nil {
-		return 0.0
+		return 0, 0, err
 	}
 
-	// uptime has two values
-	// the first one is the uptime in seconds
-	f, err := strconv.ParseFloat(strings.SplitN(string(up), " ", 2)[0], 8)
+	idle, err := strconv.ParseFloat(f[1], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return up, idle, nil
+}
+
+// uptimeIdle returns the uptime and the cumulative idle time in seconds
+func uptimeIdle() (float64, float64, error) {
+
+	up, err := ioutil.ReadFile(procFile)
 	if err != nil {
-		return 0.0
+		return 0, 0, err
+	}
+
+	return parseUptime(string(up))
+}
+
+// busyPercent is the average cpu utilization since boot
+func busyPercent(up, idle float64, cpus int) float64 {
+
+	if up <= 0 || cpus <= 0 {
+		return 0
+	}
+
+	b := 100 * (1 - idle/(up*float64(cpus)))
+	if b < 0 {
+		return 0
+	}
+	if b > 100 {
+		return 100
 	}
 
-	return f
+	return b
 }
@@ -0,0 +1,96 @@
+package vorteil
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUptime(t *testing.T) {
+
+	up, idle, err := parseUptime("350735.47 234388.90\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 350735.47, up)
+	assert.Equal(t, 234388.90, idle)
+
+	_, _, err = parseUptime("350735.47")
+	assert.Error(t, err)
+
+	_, _, err = parseUptime("up idle")
+	assert.Error(t, err)
+
+	// idle is the sum of all cpus and can be larger than the uptime
+	up, idle, err = parseUptime("100.00 300.00")
+	assert.NoError(t, err)
+	assert.InDelta(t, 25.0, busyPercent(up, idle, 4), 0.001)
+	assert.InDelta(t, 0.0, busyPercent(up, idle, 2), 0.001)
+
+	assert.InDelta(t, 50.0, busyPercent(100, 50, 1), 0.001)
+	assert.Equal(t, 0.0, busyPercent(0, 0, 1))
+
+}
+
+func TestUptime(t *testing.T) {
+
+	prev := uptime()
+	for i := 0; i < 1000; i++ {
+		up := uptime()
+		assert.True(t, up >= prev)
+		prev = up
+	}
+
+	// sub millisecond precision
+	start := uptime()
+	time.Sleep(10 * time.Millisecond)
+	assert.InDelta(t, 0.010, uptime()-start, 0.009)
+
+	up, _, err := uptimeIdle()
+	assert.NoError(t, err)
+	assert.InDelta(t, up, uptime(), 1)
+
+}
+
+func TestIP2NetworkInt(t *testing.T) {
+
+	// 16 and 4 byte forms of ipv4 addresses are the same
+	n, err := ip2networkInt(net.ParseIP("10.0.2.15"))
+	assert.NoError(t, err)
+	n4, err := ip2networkInt(net.ParseIP("10.0.2.15").To4())
+	assert.NoError(t, err)
+	assert.Equal(t, n, n4)
+	assert.Equal(t, uint32(0x0f02000a), n)
+
+	// ipv4-mapped
+	n, err = ip2networkInt(net.ParseIP("::ffff:10.0.2.15"))
+	assert.NoError(t, err)
+	assert.Equal(t, n4, n)
+
+	// real ipv6 addresses are not truncated
+	_, err = ip2networkInt(net.ParseIP("2001:db8::1"))
+	assert.Error(t, err)
+
+	assert.Len(t, uniqueIPs([]net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::1"),
+		net.ParseIP("10.0.2.15"), net.ParseIP("::ffff:10.0.2.15")}), 2)
+
+}
+
+func TestIPByteOrder(t *testing.T) {
+
+	ip := net.ParseIP("192.168.1.1")
+
+	n, err := ip2networkInt(ip)
+	assert.NoError(t, err)
+
+	// stored as s_addr the bytes are in address order
+	assert.Equal(t, [4]byte{192, 168, 1, 1}, *(*[4]byte)(unsafe.Pointer(&n)))
+	if nativeEndian == binary.LittleEndian {
+		assert.Equal(t, uint32(0x0101a8c0), n)
+	} else {
+		assert.Equal(t, uint32(0xc0a80101), n)
+	}
+
+}
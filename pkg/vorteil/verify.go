@@ -0,0 +1,90 @@
+/**
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2020 vorteil.io Pty Ltd
+ */
+
+package vorteil
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// readBase64 reads a base64 encoded key or signature of the expected size
+func readBase64(path string, size int) ([]byte, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("%s not base64 encoded: %s", path, err.Error())
+	}
+
+	if len(d) != size {
+		return nil, fmt.Errorf("%s has %d bytes, expected %d", path, len(d), size)
+	}
+
+	return d, nil
+}
+
+// verifySignature checks the ed25519 signature of the binary
+func verifySignature(path, sigFile, keyFile string) error {
+
+	if keyFile == "" {
+		return fmt.Errorf("signature for %s but no signing key configured", path)
+	}
+
+	key, err := readBase64(keyFile, ed25519.PublicKeySize)
+	if err != nil {
+		return err
+	}
+
+	sig, err := readBase64(sigFile, ed25519.SignatureSize)
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), b, sig) {
+		return fmt.Errorf("signature %s does not match %s", sigFile, path)
+	}
+
+	return nil
+}
+
+// verifyBinary checks the digest and signature of a program binary if
+// configured
+func verifyBinary(path string, o programOptions, keyFile string) error {
+
+	if o.sha256 != "" {
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, o.sha256) {
+			logError("digest of %s: expected %s, computed %s", path, o.sha256, sum)
+			return fmt.Errorf("digest mismatch for %s", path)
+		}
+		logDebug("digest of %s verified", path)
+	}
+
+	if o.signature != "" {
+		err := verifySignature(path, o.signature, keyFile)
+		if err != nil {
+			return err
+		}
+		logDebug("signature of %s verified", path)
+	}
+
+	return nil
+}
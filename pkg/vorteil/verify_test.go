@@ -0,0 +1,52 @@
+package vorteil
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyBinary(t *testing.T) {
+
+	New(testLogFn)
+
+	dir, err := ioutil.TempDir("", "verify")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bin := filepath.Join(dir, "app")
+	content := []byte("#!/bin/sh\necho app\n")
+	assert.NoError(t, ioutil.WriteFile(bin, content, 0755))
+
+	sum := sha256.Sum256(content)
+
+	assert.NoError(t, verifyBinary(bin, programOptions{}, ""))
+	assert.NoError(t, verifyBinary(bin, programOptions{sha256: hex.EncodeToString(sum[:])}, ""))
+	assert.Error(t, verifyBinary(bin, programOptions{sha256: "00ff"}, ""))
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	key := filepath.Join(dir, "key.pub")
+	sig := filepath.Join(dir, "app.sig")
+	assert.NoError(t, ioutil.WriteFile(key, []byte(base64.StdEncoding.EncodeToString(pub)), 0644))
+	assert.NoError(t, ioutil.WriteFile(sig, []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, content))), 0644))
+
+	o := programOptions{signature: sig}
+	assert.NoError(t, verifyBinary(bin, o, key))
+
+	// no key configured
+	assert.Error(t, verifyBinary(bin, o, ""))
+
+	// modified binary
+	assert.NoError(t, ioutil.WriteFile(bin, append(content, '\n'), 0755))
+	assert.Error(t, verifyBinary(bin, o, key))
+
+}
@@ -26,6 +26,9 @@ const (
 	vcfgSize   = 0x4000
 
 	forcedPoweroffTimeout = 3000
+
+	// time processes get after SIGTERM on shutdown before SIGKILL
+	defaultKillGrace = 5 * time.Second
 )
 
 // New returns a new vinitd object
@@ -34,7 +37,8 @@ func New(logging logFn) *Vinitd {
 	rand.Seed(time.Now().UnixNano())
 
 	v := &Vinitd{
-		ifcs: make(map[string]*ifc),
+		ifcs:     make(map[string]*ifc),
+		netReady: make(chan struct{}),
 	}
 
 	vlog = logging
@@ -46,20 +50,17 @@ func New(logging logFn) *Vinitd {
 
 }
 
-func setupMountOptions(diskname string) error {
+// rootMount returns the device and filesystem type mounted on /
+func rootMount() (string, string, error) {
 
 	var (
 		dev, path, fstype, opts string
 		a, b                    int
-		err                     error
 	)
 
-	// it is always the second partition
-	part := fmt.Sprintf("%s2", diskname)
-
 	file, err := os.Open("/proc/mounts")
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	defer file.Close()
 
@@ -68,39 +69,56 @@ func setupMountOptions(diskname string) error {
 	for s.Scan() {
 		fmt.Sscanf(s.Text(), "%s %s %s %s %d %d", &dev, &path, &fstype, &opts, &a, &b)
 		if path == "/" {
-			logDebug("config %s filesystem on %s, %s", fstype, part, dev)
-			switch fstype {
-			case "ext2":
-				{
-					opts = ""
-				}
-			case "ext4":
-				{
-					opts = "nodiscard,commit=30,inode_readahead_blks=64"
-				}
-			case "xfs":
-				{
-					opts = "nodiscard,attr2,inode64,noquota"
-				}
-			default:
-				{
-					return fmt.Errorf("unknown filesystem format: %s", fstype)
-				}
-			}
-
-			// MS_LAZYTIME 1 << 25
-			logDebug("using fs opts %s", opts)
-			return syscall.Mount(part, "/", fstype, syscall.MS_REMOUNT|syscall.MS_NOATIME|(1<<25), opts)
-
+			return dev, fstype, nil
 		}
 	}
 
 	err = fmt.Errorf("can not find root filesystem")
 
 	if s.Err() != nil {
-		err = fmt.Errorf("could not detect filesystem type: %s", err.Error())
+		err = fmt.Errorf("could not detect filesystem type: %s", s.Err().Error())
+	}
+	return "", "", err
+
+}
+
+// rootMountOptions returns the options the root filesystem gets mounted
+// with after boot
+func rootMountOptions(fstype string) (string, error) {
+
+	switch fstype {
+	case "ext2":
+		return "", nil
+	case "ext4":
+		return "nodiscard,commit=30,inode_readahead_blks=64", nil
+	case "xfs":
+		return "nodiscard,attr2,inode64,noquota", nil
+	}
+
+	return "", fmt.Errorf("unknown filesystem format: %s", fstype)
+}
+
+func setupMountOptions(diskname string) error {
+
+	// it is always the second partition
+	part := fmt.Sprintf("%s2", diskname)
+
+	dev, fstype, err := rootMount()
+	if err != nil {
+		return err
 	}
-	return err
+
+	logDebug("config %s filesystem on %s, %s", fstype, part, dev)
+
+	opts, err := rootMountOptions(fstype)
+	if err != nil {
+		return err
+	}
+
+	logDebug("using fs opts %s", opts)
+	rootDev, rootFSType, rootOpts = part, fstype, opts
+	rootFlags = rootMountFlags
+	return syscall.Mount(part, "/", fstype, syscall.MS_REMOUNT|rootFlags, opts)
 
 }
 
@@ -116,9 +134,28 @@ func (v *Vinitd) PreSetup() error {
 		return err
 	}
 
-	err = growDisks()
-	if err != nil {
-		return err
+	setupKernelOptions()
+
+	// ntp refines the time later if configured
+	setClockFromRTC()
+
+	// as pid 1 all orphans have to be reaped
+	go reapProcs()
+
+	setupTmpfs(kernelOpts.tmpfs, kernelOpts.tmpfsInodes)
+
+	err = fsckBootDisk(kernelOpts.fsck)
+	if err == errFsckReboot {
+		Reboot(err.Error())
+	} else if err != nil {
+		SystemPanic("boot disk check failed: %s", err.Error())
+	}
+
+	if kernelOpts.growRoot {
+		err = growDisks()
+		if err != nil {
+			return err
+		}
 	}
 	logDebug("pre-setup finished successfully")
 
@@ -137,6 +174,30 @@ func (v *Vinitd) PreSetup() error {
 		logError("can not setup mount options: %s", err.Error())
 	}
 
+	openDiskLog()
+
+	err = assembleStorage(kernelOpts.mdArrays, kernelOpts.volumeGroups,
+		time.Duration(kernelOpts.deviceTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	err = setupFstab(fstabFile, time.Duration(kernelOpts.deviceTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	err = setupOverlays(bootOverlays(kernelOpts))
+	if err != nil {
+		return err
+	}
+
+	// the system can run without swap
+	err = setupSwap(kernelOpts.swap)
+	if err != nil {
+		logError("can not enable swap: %s", err.Error())
+	}
+
 	return nil
 
 }
@@ -156,12 +217,14 @@ func (v *Vinitd) Setup() error {
 	setupVtty(v.vcfg.System.StdoutMode)
 
 	go waitForSignal()
+	go v.forwardSignals(kernelOpts.forwardSignals)
 
 	go changeDiskScheduler(v.diskname)
 
-	// power functions
+	// power functions, links created before the root might become read-only
 	go listenToPowerEvent()
-	go prepSbinPower()
+	prepSbinPower()
+	prepSbinFsck()
 
 	syscall.Reboot(syscall.LINUX_REBOOT_CMD_CAD_OFF)
 	printVersion()
@@ -175,12 +238,51 @@ func (v *Vinitd) Setup() error {
 	}
 	logDebug("set hostname to %s", hn)
 
+	for _, p := range v.vcfg.Programs {
+		if _, err := v.prepProgram(p); err != nil {
+			return err
+		}
+	}
+
+	if err := v.sortPrograms(); err != nil {
+		SystemPanic("can not order programs: %s", err.Error())
+	}
+
+	// created before the root might become read-only
+	l, err := listenControl(controlSocket)
+	if err != nil {
+		logWarn("can not create control socket: %s", err.Error())
+	} else {
+		go v.serveControl(l)
+	}
+
+	if err := v.serveMetrics(kernelOpts.metricsAddr); err != nil {
+		logWarn("can not serve metrics: %s", err.Error())
+	}
+
+	// programs in all phases can use localhost
+	if err := setupLoopback(); err != nil {
+		logError("can not setup loopback: %s", err.Error())
+	}
+
+	// programs never see a writable root, vinitd remounts it for its own
+	// writes
+	if kernelOpts.readOnlyRoot {
+		if err := setRootReadOnly(); err != nil {
+			return err
+		}
+	}
+
+	v.launchPhase(phasePreNetwork)
+
 	errors := make(chan error)
 	wgDone := make(chan bool)
 
 	var wg sync.WaitGroup
 	wg.Add(3)
 
+	go v.watchNetwork(kernelOpts.networkReady, time.Duration(kernelOpts.networkTimeout)*time.Second)
+
 	go func() {
 		err = v.networkSetup()
 		if err != nil {
@@ -199,11 +301,23 @@ func (v *Vinitd) Setup() error {
 	}()
 
 	go func() {
-		err = etcGenerateFiles(v.hostname, v.user)
+		err = withWritableRoot("etc files", func() error {
+			return etcGenerateFiles(v.hostname, v.user)
+		})
 		if err != nil {
 			logError("error creating etc files: %s", err.Error())
 			errors <- err
+			wg.Done()
+			return
 		}
+
+		id, err := setupMachineID(kernelOpts.machineID, v.hostname)
+		if err != nil {
+			logError("can not create machine id: %s", err.Error())
+		}
+		v.machineIDLock.Lock()
+		v.machineID = id
+		v.machineIDLock.Unlock()
 		wg.Done()
 	}()
 
@@ -222,9 +336,7 @@ func (v *Vinitd) Setup() error {
 		SystemPanic("system setup failed: %s", err.Error())
 	}
 
-	for _, p := range v.vcfg.Programs {
-		v.prepProgram(p)
-	}
+	v.launchPhase(phasePostNetwork)
 
 	logDebug("system setup successful")
 
@@ -242,6 +354,24 @@ func (v *Vinitd) PostSetup() error {
 		logWarn("can not start local DNS server")
 	}
 
+	v.dnsSearch = append(v.dnsSearch, kernelOpts.dnsSearch...)
+	dnsErr := err
+	err = withWritableRoot("resolv.conf", func() error {
+		return v.writeResolvConf(dnsErr == nil)
+	})
+	if err != nil {
+		logError("can not write %s: %s", resolvConfFile, err.Error())
+	}
+
+	// step the clock before chronyd and programs start
+	syncClock(v.vcfg.System.NTP)
+
+	if v.vcfg.System.Hostname == "" && v.dhcpHostname != "" {
+		if err := v.changeHostname(v.dhcpHostname); err != nil {
+			logWarn("can not use hostname %s from dhcp: %s", v.dhcpHostname, err.Error())
+		}
+	}
+
 	errors := make(chan error)
 	wgDone := make(chan bool)
 	var wg sync.WaitGroup
@@ -279,7 +409,7 @@ func (v *Vinitd) PostSetup() error {
 
 	// prepare shell if --shell is provided
 	go func() {
-		err := runBusyboxScript()
+		err := withWritableRoot("busybox", runBusyboxScript)
 		if err != nil {
 			errors <- err
 		}
@@ -321,11 +451,10 @@ func waitForSignal() {
 
 	sig := <-killSignal
 
-	logAlways("got signal %d", sig)
 	if sig == syscall.SIGPWR {
-		shutdown(syscall.LINUX_REBOOT_CMD_POWER_OFF, 0)
+		Poweroff(fmt.Sprintf("got signal %s", sig))
 	} else {
-		shutdown(syscall.LINUX_REBOOT_CMD_RESTART, 0)
+		Reboot(fmt.Sprintf("got signal %s", sig))
 	}
 
 }